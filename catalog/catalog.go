@@ -0,0 +1,117 @@
+// Package catalog holds the species and breed reference data pets are validated
+// against. Names resolve exactly, through aliases ("canine" -> "Dog") or, when
+// nothing matches, produce fuzzy suggestions ("Labrador Retreiver").
+package catalog
+
+import (
+	_ "embed"
+	"encoding/json"
+	"io"
+	"strings"
+	"unicode"
+)
+
+//go:embed seed.json
+var seedJSON []byte
+
+type Species struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases,omitempty"`
+	Breeds  []Breed  `json:"breeds,omitempty"`
+}
+
+type Breed struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+type Catalog struct {
+	Species []Species `json:"species"`
+}
+
+// Match is the result of resolving a name. Name is set when the input matched a
+// name or alias; otherwise Suggestions lists the closest names. Closest is set when
+// one suggestion is clearly nearer than the rest (within two edits).
+type Match struct {
+	Name        string   `json:"name,omitempty"`
+	Suggestions []string `json:"suggestions,omitempty"`
+	Closest     string   `json:"-"`
+}
+
+// Seed returns the catalog bundled with the application
+func Seed() (*Catalog, error) {
+	return Parse(strings.NewReader(string(seedJSON)))
+}
+
+// Parse reads a catalog in the seed file format
+func Parse(r io.Reader) (*Catalog, error) {
+	var c Catalog
+	if err := json.NewDecoder(r).Decode(&c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Key folds a name for comparison: lower case, letters and digits only, single spaces
+func Key(name string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteRune(r)
+		default:
+			space = true
+		}
+	}
+	return b.String()
+}
+
+func (c *Catalog) species(name string) *Species {
+	key := Key(name)
+	for i := range c.Species {
+		if Key(c.Species[i].Name) == key {
+			return &c.Species[i]
+		}
+	}
+	return nil
+}
+
+// ResolveSpecies maps input to a catalog species
+func (c *Catalog) ResolveSpecies(input string) Match {
+	var entries []entry
+	for _, s := range c.Species {
+		entries = append(entries, entry{s.Name, s.Name})
+		for _, a := range s.Aliases {
+			entries = append(entries, entry{a, s.Name})
+		}
+	}
+	return resolve(input, entries)
+}
+
+// HasBreeds reports whether the catalog lists breeds for species; breeds of
+// species without a list are accepted as free text
+func (c *Catalog) HasBreeds(species string) bool {
+	s := c.species(species)
+	return s != nil && len(s.Breeds) > 0
+}
+
+// ResolveBreed maps input to a breed of the given (canonical) species
+func (c *Catalog) ResolveBreed(species, input string) Match {
+	s := c.species(species)
+	if s == nil {
+		return Match{}
+	}
+	var entries []entry
+	for _, b := range s.Breeds {
+		entries = append(entries, entry{b.Name, b.Name})
+		for _, a := range b.Aliases {
+			entries = append(entries, entry{a, b.Name})
+		}
+	}
+	return resolve(input, entries)
+}
@@ -0,0 +1,103 @@
+package catalog
+
+import (
+	"sort"
+	"strings"
+)
+
+const maxSuggestions = 5
+
+// entry is a name or alias and the canonical name it stands for
+type entry struct {
+	text      string
+	canonical string
+}
+
+func resolve(input string, entries []entry) Match {
+	key := Key(input)
+	if key == "" {
+		return Match{}
+	}
+	for _, e := range entries {
+		if Key(e.text) == key {
+			return Match{Name: e.canonical}
+		}
+	}
+
+	// best distance per canonical name, over the name and all its aliases
+	best := map[string]int{}
+	for _, e := range entries {
+		ek := Key(e.text)
+		d := distance(key, ek)
+		limit := len([]rune(ek)) / 3
+		if limit < 2 {
+			limit = 2
+		}
+		switch {
+		case d <= limit:
+		case len(key) >= 3 && (strings.Contains(ek, key) || strings.Contains(key, ek) && len(ek) >= 3):
+			// substring hits ("retriever") rank after typo-close names
+			d = limit + 1 + abs(len(ek)-len(key))
+		default:
+			continue
+		}
+		if old, ok := best[e.canonical]; !ok || d < old {
+			best[e.canonical] = d
+		}
+	}
+
+	names := make([]string, 0, len(best))
+	for name := range best {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if best[names[i]] != best[names[j]] {
+			return best[names[i]] < best[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	m := Match{}
+	if len(names) > 0 && best[names[0]] <= 2 && (len(names) == 1 || best[names[1]] > best[names[0]]) {
+		m.Closest = names[0]
+	}
+	if len(names) > maxSuggestions {
+		names = names[:maxSuggestions]
+	}
+	m.Suggestions = names
+	return m
+}
+
+// distance is the Damerau-Levenshtein (optimal string alignment) edit distance,
+// so swapped letters as in "retreiver" count as a single edit
+func distance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev2 := make([]int, len(t)+1)
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && s[i-1] == t[j-2] && s[i-2] == t[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(t)]
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
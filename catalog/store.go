@@ -0,0 +1,203 @@
+package catalog
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"pet-clinic/db"
+	"pet-clinic/utils"
+
+	"github.com/lib/pq"
+)
+
+// cacheTTL bounds how stale the in-memory catalog may get when another
+// instance edits it; edits made through this process call Invalidate
+const cacheTTL = 5 * time.Minute
+
+var (
+	cacheMu  sync.Mutex
+	cached   *Catalog
+	cachedAt time.Time
+)
+
+// Current returns the catalog stored in the database, cached for a few minutes
+func Current() (*Catalog, error) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	if cached != nil && time.Since(cachedAt) < cacheTTL {
+		return cached, nil
+	}
+	c, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	cached, cachedAt = c, time.Now()
+	return c, nil
+}
+
+// Invalidate drops the cached catalog after an edit
+func Invalidate() {
+	cacheMu.Lock()
+	cached = nil
+	cacheMu.Unlock()
+}
+
+// Load reads species, breeds and their aliases from the database
+func Load() (*Catalog, error) {
+	c := &Catalog{}
+	index := map[int]int{}
+	rows, err := db.DB.Query(`SELECT s.id, s.name,
+			COALESCE(ARRAY(SELECT alias FROM species_aliases a WHERE a.species_id = s.id ORDER BY alias), '{}')
+		FROM species s ORDER BY s.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var s Species
+		if err := rows.Scan(&id, &s.Name, pq.Array(&s.Aliases)); err != nil {
+			return nil, err
+		}
+		index[id] = len(c.Species)
+		c.Species = append(c.Species, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	breeds, err := db.DB.Query(`SELECT b.species_id, b.name,
+			COALESCE(ARRAY(SELECT alias FROM breed_aliases a WHERE a.breed_id = b.id ORDER BY alias), '{}')
+		FROM breeds b ORDER BY b.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer breeds.Close()
+	for breeds.Next() {
+		var speciesID int
+		var b Breed
+		if err := breeds.Scan(&speciesID, &b.Name, pq.Array(&b.Aliases)); err != nil {
+			return nil, err
+		}
+		if i, ok := index[speciesID]; ok {
+			c.Species[i].Breeds = append(c.Species[i].Breeds, b)
+		}
+	}
+	return c, breeds.Err()
+}
+
+// Import adds every species, breed and alias of c that the database does not
+// have yet; existing rows are left alone, so it is safe to re-run
+func Import(c *Catalog) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, s := range c.Species {
+		if err := importSpecies(tx, s, false); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	Invalidate()
+	return nil
+}
+
+// AliasConflictError reports an alias that already maps to another species or breed
+type AliasConflictError struct {
+	Alias string
+	Name  string // what the alias maps to now
+}
+
+func (e *AliasConflictError) Error() string {
+	return fmt.Sprintf("alias %q already maps to %s", e.Alias, e.Name)
+}
+
+// Add stores one species entry like Import, but an alias that already maps to
+// another species or breed is an *AliasConflictError and nothing is stored
+func Add(s Species) error {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := importSpecies(tx, s, true); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	Invalidate()
+	return nil
+}
+
+// importSpecies adds s, its breeds and aliases. Aliases taken by another name are
+// skipped, or reported when strict.
+func importSpecies(tx *sql.Tx, s Species, strict bool) error {
+	speciesID, err := upsertID(tx, `INSERT INTO species (name) VALUES ($1)
+		ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name RETURNING id`, s.Name)
+	if err != nil {
+		return err
+	}
+	for _, a := range s.Aliases {
+		// on conflict the existing row comes back, so its target can be compared
+		ownerID, err := upsertID(tx, `INSERT INTO species_aliases (alias, species_id) VALUES ($1, $2)
+			ON CONFLICT (alias) DO UPDATE SET alias = EXCLUDED.alias RETURNING species_id`, Key(a), speciesID)
+		if err != nil {
+			return err
+		}
+		if strict && ownerID != speciesID {
+			var name string
+			tx.QueryRow("SELECT name FROM species WHERE id=$1", ownerID).Scan(&name)
+			return &AliasConflictError{Alias: a, Name: name}
+		}
+	}
+	for _, b := range s.Breeds {
+		breedID, err := upsertID(tx, `INSERT INTO breeds (species_id, name) VALUES ($1, $2)
+			ON CONFLICT (species_id, name) DO UPDATE SET name = EXCLUDED.name RETURNING id`, speciesID, b.Name)
+		if err != nil {
+			return err
+		}
+		for _, a := range b.Aliases {
+			ownerID, err := upsertID(tx, `INSERT INTO breed_aliases (species_id, alias, breed_id) VALUES ($1, $2, $3)
+				ON CONFLICT (species_id, alias) DO UPDATE SET alias = EXCLUDED.alias RETURNING breed_id`, speciesID, Key(a), breedID)
+			if err != nil {
+				return err
+			}
+			if strict && ownerID != breedID {
+				var name string
+				tx.QueryRow("SELECT name FROM breeds WHERE id=$1", ownerID).Scan(&name)
+				return &AliasConflictError{Alias: a, Name: name}
+			}
+		}
+	}
+	return nil
+}
+
+// SeedIfEmpty imports the bundled seed file into an empty catalog
+func SeedIfEmpty() error {
+	var count int
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM species").Scan(&count); err != nil || count > 0 {
+		return err
+	}
+	seed, err := Seed()
+	if err != nil {
+		return err
+	}
+	utils.Log.Info("Species/breed catalog is empty, importing bundled seed")
+	return Import(seed)
+}
+
+func upsertID(tx *sql.Tx, query string, args ...interface{}) (int, error) {
+	var id int
+	err := tx.QueryRow(query, args...).Scan(&id)
+	return id, err
+}
@@ -0,0 +1,132 @@
+// Package charts renders small SVG charts without external dependencies.
+package charts
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"time"
+)
+
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// LineChart is a time series with an optional reference band (RangeMin..RangeMax)
+type LineChart struct {
+	Title    string
+	Unit     string
+	Points   []Point // oldest first
+	RangeMin *float64
+	RangeMax *float64
+	Width    int
+	Height   int
+}
+
+const (
+	marginLeft   = 60
+	marginRight  = 20
+	marginTop    = 40
+	marginBottom = 50
+)
+
+// WriteSVG renders the chart; points outside the reference band are drawn in red
+func (c *LineChart) WriteSVG(w io.Writer) error {
+	width, height := c.Width, c.Height
+	if width == 0 {
+		width = 640
+	}
+	if height == 0 {
+		height = 320
+	}
+	plotW := float64(width - marginLeft - marginRight)
+	plotH := float64(height - marginTop - marginBottom)
+
+	// value domain covers the data and the reference band, padded by 10%
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, p := range c.Points {
+		lo, hi = math.Min(lo, p.Value), math.Max(hi, p.Value)
+	}
+	if c.RangeMin != nil {
+		lo, hi = math.Min(lo, *c.RangeMin), math.Max(hi, *c.RangeMin)
+	}
+	if c.RangeMax != nil {
+		lo, hi = math.Min(lo, *c.RangeMax), math.Max(hi, *c.RangeMax)
+	}
+	if math.IsInf(lo, 1) {
+		lo, hi = 0, 1
+	}
+	if hi-lo < 1e-9 {
+		lo, hi = lo-1, hi+1
+	}
+	pad := (hi - lo) * 0.1
+	lo, hi = lo-pad, hi+pad
+
+	var start, end time.Time
+	if len(c.Points) > 0 {
+		start, end = c.Points[0].Time, c.Points[len(c.Points)-1].Time
+	}
+	if !end.After(start) {
+		start, end = start.Add(-24*time.Hour), start.Add(24*time.Hour)
+	}
+	span := end.Sub(start).Seconds()
+
+	x := func(t time.Time) float64 { return marginLeft + t.Sub(start).Seconds()/span*plotW }
+	y := func(v float64) float64 { return marginTop + (hi-v)/(hi-lo)*plotH }
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="Arial, sans-serif" font-size="11">`+"\n",
+		width, height, width, height)
+	fmt.Fprintf(bw, `<rect width="100%%" height="100%%" fill="#ffffff"/>`+"\n")
+	fmt.Fprintf(bw, `<text x="%d" y="22" font-size="14" font-weight="bold">%s</text>`+"\n", marginLeft, html.EscapeString(c.Title))
+
+	if c.RangeMin != nil && c.RangeMax != nil {
+		fmt.Fprintf(bw, `<rect x="%d" y="%.1f" width="%.1f" height="%.1f" fill="#2e7d32" fill-opacity="0.12"/>`+"\n",
+			marginLeft, y(*c.RangeMax), plotW, y(*c.RangeMin)-y(*c.RangeMax))
+	}
+
+	// horizontal grid with value labels
+	for i := 0; i <= 4; i++ {
+		v := lo + (hi-lo)*float64(i)/4
+		fmt.Fprintf(bw, `<line x1="%d" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#dddddd"/>`+"\n", marginLeft, y(v), marginLeft+plotW, y(v))
+		fmt.Fprintf(bw, `<text x="%d" y="%.1f" text-anchor="end">%.1f</text>`+"\n", marginLeft-6, y(v)+4, v)
+	}
+	fmt.Fprintf(bw, `<text x="14" y="%.1f" transform="rotate(-90 14 %.1f)" text-anchor="middle">%s</text>`+"\n",
+		marginTop+plotH/2, marginTop+plotH/2, html.EscapeString(c.Unit))
+
+	// date labels along the time axis
+	for i := 0; i <= 4; i++ {
+		t := start.Add(time.Duration(float64(end.Sub(start)) * float64(i) / 4))
+		fmt.Fprintf(bw, `<text x="%.1f" y="%.1f" text-anchor="middle">%s</text>`+"\n", x(t), marginTop+plotH+18, t.Format("02 Jan 06"))
+	}
+	fmt.Fprintf(bw, `<line x1="%d" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#333333"/>`+"\n", marginLeft, marginTop+plotH, marginLeft+plotW, marginTop+plotH)
+	fmt.Fprintf(bw, `<line x1="%d" y1="%d" x2="%d" y2="%.1f" stroke="#333333"/>`+"\n", marginLeft, marginTop, marginLeft, marginTop+plotH)
+
+	if len(c.Points) == 0 {
+		fmt.Fprintf(bw, `<text x="%.1f" y="%.1f" text-anchor="middle" fill="#888888">No data</text>`+"\n", marginLeft+plotW/2, marginTop+plotH/2)
+	} else {
+		bw.WriteString(`<polyline fill="none" stroke="#1565c0" stroke-width="2" points="`)
+		for i, p := range c.Points {
+			if i > 0 {
+				bw.WriteString(" ")
+			}
+			fmt.Fprintf(bw, "%.1f,%.1f", x(p.Time), y(p.Value))
+		}
+		bw.WriteString(`"/>` + "\n")
+
+		for _, p := range c.Points {
+			color := "#1565c0"
+			if (c.RangeMin != nil && p.Value < *c.RangeMin) || (c.RangeMax != nil && p.Value > *c.RangeMax) {
+				color = "#c62828"
+			}
+			fmt.Fprintf(bw, `<circle cx="%.1f" cy="%.1f" r="3.5" fill="%s"><title>%s: %.2f %s</title></circle>`+"\n",
+				x(p.Time), y(p.Value), color, p.Time.Format("2006-01-02"), p.Value, html.EscapeString(c.Unit))
+		}
+	}
+
+	bw.WriteString("</svg>\n")
+	return bw.Flush()
+}
@@ -3,7 +3,8 @@ CREATE TABLE owners (
     id SERIAL PRIMARY KEY,
     name VARCHAR(100) NOT NULL,
     contact VARCHAR(20),
-    email VARCHAR(100) UNIQUE
+    email VARCHAR(100) UNIQUE,
+    storage_quota_bytes BIGINT CHECK (storage_quota_bytes >= 0) -- overrides STORAGE_QUOTA_OWNER_BYTES
 );
 
 -- Pets table
@@ -13,7 +14,11 @@ CREATE TABLE pets (
     species VARCHAR(50),
     breed VARCHAR(50),
     owner_id INT NOT NULL REFERENCES owners(id) ON DELETE CASCADE,
-    medical_history TEXT
+    medical_history TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'active', -- active | deceased | transferred | lost
+    status_date DATE,
+    cause_of_death TEXT,
+    status_note TEXT
 );
 
 -- Appointments table
@@ -22,22 +27,566 @@ CREATE TABLE appointments (
     date DATE NOT NULL,
     time TIME NOT NULL,
     pet_id INT NOT NULL REFERENCES pets(id) ON DELETE CASCADE,
-    reason TEXT
+    reason TEXT,
+    vet VARCHAR(100) DEFAULT '',
+    appointment_type VARCHAR(50) DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'scheduled',
+    sequence INT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    closure_conflict_id INT
 );
 
+-- Waitlist entries (empty vets/appointment_types means "any")
+CREATE TABLE waitlist_entries (
+    id SERIAL PRIMARY KEY,
+    pet_id INT NOT NULL REFERENCES pets(id) ON DELETE CASCADE,
+    earliest_date DATE NOT NULL,
+    latest_date DATE NOT NULL,
+    vets TEXT[] NOT NULL DEFAULT '{}',
+    appointment_types TEXT[] NOT NULL DEFAULT '{}',
+    reason TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'waiting',
+    created_by VARCHAR(100),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Freed-up slots offered to waitlist entries, held until expires_at
+CREATE TABLE waitlist_offers (
+    id SERIAL PRIMARY KEY,
+    entry_id INT NOT NULL REFERENCES waitlist_entries(id) ON DELETE CASCADE,
+    date DATE NOT NULL,
+    time TIME NOT NULL,
+    vet VARCHAR(100) DEFAULT '',
+    appointment_type VARCHAR(50) DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    expires_at TIMESTAMP NOT NULL,
+    appointment_id INT REFERENCES appointments(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Reminder sends; one row per appointment (or vaccination due date)/offset/channel so nothing is sent twice
+CREATE TABLE reminder_sends (
+    id SERIAL PRIMARY KEY,
+    appointment_id INT REFERENCES appointments(id) ON DELETE CASCADE,
+    vaccination_id INT,
+    offset_minutes INT NOT NULL,
+    channel VARCHAR(20) NOT NULL,
+    recipient VARCHAR(100) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (appointment_id, offset_minutes, channel),
+    UNIQUE (vaccination_id, offset_minutes, channel)
+);
+
+-- Secret tokens for subscribable calendar feeds (scope 'vet' or 'owner');
+-- only the SHA-256 of the token is stored
+CREATE TABLE calendar_feed_tokens (
+    id SERIAL PRIMARY KEY,
+    token_hash CHAR(64) NOT NULL UNIQUE,
+    scope VARCHAR(10) NOT NULL,
+    subject VARCHAR(100) NOT NULL,
+    created_by VARCHAR(100),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    revoked_at TIMESTAMP
+);
+
+-- Walk-in / emergency check-ins; priority 1 (critical) .. 5 (non-urgent)
+CREATE TABLE checkins (
+    id SERIAL PRIMARY KEY,
+    pet_id INT REFERENCES pets(id) ON DELETE SET NULL,
+    patient_name VARCHAR(100) NOT NULL,
+    complaint TEXT,
+    priority INT NOT NULL DEFAULT 3 CHECK (priority BETWEEN 1 AND 5),
+    assigned_vet VARCHAR(100) DEFAULT '',
+    status VARCHAR(20) NOT NULL DEFAULT 'waiting',
+    arrived_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    called_at TIMESTAMP,
+    completed_at TIMESTAMP,
+    created_by VARCHAR(100)
+);
+
+-- Regular opening hours; weekday 0 = Sunday, several rows per day allowed (e.g. lunch break)
+CREATE TABLE opening_hours (
+    id SERIAL PRIMARY KEY,
+    weekday INT NOT NULL CHECK (weekday BETWEEN 0 AND 6),
+    opens TIME NOT NULL,
+    closes TIME NOT NULL CHECK (closes > opens)
+);
+
+-- Holidays (imported from iCal, keyed by source_uid) and ad-hoc closures, in clinic local time
+CREATE TABLE clinic_closures (
+    id SERIAL PRIMARY KEY,
+    starts_at TIMESTAMP NOT NULL,
+    ends_at TIMESTAMP NOT NULL CHECK (ends_at > starts_at),
+    reason TEXT NOT NULL,
+    kind VARCHAR(20) NOT NULL DEFAULT 'adhoc',
+    source_uid VARCHAR(255) UNIQUE,
+    created_by VARCHAR(100),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE appointments
+    ADD FOREIGN KEY (closure_conflict_id) REFERENCES clinic_closures(id) ON DELETE SET NULL;
+
+-- Medical records: one row per visit/encounter with SOAP notes. Rows are append-only;
+-- corrections go into visit_amendments. kind = 'visit' or 'legacy' (migrated medical_history)
+CREATE TABLE visits (
+    id SERIAL PRIMARY KEY,
+    pet_id INT NOT NULL REFERENCES pets(id) ON DELETE RESTRICT,
+    appointment_id INT REFERENCES appointments(id) ON DELETE RESTRICT,
+    visit_date DATE NOT NULL DEFAULT CURRENT_DATE,
+    vet VARCHAR(100) DEFAULT '',
+    kind VARCHAR(20) NOT NULL DEFAULT 'visit',
+    subjective TEXT NOT NULL DEFAULT '',
+    objective TEXT NOT NULL DEFAULT '',
+    assessment TEXT NOT NULL DEFAULT '',
+    plan TEXT NOT NULL DEFAULT '',
+    created_by VARCHAR(100),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- NULL columns are left unchanged by the amendment
+CREATE TABLE visit_amendments (
+    id SERIAL PRIMARY KEY,
+    visit_id INT NOT NULL REFERENCES visits(id) ON DELETE RESTRICT,
+    subjective TEXT,
+    objective TEXT,
+    assessment TEXT,
+    plan TEXT,
+    reason TEXT NOT NULL,
+    amended_by VARCHAR(100),
+    amended_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE FUNCTION forbid_record_change() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION '% is append-only; add an amendment instead', TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER visits_append_only BEFORE UPDATE OR DELETE ON visits
+    FOR EACH ROW EXECUTE FUNCTION forbid_record_change();
+CREATE TRIGGER visit_amendments_append_only BEFORE UPDATE OR DELETE ON visit_amendments
+    FOR EACH ROW EXECUTE FUNCTION forbid_record_change();
+
+-- Vaccine catalog; one row per vaccine and species with its schedule
+CREATE TABLE vaccines (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    species VARCHAR(50) NOT NULL,
+    core BOOLEAN NOT NULL DEFAULT TRUE,
+    first_dose_age_weeks INT NOT NULL DEFAULT 8,
+    initial_doses INT NOT NULL DEFAULT 1,
+    initial_interval_days INT NOT NULL DEFAULT 0,
+    booster_interval_days INT NOT NULL DEFAULT 365,
+    UNIQUE (name, species)
+);
+
+CREATE TABLE vaccinations (
+    id SERIAL PRIMARY KEY,
+    pet_id INT NOT NULL REFERENCES pets(id) ON DELETE RESTRICT,
+    vaccine_id INT NOT NULL REFERENCES vaccines(id),
+    visit_id INT REFERENCES visits(id),
+    administered_on DATE NOT NULL,
+    dose_number INT NOT NULL DEFAULT 1,
+    product VARCHAR(100),
+    lot_number VARCHAR(50),
+    administering_vet VARCHAR(100),
+    next_due_date DATE,
+    created_by VARCHAR(100),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE reminder_sends
+    ADD FOREIGN KEY (vaccination_id) REFERENCES vaccinations(id) ON DELETE CASCADE;
+
+CREATE TABLE prescriptions (
+    id SERIAL PRIMARY KEY,
+    pet_id INT NOT NULL REFERENCES pets(id) ON DELETE RESTRICT,
+    visit_id INT NOT NULL REFERENCES visits(id),
+    drug VARCHAR(100) NOT NULL,
+    drug_class VARCHAR(100) DEFAULT '',
+    dose VARCHAR(50) NOT NULL,
+    frequency VARCHAR(50) NOT NULL,
+    route VARCHAR(30) NOT NULL,
+    duration_days INT,
+    refills_allowed INT NOT NULL DEFAULT 0,
+    refills_used INT NOT NULL DEFAULT 0 CHECK (refills_used <= refills_allowed),
+    instructions TEXT,
+    prescribing_vet VARCHAR(100) NOT NULL,
+    allergy_override TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'active',
+    created_by VARCHAR(100),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Medication administration record (MAR) for inpatients
+CREATE TABLE medication_administrations (
+    id SERIAL PRIMARY KEY,
+    prescription_id INT NOT NULL REFERENCES prescriptions(id),
+    administered_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    dose_given VARCHAR(50) NOT NULL,
+    route VARCHAR(30),
+    outcome VARCHAR(20) NOT NULL DEFAULT 'given',
+    notes TEXT,
+    administered_by VARCHAR(100) NOT NULL
+);
+
+CREATE TABLE refill_requests (
+    id SERIAL PRIMARY KEY,
+    prescription_id INT NOT NULL REFERENCES prescriptions(id),
+    note TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    requested_by VARCHAR(100) NOT NULL,
+    requested_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    decided_by VARCHAR(100),
+    decided_at TIMESTAMP,
+    decision_note TEXT
+);
+CREATE UNIQUE INDEX refill_requests_one_pending ON refill_requests (prescription_id) WHERE status = 'pending';
+
+-- Allergies, chronic conditions and handling alerts shown on every pet read.
+-- drug_class lets prescriptions be checked against allergies
+CREATE TABLE pet_alerts (
+    id SERIAL PRIMARY KEY,
+    pet_id INT NOT NULL REFERENCES pets(id) ON DELETE RESTRICT,
+    kind VARCHAR(20) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    drug_class VARCHAR(100) DEFAULT '',
+    severity VARCHAR(20) NOT NULL DEFAULT 'moderate',
+    notes TEXT,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_by VARCHAR(100),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    resolved_at TIMESTAMP
+);
+
+-- Vitals are stored in canonical units (kg, °C, beats/breaths per minute)
+CREATE TABLE vitals (
+    id SERIAL PRIMARY KEY,
+    pet_id INT NOT NULL REFERENCES pets(id) ON DELETE RESTRICT,
+    visit_id INT REFERENCES visits(id),
+    recorded_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    weight_kg NUMERIC(6, 2),
+    temperature_c NUMERIC(4, 1),
+    heart_rate_bpm INT,
+    respiratory_rate_bpm INT,
+    recorded_by VARCHAR(100)
+);
+
+-- Reference ranges by species, optionally narrowed by breed (breed '' = whole species)
+CREATE TABLE vital_reference_ranges (
+    id SERIAL PRIMARY KEY,
+    species VARCHAR(50) NOT NULL,
+    breed VARCHAR(50) NOT NULL DEFAULT '',
+    measure VARCHAR(30) NOT NULL,
+    min_value NUMERIC(8, 2) NOT NULL,
+    max_value NUMERIC(8, 2) NOT NULL,
+    UNIQUE (species, breed, measure)
+);
+
+-- Lab orders; the accession number is printed on the sample and echoed back by the analyzer
+CREATE SEQUENCE lab_accession_seq;
+
+CREATE TABLE lab_orders (
+    id SERIAL PRIMARY KEY,
+    pet_id INT NOT NULL REFERENCES pets(id) ON DELETE RESTRICT,
+    visit_id INT REFERENCES visits(id),
+    accession VARCHAR(30) NOT NULL UNIQUE DEFAULT 'LAB-' || lpad(nextval('lab_accession_seq')::text, 6, '0'),
+    panel VARCHAR(100) NOT NULL,
+    notes TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'ordered', -- ordered | resulted | cancelled
+    ordered_by VARCHAR(100),
+    ordered_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    resulted_at TIMESTAMP
+);
+
+-- Every result file received, kept verbatim; unmatched files wait for staff to assign them
+CREATE TABLE lab_imports (
+    id SERIAL PRIMARY KEY,
+    filename VARCHAR(255) NOT NULL,
+    format VARCHAR(10) NOT NULL,
+    source VARCHAR(10) NOT NULL, -- upload | inbox
+    content TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL, -- matched | unmatched | failed
+    unmatched TEXT[] NOT NULL DEFAULT '{}', -- accessions (or pet ids) with no order yet
+    error TEXT,
+    imported_by VARCHAR(100),
+    received_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE lab_results (
+    id SERIAL PRIMARY KEY,
+    order_id INT NOT NULL REFERENCES lab_orders(id),
+    import_id INT NOT NULL REFERENCES lab_imports(id),
+    code VARCHAR(30) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    value VARCHAR(50) NOT NULL,
+    numeric_value NUMERIC(12, 4),
+    units VARCHAR(30),
+    ref_low NUMERIC(12, 4),
+    ref_high NUMERIC(12, 4),
+    flag VARCHAR(5) NOT NULL DEFAULT '', -- '' normal | L | H | LL | HH | A
+    received_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Every lifecycle change of a pet; records are kept, pets are never deleted for these
+CREATE TABLE pet_status_changes (
+    id SERIAL PRIMARY KEY,
+    pet_id INT NOT NULL REFERENCES pets(id) ON DELETE RESTRICT,
+    status VARCHAR(20) NOT NULL,
+    status_date DATE,
+    cause_of_death TEXT,
+    note TEXT,
+    changed_by VARCHAR(100),
+    changed_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Everyone responsible for a pet. pets.owner_id mirrors the current primary owner;
+-- rows are ended (removed_at) rather than deleted so ownership history survives
+CREATE TABLE pet_owners (
+    id SERIAL PRIMARY KEY,
+    pet_id INT NOT NULL REFERENCES pets(id) ON DELETE CASCADE,
+    owner_id INT NOT NULL REFERENCES owners(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL, -- primary | co-owner | caregiver
+    added_by VARCHAR(100),
+    added_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    removed_by VARCHAR(100),
+    removed_at TIMESTAMP
+);
+CREATE UNIQUE INDEX pet_owners_current ON pet_owners (pet_id, owner_id) WHERE removed_at IS NULL;
+CREATE UNIQUE INDEX pet_owners_one_primary ON pet_owners (pet_id) WHERE role = 'primary' AND removed_at IS NULL;
+
+-- Ownership transfers: initiated by the primary owner, accepted by the new owner
+CREATE TABLE pet_transfers (
+    id SERIAL PRIMARY KEY,
+    pet_id INT NOT NULL REFERENCES pets(id) ON DELETE CASCADE,
+    from_owner_id INT NOT NULL REFERENCES owners(id) ON DELETE CASCADE,
+    to_owner_id INT NOT NULL REFERENCES owners(id) ON DELETE CASCADE,
+    note TEXT,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending | accepted | declined | cancelled
+    initiated_by VARCHAR(100),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    decided_by VARCHAR(100),
+    decided_at TIMESTAMP
+);
+CREATE UNIQUE INDEX pet_transfers_one_pending ON pet_transfers (pet_id) WHERE status = 'pending';
+
+-- Microchips, tattoos, license and rabies tags; values are stored normalized
+-- (microchips as 15 digits) and each one belongs to exactly one pet
+CREATE TABLE pet_identifiers (
+    id SERIAL PRIMARY KEY,
+    pet_id INT NOT NULL REFERENCES pets(id) ON DELETE CASCADE,
+    kind VARCHAR(20) NOT NULL, -- microchip | tattoo | license_tag | rabies_tag
+    value VARCHAR(40) NOT NULL,
+    issuer VARCHAR(100) NOT NULL DEFAULT '', -- e.g. licensing authority; '' for microchips
+    notes TEXT,
+    added_by VARCHAR(100),
+    added_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (kind, issuer, value)
+);
+CREATE INDEX pet_identifiers_value ON pet_identifiers (value);
+
+-- Species and breed catalog that pet species/breed are validated against.
+-- Aliases are stored folded (lower case, letters/digits, single spaces)
+CREATE TABLE species (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(50) NOT NULL UNIQUE
+);
+
+CREATE TABLE species_aliases (
+    alias VARCHAR(50) PRIMARY KEY,
+    species_id INT NOT NULL REFERENCES species(id) ON DELETE CASCADE
+);
+
+CREATE TABLE breeds (
+    id SERIAL PRIMARY KEY,
+    species_id INT NOT NULL REFERENCES species(id) ON DELETE CASCADE,
+    name VARCHAR(50) NOT NULL,
+    UNIQUE (species_id, name)
+);
+
+CREATE TABLE breed_aliases (
+    species_id INT NOT NULL REFERENCES species(id) ON DELETE CASCADE,
+    alias VARCHAR(50) NOT NULL,
+    breed_id INT NOT NULL REFERENCES breeds(id) ON DELETE CASCADE,
+    PRIMARY KEY (species_id, alias)
+);
+
+-- Uploaded files (x-rays, reports) linked to a pet and optionally a visit. The id is
+-- server-generated and is the only name used in storage; the client's filename is metadata.
+-- owner_id is the pet's primary owner at upload time
+CREATE TABLE attachments (
+    id CHAR(32) PRIMARY KEY,
+    pet_id INT NOT NULL REFERENCES pets(id) ON DELETE RESTRICT,
+    owner_id INT REFERENCES owners(id) ON DELETE SET NULL,
+    visit_id INT REFERENCES visits(id),
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    sha256 CHAR(64) NOT NULL,
+    description TEXT,
+    uploaded_by VARCHAR(100),
+    uploaded_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    -- the current version; filename .. uploaded_at above describe it
+    version INT NOT NULL DEFAULT 1,
+    blob_key CHAR(32) NOT NULL,
+    -- thumbnails/previews live under previews/<blob_key>/<variant>; made by the preview worker
+    preview_status VARCHAR(20) NOT NULL DEFAULT 'none'
+        CHECK (preview_status IN ('none', 'pending', 'processing', 'ready', 'failed')),
+    preview_error TEXT,
+    preview_claimed_at TIMESTAMP
+);
+CREATE INDEX attachments_pet ON attachments (pet_id, uploaded_at);
+CREATE INDEX attachments_preview_queue ON attachments (uploaded_at) WHERE preview_status IN ('pending', 'processing');
+
+-- every version of an attachment; a restore adds a version pointing at an older blob
+CREATE TABLE attachment_versions (
+    id SERIAL PRIMARY KEY,
+    attachment_id CHAR(32) NOT NULL REFERENCES attachments(id) ON DELETE CASCADE,
+    version INT NOT NULL,
+    blob_key CHAR(32) NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    sha256 CHAR(64) NOT NULL,
+    restored_from INT,
+    uploaded_by VARCHAR(100),
+    uploaded_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (attachment_id, version)
+);
+
+-- signed, expiring download links for sharing one file without a login
+CREATE TABLE download_links (
+    id SERIAL PRIMARY KEY,
+    attachment_id CHAR(32) NOT NULL REFERENCES attachments(id) ON DELETE CASCADE,
+    recipient TEXT,
+    expires_at TIMESTAMP NOT NULL,
+    max_downloads INT CHECK (max_downloads > 0),
+    download_count INT NOT NULL DEFAULT 0,
+    created_by VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    revoked_by VARCHAR(100),
+    revoked_at TIMESTAMP
+);
+CREATE INDEX download_links_attachment ON download_links (attachment_id);
+
+-- every request made with a download link, served or refused
+CREATE TABLE download_link_accesses (
+    id SERIAL PRIMARY KEY,
+    link_id INT NOT NULL REFERENCES download_links(id) ON DELETE CASCADE,
+    outcome VARCHAR(20) NOT NULL
+        CHECK (outcome IN ('served', 'interrupted', 'expired', 'revoked', 'limit_reached')),
+    remote_addr VARCHAR(100),
+    user_agent TEXT,
+    accessed_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX download_link_accesses_link ON download_link_accesses (link_id, accessed_at);
+
+-- resumable (tus) uploads in progress; each received chunk is a blob under tus/<id>/
+CREATE TABLE upload_sessions (
+    id CHAR(32) PRIMARY KEY,
+    pet_id INT NOT NULL REFERENCES pets(id) ON DELETE CASCADE,
+    visit_id INT REFERENCES visits(id),
+    filename VARCHAR(255) NOT NULL,
+    description TEXT,
+    expected_sha256 CHAR(64),
+    upload_length BIGINT NOT NULL CHECK (upload_length >= 0),
+    upload_offset BIGINT NOT NULL DEFAULT 0,
+    parts TEXT[] NOT NULL DEFAULT '{}',
+    created_by VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    attachment_id CHAR(32) REFERENCES attachments(id) ON DELETE SET NULL,
+    -- set while one request turns the finished upload into an attachment
+    completing_since TIMESTAMP,
+    -- set when the upload is a new version of an existing attachment
+    version_of CHAR(32) REFERENCES attachments(id) ON DELETE CASCADE
+);
+CREATE INDEX upload_sessions_expires ON upload_sessions (expires_at);
+
+-- uploads the malware scanner flagged; the content is kept under quarantine/<id> for review
+CREATE TABLE quarantined_files (
+    id CHAR(32) PRIMARY KEY,
+    pet_id INT REFERENCES pets(id) ON DELETE SET NULL,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    sha256 CHAR(64) NOT NULL,
+    signature TEXT NOT NULL,
+    uploaded_by VARCHAR(100),
+    quarantined_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- ZIP archives of a pet's whole record, built by the background worker and kept
+-- under archives/ until expires_at
+CREATE TABLE archive_jobs (
+    id SERIAL PRIMARY KEY,
+    pet_id INT NOT NULL REFERENCES pets(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'processing', 'ready', 'failed', 'expired')),
+    filename VARCHAR(255) NOT NULL,
+    blob_key VARCHAR(100),
+    size_bytes BIGINT,
+    sha256 CHAR(64),
+    error TEXT,
+    requested_by VARCHAR(100) NOT NULL,
+    claimed_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMP,
+    expires_at TIMESTAMP
+);
+CREATE INDEX archive_jobs_queue ON archive_jobs (created_at) WHERE status IN ('pending', 'processing');
+
 INSERT INTO owners (name, contact, email) VALUES
 ('Ganesh', '9876543210', 'john.doe@gmail.com'),
 ('Riya', '9998877665', 'priya.sharma@gmail.com'),
 ('Ravi Kumar', '8899001122', 'ravi.kumar@yahoo.com');
 
 INSERT INTO pets (name, species, breed, owner_id, medical_history) VALUES
-('Bruno', 'Dog', 'Labrador', 1, 'Vaccinated and dewormed'),
+('Bruno', 'Dog', 'Labrador Retriever', 1, 'Vaccinated and dewormed'),
 ('Misty', 'Cat', 'Persian', 2, 'Allergic to certain foods'),
 ('Rocky', 'Dog', 'German Shepherd', 3, 'Hip dysplasia treatment ongoing'),
 ('Simba', 'Cat', 'Maine Coon', 2, 'Neutered last month');
 
+INSERT INTO pet_owners (pet_id, owner_id, role, added_by)
+SELECT id, owner_id, 'primary', 'setup' FROM pets;
+
 INSERT INTO appointments (date, time, pet_id, reason) VALUES
 ('2025-10-30', '10:00', 1, 'Routine check-up'),
 ('2025-10-31', '16:00', 2, 'Vaccination booster'),
 ('2025-11-01', '09:30', 3, 'Follow-up on hip treatment');
 
+INSERT INTO opening_hours (weekday, opens, closes) VALUES
+(1, '09:00', '18:00'),
+(2, '09:00', '18:00'),
+(3, '09:00', '18:00'),
+(4, '09:00', '18:00'),
+(5, '09:00', '18:00'),
+(6, '09:00', '13:00');
+
+INSERT INTO vaccines (name, species, core, first_dose_age_weeks, initial_doses, initial_interval_days, booster_interval_days) VALUES
+('Rabies', 'Dog', TRUE, 12, 1, 0, 365),
+('DHPP', 'Dog', TRUE, 8, 3, 21, 365),
+('Leptospirosis', 'Dog', FALSE, 12, 2, 21, 365),
+('Bordetella', 'Dog', FALSE, 8, 1, 0, 365),
+('Rabies', 'Cat', TRUE, 12, 1, 0, 365),
+('FVRCP', 'Cat', TRUE, 8, 3, 21, 365),
+('FeLV', 'Cat', FALSE, 8, 2, 21, 365);
+
+INSERT INTO vital_reference_ranges (species, breed, measure, min_value, max_value) VALUES
+('Dog', '', 'temperature_c', 37.5, 39.2),
+('Dog', '', 'heart_rate_bpm', 60, 140),
+('Dog', '', 'respiratory_rate_bpm', 10, 35),
+('Dog', 'Labrador Retriever', 'weight_kg', 25, 36),
+('Dog', 'German Shepherd', 'weight_kg', 22, 40),
+('Cat', '', 'temperature_c', 38.0, 39.2),
+('Cat', '', 'heart_rate_bpm', 140, 220),
+('Cat', '', 'respiratory_rate_bpm', 20, 30),
+('Cat', '', 'weight_kg', 3, 6),
+('Cat', 'Persian', 'weight_kg', 3, 5.5),
+('Cat', 'Maine Coon', 'weight_kg', 5, 11);
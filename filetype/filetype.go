@@ -0,0 +1,121 @@
+// Package filetype recognizes uploaded files by their leading bytes rather than
+// by the name the client sent, and holds the configurable allow-list.
+package filetype
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// HeaderSize is how many leading bytes Detect needs (DICOM puts its magic at 128)
+const HeaderSize = 132
+
+// Type is a file format the clinic knows how to recognize
+type Type struct {
+	Name       string
+	MIME       string
+	Extensions []string // lower case, with the dot; the first one is canonical
+	match      func(head []byte) bool
+}
+
+func prefix(magic ...string) func([]byte) bool {
+	return func(head []byte) bool {
+		for _, m := range magic {
+			if bytes.HasPrefix(head, []byte(m)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Known lists every recognized format; Allowed picks from these by name
+var Known = []Type{
+	{Name: "pdf", MIME: "application/pdf", Extensions: []string{".pdf"}, match: prefix("%PDF-")},
+	{Name: "jpeg", MIME: "image/jpeg", Extensions: []string{".jpg", ".jpeg", ".jpe"}, match: prefix("\xff\xd8\xff")},
+	{Name: "png", MIME: "image/png", Extensions: []string{".png"}, match: prefix("\x89PNG\r\n\x1a\n")},
+	{Name: "gif", MIME: "image/gif", Extensions: []string{".gif"}, match: prefix("GIF87a", "GIF89a")},
+	{Name: "tiff", MIME: "image/tiff", Extensions: []string{".tif", ".tiff"}, match: prefix("II*\x00", "MM\x00*")},
+	{Name: "webp", MIME: "image/webp", Extensions: []string{".webp"}, match: func(head []byte) bool {
+		return len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WEBP"
+	}},
+	// ISO base media files carry "ftyp" and a brand at offset 4; QuickTime's brand is "qt  "
+	{Name: "mov", MIME: "video/quicktime", Extensions: []string{".mov", ".qt"}, match: func(head []byte) bool {
+		return len(head) >= 12 && string(head[4:8]) == "ftyp" && string(head[8:12]) == "qt  "
+	}},
+	{Name: "mp4", MIME: "video/mp4", Extensions: []string{".mp4", ".m4v"}, match: func(head []byte) bool {
+		return len(head) >= 12 && string(head[4:8]) == "ftyp" && mp4Brands[string(head[8:12])]
+	}},
+	{Name: "webm", MIME: "video/webm", Extensions: []string{".webm"}, match: prefix("\x1a\x45\xdf\xa3")},
+	// DICOM Part 10: a 128-byte preamble followed by "DICM"
+	{Name: "dicom", MIME: "application/dicom", Extensions: []string{".dcm", ".dicom"}, match: func(head []byte) bool {
+		return len(head) >= 132 && string(head[128:132]) == "DICM"
+	}},
+}
+
+// mp4Brands are the major brands of MP4 video; HEIF images share the box
+// layout under brands such as "heic" and are not video
+var mp4Brands = map[string]bool{
+	"isom": true, "iso2": true, "iso4": true, "iso5": true, "iso6": true,
+	"mp41": true, "mp42": true, "avc1": true, "M4V ": true, "mmp4": true, "dash": true,
+}
+
+// DefaultAllowed is used when UPLOAD_ALLOWED_TYPES is not set
+const DefaultAllowed = "pdf,jpeg,png,dicom"
+
+// Detect returns the format whose signature head starts with
+func Detect(head []byte) (Type, bool) {
+	for _, t := range Known {
+		if t.match(head) {
+			return t, true
+		}
+	}
+	return Type{}, false
+}
+
+// Lookup finds a known format by name
+func Lookup(name string) (Type, bool) {
+	for _, t := range Known {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return Type{}, false
+}
+
+// Allowed returns the format names accepted for upload, from the comma-separated
+// UPLOAD_ALLOWED_TYPES (e.g. "pdf,jpeg,png,dicom"); unknown names are ignored
+func Allowed() map[string]bool {
+	list := os.Getenv("UPLOAD_ALLOWED_TYPES")
+	if strings.TrimSpace(list) == "" {
+		list = DefaultAllowed
+	}
+	allowed := map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "jpg" {
+			name = "jpeg"
+		}
+		if _, ok := Lookup(name); ok {
+			allowed[name] = true
+		}
+	}
+	return allowed
+}
+
+// MatchesName reports whether filename's extension fits t. A name without an
+// extension matches anything, since DICOM exports often have none.
+func (t Type) MatchesName(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext == "" {
+		return true
+	}
+	for _, e := range t.Extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"pet-clinic/db"
+	"pet-clinic/models"
+	"pet-clinic/utils"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+var alertKinds = map[string]bool{"allergy": true, "condition": true, "alert": true}
+
+// severities in increasing order; allergies at or above "severe" block prescribing
+var alertSeverities = map[string]int{"mild": 1, "moderate": 2, "severe": 3, "life-threatening": 4}
+
+// knownDrugClasses fills in drug_class for common drugs when the prescriber leaves it empty
+var knownDrugClasses = map[string]string{
+	"amoxicillin":             "penicillins",
+	"amoxicillin-clavulanate": "penicillins",
+	"ampicillin":              "penicillins",
+	"penicillin":              "penicillins",
+	"cephalexin":              "cephalosporins",
+	"cefazolin":               "cephalosporins",
+	"cefovecin":               "cephalosporins",
+	"enrofloxacin":            "fluoroquinolones",
+	"marbofloxacin":           "fluoroquinolones",
+	"doxycycline":             "tetracyclines",
+	"trimethoprim-sulfa":      "sulfonamides",
+	"meloxicam":               "nsaids",
+	"carprofen":               "nsaids",
+	"robenacoxib":             "nsaids",
+	"prednisolone":            "corticosteroids",
+	"dexamethasone":           "corticosteroids",
+	"buprenorphine":           "opioids",
+	"methadone":               "opioids",
+	"acepromazine":            "phenothiazines",
+}
+
+// drugClassFor returns the given class, or the known class of the drug
+func drugClassFor(drug, class string) string {
+	if strings.TrimSpace(class) != "" {
+		return strings.ToLower(strings.TrimSpace(class))
+	}
+	return knownDrugClasses[strings.ToLower(strings.TrimSpace(drug))]
+}
+
+const petAlertColumns = `id, pet_id, kind, name, COALESCE(drug_class, ''), severity, COALESCE(notes, ''), active,
+	COALESCE(created_by, ''), created_at::text, COALESCE(resolved_at::text, '')`
+
+func scanPetAlert(row interface{ Scan(...interface{}) error }, a *models.PetAlert) error {
+	return row.Scan(&a.ID, &a.PetID, &a.Kind, &a.Name, &a.DrugClass, &a.Severity, &a.Notes, &a.Active,
+		&a.CreatedBy, &a.CreatedAt, &a.ResolvedAt)
+}
+
+// loadActiveAlerts returns active alerts per pet, most severe first
+func loadActiveAlerts(petIDs []int) (map[int][]models.PetAlert, error) {
+	ids := make([]int64, len(petIDs))
+	for i, id := range petIDs {
+		ids[i] = int64(id)
+	}
+
+	rows, err := db.DB.Query(`SELECT `+petAlertColumns+` FROM pet_alerts
+		WHERE active AND pet_id = ANY($1)
+		ORDER BY CASE severity WHEN 'life-threatening' THEN 4 WHEN 'severe' THEN 3 WHEN 'moderate' THEN 2 ELSE 1 END DESC, id`,
+		pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := map[int][]models.PetAlert{}
+	for rows.Next() {
+		var a models.PetAlert
+		if err := scanPetAlert(rows, &a); err != nil {
+			return nil, err
+		}
+		alerts[a.PetID] = append(alerts[a.PetID], a)
+	}
+	return alerts, rows.Err()
+}
+
+// checkDrugAllergies finds active allergies matching the drug or its class, split into
+// blocking (severe and worse) and warning-only matches
+func checkDrugAllergies(petID int, drug, class string) (blocking, warnings []models.PetAlert, err error) {
+	rows, err := db.DB.Query(`SELECT `+petAlertColumns+` FROM pet_alerts
+		WHERE active AND kind = 'allergy' AND pet_id = $1
+		  AND (LOWER(name) = LOWER($2)
+		    OR ($3 <> '' AND (LOWER(drug_class) = $3 OR LOWER(name) = $3)))`,
+		petID, strings.TrimSpace(drug), class)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a models.PetAlert
+		if err := scanPetAlert(rows, &a); err != nil {
+			return nil, nil, err
+		}
+		if alertSeverities[a.Severity] >= alertSeverities["severe"] {
+			blocking = append(blocking, a)
+		} else {
+			warnings = append(warnings, a)
+		}
+	}
+	return blocking, warnings, rows.Err()
+}
+
+// AddPetAlert - staff record an allergy, chronic condition or handling alert
+func AddPetAlert(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	var a models.PetAlert
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		ErrorResponse(w, "Invalid alert input", http.StatusBadRequest, err)
+		return
+	}
+	a.Name = strings.TrimSpace(a.Name)
+	if a.Severity == "" {
+		a.Severity = "moderate"
+	}
+	if !alertKinds[a.Kind] || a.Name == "" || alertSeverities[a.Severity] == 0 {
+		http.Error(w, "kind (allergy, condition, alert), name and severity (mild, moderate, severe, life-threatening) are required", http.StatusBadRequest)
+		return
+	}
+	if a.Kind == "allergy" {
+		a.DrugClass = drugClassFor(a.Name, a.DrugClass)
+	}
+
+	err = db.DB.QueryRow(`INSERT INTO pet_alerts (pet_id, kind, name, drug_class, severity, notes, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at::text`,
+		petID, a.Kind, a.Name, a.DrugClass, a.Severity, a.Notes, username).Scan(&a.ID, &a.CreatedAt)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+		http.Error(w, "Pet not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ErrorResponse(w, "Failed to add alert", http.StatusInternalServerError, err)
+		return
+	}
+	a.PetID = petID
+	a.Active = true
+	a.CreatedBy = username
+
+	utils.Log.WithFields(map[string]interface{}{"id": a.ID, "pet_id": petID, "kind": a.Kind, "name": a.Name, "severity": a.Severity}).Warn("Pet alert added")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(a)
+}
+
+// GetPetAlerts - alerts of a pet; resolved ones only with ?include_resolved=true
+func GetPetAlerts(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	if _, _, ok := authorizePetAccess(w, r, petID); !ok {
+		return
+	}
+
+	query := `SELECT ` + petAlertColumns + ` FROM pet_alerts WHERE pet_id=$1`
+	if r.URL.Query().Get("include_resolved") != "true" {
+		query += " AND active"
+	}
+	query += " ORDER BY active DESC, id"
+
+	rows, err := db.DB.Query(query, petID)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch alerts", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	alerts := []models.PetAlert{}
+	for rows.Next() {
+		var a models.PetAlert
+		if err := scanPetAlert(rows, &a); err != nil {
+			ErrorResponse(w, "Error scanning alerts", http.StatusInternalServerError, err)
+			return
+		}
+		alerts = append(alerts, a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(alerts)
+}
+
+// ResolvePetAlert - staff mark an alert as no longer active; it stays in the history
+func ResolvePetAlert(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := db.DB.Exec("UPDATE pet_alerts SET active=FALSE, resolved_at=NOW() WHERE id=$1 AND active", id)
+	if err != nil {
+		ErrorResponse(w, "Failed to resolve alert", http.StatusInternalServerError, err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Active alert not found", http.StatusNotFound)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"id": id, "user": username}).Info("Pet alert resolved")
+	w.Write([]byte("Alert resolved"))
+}
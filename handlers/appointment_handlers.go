@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"net/http"
 	"pet-clinic/db"
@@ -18,22 +19,69 @@ func BookAppointment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := db.DB.Exec(`INSERT INTO appointments (date, time, pet_id, reason)
-		VALUES ($1, $2, $3, $4)`,
-		a.Date, a.Time, a.PetID, a.Reason)
+	if !requireActivePet(w, a.PetID) {
+		return
+	}
+	if !checkSlotBookable(w, a.Date, a.Time) {
+		return
+	}
 
+	held, err := slotHeldForWaitlist(a.Date, a.Time, a.Vet)
 	if err != nil {
 		ErrorResponse(w, "Appointment booking failed", http.StatusInternalServerError, err)
 		return
 	}
+	if held {
+		utils.Log.WithFields(map[string]interface{}{"date": a.Date, "time": a.Time}).Warn("Booking attempted on a held waitlist slot")
+		http.Error(w, "Slot is currently held for a waitlist offer", http.StatusConflict)
+		return
+	}
 
-	utils.Log.Info("Appointment booked successfully")
+	err = db.DB.QueryRow(`INSERT INTO appointments (date, time, pet_id, reason, vet, appointment_type)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		a.Date, a.Time, a.PetID, a.Reason, a.Vet, a.Type).Scan(&a.ID)
+
+	if err != nil {
+		ErrorResponse(w, "Appointment booking failed", http.StatusInternalServerError, err)
+		return
+	}
+
+	go sendAppointmentInvite(a.ID, "REQUEST")
+
+	utils.Log.WithField("id", a.ID).Info("Appointment booked successfully")
 	w.Write([]byte("Appointment created"))
 }
 
-// Get Appointments
+// checkSlotBookable enforces the clinic calendar; writes the error response when the slot is not bookable
+func checkSlotBookable(w http.ResponseWriter, date, tm string) bool {
+	start, err := parseSlot(date, tm)
+	if err != nil {
+		ErrorResponse(w, "Invalid appointment date or time", http.StatusBadRequest, err)
+		return false
+	}
+
+	reason, err := clinicClosedReason(start)
+	if err != nil {
+		ErrorResponse(w, "Failed to check clinic calendar", http.StatusInternalServerError, err)
+		return false
+	}
+	if reason != "" {
+		utils.Log.WithFields(map[string]interface{}{"date": date, "time": tm, "reason": reason}).Warn("Booking rejected by clinic calendar")
+		http.Error(w, "Clinic is not open at that time ("+reason+")", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// Get Appointments (cancelled ones only with ?include_cancelled=true)
 func GetAppointments(w http.ResponseWriter, r *http.Request) {
-	rows, err := db.DB.Query("SELECT id, date, time, pet_id, reason FROM appointments")
+	query := `SELECT id, date, time, pet_id, reason, COALESCE(vet, ''), COALESCE(appointment_type, ''), status,
+		closure_conflict_id
+		FROM appointments`
+	if r.URL.Query().Get("include_cancelled") != "true" {
+		query += " WHERE status <> 'cancelled'"
+	}
+	rows, err := db.DB.Query(query)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -43,7 +91,7 @@ func GetAppointments(w http.ResponseWriter, r *http.Request) {
 	var appts []models.Appointment
 	for rows.Next() {
 		var a models.Appointment
-		rows.Scan(&a.ID, &a.Date, &a.Time, &a.PetID, &a.Reason)
+		rows.Scan(&a.ID, &a.Date, &a.Time, &a.PetID, &a.Reason, &a.Vet, &a.Type, &a.Status, &a.ClosureConflictID)
 		appts = append(appts, a)
 	}
 	json.NewEncoder(w).Encode(appts)
@@ -55,23 +103,72 @@ func UpdateAppointment(w http.ResponseWriter, r *http.Request) {
 	var a models.Appointment
 	json.NewDecoder(r.Body).Decode(&a)
 
-	_, err := db.DB.Exec(`UPDATE appointments SET date=$1, time=$2, pet_id=$3, reason=$4 WHERE id=$5`,
-		a.Date, a.Time, a.PetID, a.Reason, id)
+	if !requireActivePet(w, a.PetID) {
+		return
+	}
+	if !checkSlotBookable(w, a.Date, a.Time) {
+		return
+	}
+
+	held, err := slotHeldForWaitlist(a.Date, a.Time, a.Vet)
+	if err != nil {
+		ErrorResponse(w, "Appointment update failed", http.StatusInternalServerError, err)
+		return
+	}
+	if held {
+		utils.Log.WithFields(map[string]interface{}{"id": id, "date": a.Date, "time": a.Time}).Warn("Reschedule attempted onto a held waitlist slot")
+		http.Error(w, "Slot is currently held for a waitlist offer", http.StatusConflict)
+		return
+	}
+
+	// the old slot comes back from the locked row so it can go to the waitlist
+	var old freedSlot
+	var moved bool
+	err = db.DB.QueryRow(`WITH old AS (
+			SELECT id, date, time, vet, appointment_type, status FROM appointments WHERE id=$7 FOR UPDATE)
+		UPDATE appointments a SET date=$1, time=$2, pet_id=$3, reason=$4, vet=$5, appointment_type=$6,
+			sequence=a.sequence+1, updated_at=NOW(), closure_conflict_id=NULL
+		FROM old WHERE a.id = old.id
+		RETURNING a.id, old.date::text, to_char(old.time, 'HH24:MI'), COALESCE(old.vet, ''), COALESCE(old.appointment_type, ''),
+			old.status <> 'cancelled' AND (old.date, old.time, COALESCE(old.vet, '')) IS DISTINCT FROM (a.date, a.time, COALESCE(a.vet, ''))`,
+		a.Date, a.Time, a.PetID, a.Reason, a.Vet, a.Type, id).Scan(&a.ID, &old.Date, &old.Time, &old.Vet, &old.Type, &moved)
 
+	if err == sql.ErrNoRows {
+		http.Error(w, "Appointment not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	go sendAppointmentInvite(a.ID, "REQUEST")
+	if moved {
+		offerFreedSlot(old)
+	}
 	w.Write([]byte("Appointment updated"))
 }
 
-// Cancel Appointment
+// Cancel Appointment - kept as 'cancelled' so calendars can drop it; the freed slot is offered to the waitlist
 func DeleteAppointment(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
-	_, err := db.DB.Exec("DELETE FROM appointments WHERE id=$1", id)
+
+	var apptID int
+	var s freedSlot
+	err := db.DB.QueryRow(`UPDATE appointments SET status='cancelled', sequence=sequence+1, updated_at=NOW()
+		WHERE id=$1 AND status <> 'cancelled'
+		RETURNING id, date::text, time::text, COALESCE(vet, ''), COALESCE(appointment_type, '')`, id).
+		Scan(&apptID, &s.Date, &s.Time, &s.Vet, &s.Type)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Appointment not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	go sendAppointmentInvite(apptID, "CANCEL")
+	offerFreedSlot(s)
 	w.Write([]byte(" Appointment cancelled"))
 }
@@ -0,0 +1,498 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"pet-clinic/db"
+	"pet-clinic/models"
+	"pet-clinic/storage"
+	"pet-clinic/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// archiveSyncMax is the most attachment data a record may hold and still be
+// streamed straight away (ARCHIVE_SYNC_MAX_BYTES, default 100 MiB)
+func archiveSyncMax() int64 {
+	if n, err := strconv.ParseInt(os.Getenv("ARCHIVE_SYNC_MAX_BYTES"), 10, 64); err == nil && n >= 0 {
+		return n
+	}
+	return 100 << 20
+}
+
+// archiveExpiry is how long a finished archive stays downloadable (ARCHIVE_EXPIRY_HOURS, default 24)
+func archiveExpiry() time.Duration {
+	if h, err := strconv.Atoi(os.Getenv("ARCHIVE_EXPIRY_HOURS")); err == nil && h > 0 {
+		return time.Duration(h) * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// petRecord is everything that goes into a pet's archive
+type petRecord struct {
+	Pet           models.Pet
+	Visits        []models.Visit
+	Vaccinations  []models.Vaccination
+	Prescriptions []models.Prescription
+	Attachments   []models.Attachment
+}
+
+// archivedAttachment is an attachments.json entry: where the file is in the ZIP,
+// or missing when its content could not be read from storage
+type archivedAttachment struct {
+	models.Attachment
+	Path    string `json:"path,omitempty"`
+	Missing bool   `json:"missing,omitempty"`
+}
+
+// loadPetRecord reads the database side of an archive; attachment contents are
+// only read while the ZIP is written
+func loadPetRecord(petID int) (petRecord, error) {
+	var rec petRecord
+	var err error
+	if rec.Pet, err = loadPet(petID); err != nil {
+		return rec, err
+	}
+	if rec.Visits, err = loadVisits("pet_id = $1", petID); err != nil {
+		return rec, err
+	}
+	if rec.Vaccinations, err = loadPetVaccinations(petID); err != nil {
+		return rec, err
+	}
+	if rec.Prescriptions, err = loadPetPrescriptions(petID); err != nil {
+		return rec, err
+	}
+
+	rows, err := db.DB.Query(`SELECT `+attachmentColumns+` FROM attachments WHERE pet_id=$1 ORDER BY uploaded_at`, petID)
+	if err != nil {
+		return rec, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a models.Attachment
+		if err := scanAttachment(rows, &a); err != nil {
+			return rec, err
+		}
+		rec.Attachments = append(rec.Attachments, a)
+	}
+	return rec, rows.Err()
+}
+
+// archiveFilename names the ZIP after the pet, e.g. "pet-12-Bruno-record.zip"
+func archiveFilename(p models.Pet) string {
+	name := strings.NewReplacer("/", "-", `\`, "-").Replace(p.Name)
+	return cleanFilename(fmt.Sprintf("pet-%d-%s-record.zip", p.ID, name))
+}
+
+// storedCompressed lists content that deflate would not shrink
+func storedCompressed(contentType string) bool {
+	switch {
+	case contentType == "image/jpeg", contentType == "image/png", contentType == "image/gif",
+		contentType == "image/webp", contentType == "application/pdf", strings.HasPrefix(contentType, "video/"):
+		return true
+	}
+	return false
+}
+
+// writePetArchive streams rec as a ZIP: pet.json, visits.json, vaccinations.json,
+// prescriptions.json, the current version of every attachment under attachments/
+// and an attachments.json index. Each file is copied from the blob store straight
+// into the ZIP, so memory use does not grow with the record.
+func writePetArchive(ctx context.Context, w io.Writer, rec petRecord) error {
+	store, err := storage.Default()
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(w)
+	now := time.Now()
+
+	writeJSON := func(name string, v interface{}) error {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	for _, part := range []struct {
+		name string
+		v    interface{}
+	}{
+		{"pet.json", rec.Pet},
+		{"visits.json", rec.Visits},
+		{"vaccinations.json", rec.Vaccinations},
+		{"prescriptions.json", rec.Prescriptions},
+	} {
+		if err := writeJSON(part.name, part.v); err != nil {
+			return err
+		}
+	}
+
+	index := []archivedAttachment{}
+	for _, a := range rec.Attachments {
+		entry := archivedAttachment{Attachment: a}
+		rc, err := store.Get(ctx, a.BlobKey)
+		if errors.Is(err, storage.ErrNotExist) {
+			utils.Log.WithFields(map[string]interface{}{"id": a.ID, "pet_id": a.PetID}).Warn("Attachment missing from storage, left out of archive")
+			entry.Missing = true
+			index = append(index, entry)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		// the id prefix keeps files with the same name apart
+		entry.Path = "attachments/" + a.ID[:8] + "-" + a.Filename
+		method := zip.Deflate
+		if storedCompressed(a.ContentType) {
+			method = zip.Store
+		}
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: entry.Path, Method: method, Modified: parseDBTime(a.UploadedAt)})
+		if err == nil {
+			_, err = io.Copy(f, rc)
+		}
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		index = append(index, entry)
+	}
+	if err := writeJSON("attachments.json", index); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// GetPetArchive - GET /api/pets/{id}/archive downloads a pet's complete record as a
+// ZIP, e.g. when it moves to another clinic; same access rules as the attachments.
+// Records holding more than ARCHIVE_SYNC_MAX_BYTES of files, or ?async=true, are
+// built by a background job instead: the reply is 202 with the job to poll.
+func GetPetArchive(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	username, _, ok := authorizePetManage(w, r, petID)
+	if !ok {
+		return
+	}
+
+	var total int64
+	if err := db.DB.QueryRow(`SELECT COALESCE(SUM(size_bytes), 0)::bigint FROM attachments WHERE pet_id=$1`, petID).Scan(&total); err != nil {
+		ErrorResponse(w, "Failed to build archive", http.StatusInternalServerError, err)
+		return
+	}
+	if r.URL.Query().Get("async") == "true" || total > archiveSyncMax() {
+		createArchiveJob(w, petID, username)
+		return
+	}
+
+	rec, err := loadPetRecord(petID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Pet not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ErrorResponse(w, "Failed to build archive", http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", archiveFilename(rec.Pet)))
+	w.Header().Set("Cache-Control", "no-store")
+	counter := &countingWriter{w: w}
+	fields := map[string]interface{}{"pet_id": petID, "attachments": len(rec.Attachments), "user": username}
+	if err := writePetArchive(r.Context(), counter, rec); err != nil {
+		// the status line is already sent; the client sees a truncated ZIP
+		utils.Log.WithError(err).WithFields(fields).WithField("bytes", counter.n).Warn("Pet archive interrupted")
+		return
+	}
+	utils.Log.WithFields(fields).WithField("bytes", counter.n).Info("Pet archive downloaded")
+}
+
+const archiveJobColumns = `id, pet_id, status, filename, size_bytes, COALESCE(sha256, ''), COALESCE(error, ''),
+	requested_by, created_at::text, COALESCE(completed_at::text, ''), COALESCE(expires_at::text, ''), COALESCE(blob_key, ''),
+	COALESCE(FLOOR(EXTRACT(EPOCH FROM expires_at::timestamptz)), 0)::bigint`
+
+func scanArchiveJob(row interface{ Scan(...interface{}) error }, j *models.ArchiveJob) error {
+	return row.Scan(&j.ID, &j.PetID, &j.Status, &j.Filename, &j.Size, &j.SHA256, &j.Error,
+		&j.RequestedBy, &j.CreatedAt, &j.CompletedAt, &j.ExpiresAt, &j.BlobKey, &j.ExpiresUnix)
+}
+
+// createArchiveJob queues an archive of the pet, or returns the caller's job for
+// it that is still queued or running
+func createArchiveJob(w http.ResponseWriter, petID int, username string) {
+	var job models.ArchiveJob
+	err := scanArchiveJob(db.DB.QueryRow(`SELECT `+archiveJobColumns+` FROM archive_jobs
+		WHERE pet_id=$1 AND requested_by=$2 AND status IN ('pending', 'processing')
+		ORDER BY id DESC LIMIT 1`, petID, username), &job)
+	if errors.Is(err, sql.ErrNoRows) {
+		var p models.Pet
+		if p, err = loadPet(petID); errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Pet not found", http.StatusNotFound)
+			return
+		}
+		if err == nil {
+			err = scanArchiveJob(db.DB.QueryRow(`INSERT INTO archive_jobs (pet_id, filename, requested_by)
+				VALUES ($1, $2, $3) RETURNING `+archiveJobColumns, petID, archiveFilename(p), username), &job)
+		}
+		if err == nil {
+			utils.Log.WithFields(map[string]interface{}{"job_id": job.ID, "pet_id": petID, "user": username}).Info("Pet archive job queued")
+		}
+	}
+	if err != nil {
+		ErrorResponse(w, "Failed to queue archive", http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/api/archive-jobs/%d", job.ID))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// signArchiveLink authenticates a finished job's download URL until expires
+func signArchiveLink(key []byte, jobID int, expires int64) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "archive.%d.%d", jobID, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// GetArchiveJob - poll a background archive; once ready, url is a signed link that
+// works without a login until expires_at
+func GetArchiveJob(w http.ResponseWriter, r *http.Request) {
+	var job models.ArchiveJob
+	err := scanArchiveJob(db.DB.QueryRow(`SELECT `+archiveJobColumns+` FROM archive_jobs WHERE id=$1`, mux.Vars(r)["id"]), &job)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Archive job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch archive job", http.StatusInternalServerError, err)
+		return
+	}
+	if _, _, ok := authorizePetManage(w, r, job.PetID); !ok {
+		return
+	}
+
+	if job.Status == "ready" {
+		key := downloadLinkKey()
+		if key == nil {
+			ErrorResponse(w, "Download links are not configured (DOWNLOAD_LINK_SECRET)", http.StatusInternalServerError, nil)
+			return
+		}
+		job.URL = fmt.Sprintf("/archives/%d?expires=%d&sig=%s", job.ID, job.ExpiresUnix, signArchiveLink(key, job.ID, job.ExpiresUnix))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// ArchiveDownload - GET /archives/{id}?expires=&sig= serves a finished archive
+// without a login. Ranges are allowed, so large archives can resume.
+func ArchiveDownload(w http.ResponseWriter, r *http.Request) {
+	jobID, err := strconv.Atoi(mux.Vars(r)["id"])
+	expires, expErr := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	key := downloadLinkKey()
+	if err != nil || expErr != nil || key == nil ||
+		!hmac.Equal([]byte(r.URL.Query().Get("sig")), []byte(signArchiveLink(key, jobID, expires))) {
+		http.Error(w, "Archive not found", http.StatusNotFound)
+		return
+	}
+	if time.Now().Unix() >= expires {
+		http.Error(w, "This link has expired", http.StatusGone)
+		return
+	}
+
+	var job models.ArchiveJob
+	err = scanArchiveJob(db.DB.QueryRow(`SELECT `+archiveJobColumns+` FROM archive_jobs
+		WHERE id=$1 AND status='ready' AND expires_at > NOW()`, jobID), &job)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "This link is no longer valid", http.StatusGone)
+		return
+	}
+	if err != nil {
+		ErrorResponse(w, "Error reading archive", http.StatusInternalServerError, err)
+		return
+	}
+
+	// no UploadedAt: without Last-Modified, If-Range can only match the strong ETag
+	a := models.Attachment{ID: strconv.Itoa(job.ID), BlobKey: job.BlobKey, Filename: job.Filename,
+		ContentType: "application/zip", SHA256: job.SHA256}
+	if job.Size != nil {
+		a.Size = *job.Size
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	sent := serveAttachment(w, r, a, true)
+	fields := map[string]interface{}{"job_id": job.ID, "pet_id": job.PetID, "status": sent.Status, "bytes": sent.Bytes, "remote_addr": r.RemoteAddr}
+	if !sent.Complete {
+		utils.Log.WithFields(fields).Warn("Archive download not completed")
+	} else if sent.Status == http.StatusOK || sent.Status == http.StatusPartialContent {
+		utils.Log.WithFields(fields).Info("Archive downloaded")
+	}
+}
+
+// RunArchiveWorker builds queued archives one at a time and deletes expired ones.
+// Jobs are claimed with SKIP LOCKED like the preview worker; a claim older than
+// an hour (a crashed worker) is picked up again.
+func RunArchiveWorker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for processArchiveJob() {
+		}
+		expireArchives()
+	}
+}
+
+// processArchiveJob builds the oldest queued archive; false when there was none
+func processArchiveJob() bool {
+	var jobID, petID int
+	err := db.DB.QueryRow(`UPDATE archive_jobs SET status='processing', claimed_at=NOW()
+		WHERE id = (
+			SELECT id FROM archive_jobs
+			WHERE status='pending'
+			   OR status='processing' AND claimed_at < NOW() - INTERVAL '1 hour'
+			ORDER BY created_at LIMIT 1 FOR UPDATE SKIP LOCKED)
+		RETURNING id, pet_id`).Scan(&jobID, &petID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		utils.Log.WithError(err).Error("Failed to claim archive job")
+		return false
+	}
+
+	fields := map[string]interface{}{"job_id": jobID, "pet_id": petID}
+	blobKey, size, sum, buildErr := buildArchive(petID)
+	if buildErr != nil {
+		utils.Log.WithError(buildErr).WithFields(fields).Error("Pet archive job failed")
+		_, err = db.DB.Exec(`UPDATE archive_jobs SET status='failed', error=$2, claimed_at=NULL, completed_at=NOW()
+			WHERE id=$1`, jobID, buildErr.Error())
+	} else {
+		_, err = db.DB.Exec(`UPDATE archive_jobs SET status='ready', blob_key=$2, size_bytes=$3, sha256=$4,
+				claimed_at=NULL, completed_at=NOW(), expires_at=NOW() + $5 * INTERVAL '1 second'
+			WHERE id=$1`, jobID, blobKey, size, sum, int64(archiveExpiry()/time.Second))
+	}
+	if err != nil {
+		utils.Log.WithError(err).WithFields(fields).Error("Failed to record archive job status")
+		if store, serr := storage.Default(); serr == nil && blobKey != "" {
+			store.Delete(context.Background(), blobKey)
+		}
+		return true
+	}
+	if buildErr != nil {
+		return true
+	}
+	utils.Log.WithFields(fields).WithField("size", size).Info("Pet archive ready")
+	return true
+}
+
+// buildArchive writes the ZIP to a temporary file first, since the blob store
+// needs the size up front, then stores it under archives/
+func buildArchive(petID int) (blobKey string, size int64, sum string, err error) {
+	rec, err := loadPetRecord(petID)
+	if err != nil {
+		return "", 0, "", err
+	}
+	store, err := storage.Default()
+	if err != nil {
+		return "", 0, "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	tmp, err := os.CreateTemp("", "pet-archive-*.zip")
+	if err != nil {
+		return "", 0, "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(tmp, hash)}
+	if err := writePetArchive(ctx, counter, rec); err != nil {
+		return "", 0, "", err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return "", 0, "", err
+	}
+
+	id, err := newFileID()
+	if err != nil {
+		return "", 0, "", err
+	}
+	blobKey = "archives/" + id + ".zip"
+	if err := store.Put(ctx, blobKey, tmp, counter.n); err != nil {
+		return "", 0, "", err
+	}
+	return blobKey, counter.n, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// expireArchives deletes the stored ZIPs of jobs past their expiry
+func expireArchives() {
+	rows, err := db.DB.Query(`SELECT id, blob_key FROM archive_jobs WHERE status='ready' AND expires_at <= NOW()`)
+	if err != nil {
+		utils.Log.WithError(err).Error("Failed to list expired archives")
+		return
+	}
+	type expired struct {
+		id      int
+		blobKey string
+	}
+	var list []expired
+	for rows.Next() {
+		var e expired
+		if err := rows.Scan(&e.id, &e.blobKey); err == nil {
+			list = append(list, e)
+		}
+	}
+	rows.Close()
+	if len(list) == 0 {
+		return
+	}
+
+	store, err := storage.Default()
+	if err != nil {
+		utils.Log.WithError(err).Error("Failed to open storage for archive cleanup")
+		return
+	}
+	for _, e := range list {
+		if err := store.Delete(context.Background(), e.blobKey); err != nil && !errors.Is(err, storage.ErrNotExist) {
+			utils.Log.WithError(err).WithField("job_id", e.id).Warn("Failed to delete expired archive")
+			continue
+		}
+		db.DB.Exec(`UPDATE archive_jobs SET status='expired', blob_key=NULL WHERE id=$1`, e.id)
+	}
+	utils.Log.WithField("count", len(list)).Info("Expired pet archives deleted")
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pet-clinic/db"
+	"pet-clinic/models"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+const attachmentColumns = `id, pet_id, owner_id, visit_id, filename, content_type, size_bytes, sha256,
+	COALESCE(description, ''), COALESCE(uploaded_by, ''), uploaded_at::text, version, blob_key, preview_status`
+
+func scanAttachment(row interface{ Scan(...interface{}) error }, a *models.Attachment) error {
+	return row.Scan(&a.ID, &a.PetID, &a.OwnerID, &a.VisitID, &a.Filename, &a.ContentType, &a.Size, &a.SHA256,
+		&a.Description, &a.UploadedBy, &a.UploadedAt, &a.Version, &a.BlobKey, &a.PreviewStatus)
+}
+
+func loadAttachment(id string) (models.Attachment, error) {
+	var a models.Attachment
+	err := scanAttachment(db.DB.QueryRow(`SELECT `+attachmentColumns+` FROM attachments WHERE id=$1`, id), &a)
+	return a, err
+}
+
+func listAttachments(w http.ResponseWriter, condition string, args ...interface{}) {
+	rows, err := db.DB.Query(`SELECT `+attachmentColumns+` FROM attachments WHERE `+condition+` ORDER BY uploaded_at DESC`, args...)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch attachments", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	list := []models.Attachment{}
+	for rows.Next() {
+		var a models.Attachment
+		if err := scanAttachment(rows, &a); err != nil {
+			ErrorResponse(w, "Error scanning attachments", http.StatusInternalServerError, err)
+			return
+		}
+		list = append(list, a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// GetPetAttachments - files of a pet, newest first
+func GetPetAttachments(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	if _, _, ok := authorizePetManage(w, r, petID); !ok {
+		return
+	}
+	listAttachments(w, "pet_id=$1", petID)
+}
+
+// GetVisitAttachments - files attached to one visit
+func GetVisitAttachments(w http.ResponseWriter, r *http.Request) {
+	visitID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid visit id", http.StatusBadRequest)
+		return
+	}
+	var petID int
+	if err := db.DB.QueryRow("SELECT pet_id FROM visits WHERE id=$1", visitID).Scan(&petID); err != nil {
+		ErrorResponse(w, "Visit not found", http.StatusNotFound, err)
+		return
+	}
+	if _, _, ok := authorizePetManage(w, r, petID); !ok {
+		return
+	}
+	listAttachments(w, "visit_id=$1", visitID)
+}
+
+// GetAttachment - metadata of one file
+func GetAttachment(w http.ResponseWriter, r *http.Request) {
+	a, err := loadAttachment(mux.Vars(r)["id"])
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch attachment", http.StatusInternalServerError, err)
+		return
+	}
+	if _, _, ok := authorizePetManage(w, r, a.PetID); !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
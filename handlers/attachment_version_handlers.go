@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pet-clinic/db"
+	"pet-clinic/imaging"
+	"pet-clinic/models"
+	"pet-clinic/utils"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// saveAttachment records the blob a.BlobKey as a new attachment (a.ID empty, the
+// blob key becomes its id) or as the next version of attachment a.ID. Blobs are
+// never replaced, so every earlier version stays downloadable. Images and PDFs
+// are queued for the preview worker.
+func saveAttachment(a *models.Attachment, restoredFrom *int) error {
+	a.PreviewStatus = "none"
+	if imaging.Supported(a.ContentType) {
+		a.PreviewStatus = "pending"
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if a.ID == "" {
+		a.ID, a.Version = a.BlobKey, 1
+		err = tx.QueryRow(`INSERT INTO attachments (id, pet_id, owner_id, visit_id, filename, content_type,
+				size_bytes, sha256, description, uploaded_by, version, blob_key, preview_status)
+			SELECT $1, p.id, p.owner_id, $3, $4, $5, $6, $7, NULLIF($8, ''), NULLIF($9, ''), 1, $1, $10
+			FROM pets p WHERE p.id = $2
+			RETURNING owner_id, uploaded_at::text`,
+			a.ID, a.PetID, a.VisitID, a.Filename, a.ContentType, a.Size, a.SHA256, a.Description, a.UploadedBy, a.PreviewStatus).
+			Scan(&a.OwnerID, &a.UploadedAt)
+	} else {
+		// the row lock taken here orders concurrent uploads of the same attachment
+		err = tx.QueryRow(`UPDATE attachments SET version = version + 1, blob_key=$2, filename=$3, content_type=$4,
+				size_bytes=$5, sha256=$6, uploaded_by=NULLIF($7, ''), uploaded_at=NOW(),
+				preview_status=$8, preview_error=NULL, preview_claimed_at=NULL,
+				owner_id=(SELECT owner_id FROM pets WHERE id = attachments.pet_id)
+			WHERE id=$1
+			RETURNING version, owner_id, uploaded_at::text`,
+			a.ID, a.BlobKey, a.Filename, a.ContentType, a.Size, a.SHA256, a.UploadedBy, a.PreviewStatus).
+			Scan(&a.Version, &a.OwnerID, &a.UploadedAt)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO attachment_versions (attachment_id, version, blob_key, filename, content_type,
+			size_bytes, sha256, restored_from, uploaded_by, uploaded_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), $10::timestamp)`,
+		a.ID, a.Version, a.BlobKey, a.Filename, a.ContentType, a.Size, a.SHA256, restoredFrom, a.UploadedBy, a.UploadedAt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const attachmentVersionColumns = `version, filename, content_type, size_bytes, sha256, restored_from,
+	COALESCE(uploaded_by, ''), uploaded_at::text, blob_key`
+
+func scanAttachmentVersion(row interface{ Scan(...interface{}) error }, v *models.AttachmentVersion) error {
+	return row.Scan(&v.Version, &v.Filename, &v.ContentType, &v.Size, &v.SHA256, &v.RestoredFrom,
+		&v.UploadedBy, &v.UploadedAt, &v.BlobKey)
+}
+
+func loadAttachmentVersion(id string, version int) (models.AttachmentVersion, error) {
+	var v models.AttachmentVersion
+	err := scanAttachmentVersion(db.DB.QueryRow(`SELECT `+attachmentVersionColumns+` FROM attachment_versions
+		WHERE attachment_id=$1 AND version=$2`, id, version), &v)
+	return v, err
+}
+
+// useAttachmentVersion points a at the content of an older version, for downloads
+func useAttachmentVersion(a *models.Attachment, version int) error {
+	v, err := loadAttachmentVersion(a.ID, version)
+	if err != nil {
+		return err
+	}
+	a.Version, a.BlobKey, a.Filename, a.ContentType, a.Size, a.SHA256 = v.Version, v.BlobKey, v.Filename, v.ContentType, v.Size, v.SHA256
+	a.UploadedBy, a.UploadedAt = v.UploadedBy, v.UploadedAt
+	return nil
+}
+
+// GetAttachmentVersions - history of a file, newest first; same access rules as DownloadFile
+func GetAttachmentVersions(w http.ResponseWriter, r *http.Request) {
+	a, err := loadAttachment(mux.Vars(r)["id"])
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch versions", http.StatusInternalServerError, err)
+		return
+	}
+	if _, _, ok := authorizePetManage(w, r, a.PetID); !ok {
+		return
+	}
+
+	rows, err := db.DB.Query(`SELECT `+attachmentVersionColumns+` FROM attachment_versions
+		WHERE attachment_id=$1 ORDER BY version DESC`, a.ID)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch versions", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	list := []models.AttachmentVersion{}
+	for rows.Next() {
+		var v models.AttachmentVersion
+		if err := scanAttachmentVersion(rows, &v); err != nil {
+			ErrorResponse(w, "Error scanning versions", http.StatusInternalServerError, err)
+			return
+		}
+		list = append(list, v)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// RestoreAttachmentVersion - POST /attachments/{id}/versions/{version}/restore makes
+// an older version current again by adding it as a new version; history is kept
+func RestoreAttachmentVersion(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	a, err := loadAttachment(vars["id"])
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ErrorResponse(w, "Failed to restore version", http.StatusInternalServerError, err)
+		return
+	}
+	username, _, ok := authorizePetManage(w, r, a.PetID)
+	if !ok {
+		return
+	}
+	version, err := strconv.Atoi(vars["version"])
+	if err != nil {
+		http.Error(w, "Invalid version", http.StatusBadRequest)
+		return
+	}
+	v, err := loadAttachmentVersion(a.ID, version)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ErrorResponse(w, "Failed to restore version", http.StatusInternalServerError, err)
+		return
+	}
+	if v.BlobKey == a.BlobKey {
+		http.Error(w, "That version is already current", http.StatusConflict)
+		return
+	}
+
+	// the restored blob is shared with the old version, so no bytes are copied
+	// and the storage quota is unaffected
+	a.BlobKey, a.Filename, a.ContentType, a.Size, a.SHA256, a.UploadedBy = v.BlobKey, v.Filename, v.ContentType, v.Size, v.SHA256, username
+	if err := saveAttachment(&a, &v.Version); err != nil {
+		ErrorResponse(w, "Failed to restore version", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"id": a.ID, "restored_version": version, "version": a.Version, "user": username}).Info("Attachment version restored")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
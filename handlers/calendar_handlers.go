@@ -0,0 +1,359 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"pet-clinic/db"
+	"pet-clinic/ical"
+	"pet-clinic/models"
+	"pet-clinic/notify"
+	"pet-clinic/utils"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const calendarEventColumns = `a.id, a.date::text, to_char(a.time, 'HH24:MI:SS'), p.name, COALESCE(a.reason, ''),
+	COALESCE(a.vet, ''), COALESCE(a.appointment_type, ''), a.status, a.sequence, a.updated_at`
+
+// scanCalendarEvent turns an appointment row into an iCalendar event in clinic time
+func scanCalendarEvent(row interface{ Scan(...interface{}) error }) (ical.Event, error) {
+	var (
+		id                              int
+		date, tm, pet, reason, vet, typ string
+		status                          string
+		e                               ical.Event
+	)
+	if err := row.Scan(&id, &date, &tm, &pet, &reason, &vet, &typ, &status, &e.Sequence, &e.LastModified); err != nil {
+		return e, err
+	}
+
+	start, err := time.ParseInLocation("2006-01-02 15:04:05", date+" "+tm, utils.ClinicLocation())
+	if err != nil {
+		return e, err
+	}
+
+	e.UID = fmt.Sprintf("appointment-%d@pet-clinic", id)
+	e.Start = start
+	e.End = start.Add(utils.AppointmentDuration())
+	e.Summary = "Vet appointment: " + pet
+	if typ != "" {
+		e.Summary += " (" + typ + ")"
+	}
+	e.Description = reason
+	if vet != "" {
+		e.Description = "Vet: " + vet + "\n" + reason
+	}
+	e.Location = os.Getenv("CLINIC_ADDRESS")
+	e.Status = "CONFIRMED"
+	if status == "cancelled" {
+		e.Status = "CANCELLED"
+	}
+	return e, nil
+}
+
+func writeCalendar(w http.ResponseWriter, cal *ical.Calendar, filename string, attachment bool) {
+	var buf bytes.Buffer
+	if err := cal.Write(&buf); err != nil {
+		ErrorResponse(w, "Failed to build calendar", http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	disposition := "inline"
+	if attachment {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Disposition", disposition+`; filename="`+filename+`"`)
+	w.Write(buf.Bytes())
+}
+
+func hashFeedToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sendAppointmentInvite emails the owner an .ics invite (METHOD REQUEST) or cancellation (METHOD CANCEL).
+// Runs best-effort: without SMTP configuration it only logs.
+func sendAppointmentInvite(appointmentID int, method string) {
+	log := utils.Log.WithFields(map[string]interface{}{"appointment_id": appointmentID, "method": method})
+
+	n, err := notify.NewSMTPNotifierFromEnv()
+	if err != nil {
+		log.Debug("SMTP not configured, skipping calendar invite")
+		return
+	}
+
+	var email string
+	row := db.DB.QueryRow(`SELECT `+calendarEventColumns+`, COALESCE(o.email, '')
+		FROM appointments a
+		JOIN pets p ON p.id = a.pet_id
+		JOIN owners o ON o.id = p.owner_id
+		WHERE a.id = $1`, appointmentID)
+	e, err := scanCalendarEvent(scanWithExtra{row, &email})
+	if err != nil || email == "" {
+		log.WithError(err).Warn("Cannot send calendar invite")
+		return
+	}
+
+	var buf bytes.Buffer
+	(&ical.Calendar{Method: method, Events: []ical.Event{e}}).Write(&buf)
+
+	subject := "Appointment confirmed: " + e.Start.In(utils.ClinicLocation()).Format("Mon 02 Jan 2006 15:04")
+	if method == "CANCEL" {
+		subject = "Appointment cancelled: " + e.Start.In(utils.ClinicLocation()).Format("Mon 02 Jan 2006 15:04")
+	}
+
+	err = n.Send(notify.Message{
+		To:      email,
+		Subject: subject,
+		Body:    e.Summary + "\n" + e.Description,
+		Attachments: []notify.Attachment{{
+			Filename:    "appointment.ics",
+			ContentType: "text/calendar; charset=utf-8; method=" + method,
+			Data:        buf.Bytes(),
+		}},
+	})
+	if err != nil {
+		log.WithError(err).Error("Failed to send calendar invite")
+		return
+	}
+	log.Info("Calendar invite sent")
+}
+
+// scanWithExtra scans trailing columns after the calendar event columns
+type scanWithExtra struct {
+	row   interface{ Scan(...interface{}) error }
+	extra interface{}
+}
+
+func (s scanWithExtra) Scan(dest ...interface{}) error {
+	return s.row.Scan(append(dest, s.extra)...)
+}
+
+// GetAppointmentICS - download a single appointment as an .ics file
+func GetAppointmentICS(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	utils.Log.WithField("id", id).Debug("GET /appointments/{id}/ics called")
+
+	var petID int
+	if err := db.DB.QueryRow("SELECT pet_id FROM appointments WHERE id=$1", id).Scan(&petID); err != nil {
+		ErrorResponse(w, "Appointment not found", http.StatusNotFound, err)
+		return
+	}
+	if _, _, ok := authorizePetAccess(w, r, petID); !ok {
+		return
+	}
+
+	e, err := scanCalendarEvent(db.DB.QueryRow(`SELECT `+calendarEventColumns+`
+		FROM appointments a JOIN pets p ON p.id = a.pet_id WHERE a.id = $1`, id))
+	if err != nil {
+		ErrorResponse(w, "Failed to load appointment", http.StatusInternalServerError, err)
+		return
+	}
+
+	writeCalendar(w, &ical.Calendar{Method: "PUBLISH", Events: []ical.Event{e}}, "appointment-"+id+".ics", true)
+}
+
+// CreateCalendarToken - mint a secret feed token; owners get their own feed, staff any owner or vet feed
+func CreateCalendarToken(w http.ResponseWriter, r *http.Request) {
+	utils.Log.Debug("POST /calendar/tokens called")
+
+	username, role, ok := getUserFromRequest(r)
+	if !ok {
+		ErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	var t models.CalendarFeedToken
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		ErrorResponse(w, "Invalid token input", http.StatusBadRequest, err)
+		return
+	}
+
+	switch t.Scope {
+	case "owner":
+		if role == "owner" {
+			ownerID, valid := ownerIDFromUsername(username)
+			if !valid || (t.Subject != "" && t.Subject != strconv.Itoa(ownerID)) {
+				http.Error(w, "You can only subscribe to your own calendar", http.StatusForbidden)
+				return
+			}
+			t.Subject = strconv.Itoa(ownerID)
+		}
+		if _, err := strconv.Atoi(t.Subject); err != nil {
+			http.Error(w, "subject must be an owner id", http.StatusBadRequest)
+			return
+		}
+	case "vet":
+		if role != "staff" {
+			http.Error(w, "Staff access required", http.StatusForbidden)
+			return
+		}
+		if t.Subject == "" {
+			http.Error(w, "subject must be a vet name", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "scope must be 'owner' or 'vet'", http.StatusBadRequest)
+		return
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		ErrorResponse(w, "Failed to generate token", http.StatusInternalServerError, err)
+		return
+	}
+	t.Token = base64.RawURLEncoding.EncodeToString(raw)
+
+	err := db.DB.QueryRow(`INSERT INTO calendar_feed_tokens (token_hash, scope, subject, created_by)
+		VALUES ($1, $2, $3, $4) RETURNING id, created_at::text`,
+		hashFeedToken(t.Token), t.Scope, t.Subject, username).Scan(&t.ID, &t.CreatedAt)
+	if err != nil {
+		ErrorResponse(w, "Failed to create calendar token", http.StatusInternalServerError, err)
+		return
+	}
+	t.CreatedBy = username
+	t.FeedURL = "/calendar/" + t.Token + ".ics"
+
+	utils.Log.WithFields(map[string]interface{}{"id": t.ID, "scope": t.Scope, "subject": t.Subject}).Info("Calendar feed token created")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+// GetCalendarTokens - list feed tokens (never the secrets); owners see only their own
+func GetCalendarTokens(w http.ResponseWriter, r *http.Request) {
+	username, role, ok := getUserFromRequest(r)
+	if !ok {
+		ErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	query := `SELECT id, scope, subject, COALESCE(created_by, ''), created_at::text, COALESCE(revoked_at::text, '')
+		FROM calendar_feed_tokens`
+	var args []interface{}
+	if role == "owner" {
+		ownerID, valid := ownerIDFromUsername(username)
+		if !valid {
+			http.Error(w, "Invalid owner identity", http.StatusForbidden)
+			return
+		}
+		query += " WHERE scope = 'owner' AND subject = $1"
+		args = append(args, strconv.Itoa(ownerID))
+	}
+	query += " ORDER BY id"
+
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch calendar tokens", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	tokens := []models.CalendarFeedToken{}
+	for rows.Next() {
+		var t models.CalendarFeedToken
+		if err := rows.Scan(&t.ID, &t.Scope, &t.Subject, &t.CreatedBy, &t.CreatedAt, &t.RevokedAt); err != nil {
+			ErrorResponse(w, "Error scanning calendar token", http.StatusInternalServerError, err)
+			return
+		}
+		tokens = append(tokens, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// RevokeCalendarToken - revoked tokens stop serving the feed immediately
+func RevokeCalendarToken(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	utils.Log.WithField("id", id).Debug("DELETE /calendar/tokens/{id} called")
+
+	username, role, ok := getUserFromRequest(r)
+	if !ok {
+		ErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	var scope, subject string
+	if err := db.DB.QueryRow("SELECT scope, subject FROM calendar_feed_tokens WHERE id=$1", id).Scan(&scope, &subject); err != nil {
+		ErrorResponse(w, "Calendar token not found", http.StatusNotFound, err)
+		return
+	}
+	if role == "owner" {
+		ownerID, valid := ownerIDFromUsername(username)
+		if !valid || scope != "owner" || subject != strconv.Itoa(ownerID) {
+			http.Error(w, "You can only revoke your own calendar tokens", http.StatusForbidden)
+			return
+		}
+	}
+
+	if _, err := db.DB.Exec("UPDATE calendar_feed_tokens SET revoked_at=NOW() WHERE id=$1 AND revoked_at IS NULL", id); err != nil {
+		ErrorResponse(w, "Failed to revoke calendar token", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"id": id, "user": username}).Warn("Calendar feed token revoked")
+	w.Write([]byte("Calendar token revoked"))
+}
+
+// CalendarFeed - public subscribable feed; the secret token in the URL is the only credential
+func CalendarFeed(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	var scope, subject string
+	err := db.DB.QueryRow(`SELECT scope, subject FROM calendar_feed_tokens
+		WHERE token_hash=$1 AND revoked_at IS NULL`, hashFeedToken(token)).Scan(&scope, &subject)
+	if err == sql.ErrNoRows {
+		utils.Log.Warn("Calendar feed requested with unknown or revoked token")
+		http.Error(w, "Calendar not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ErrorResponse(w, "Failed to load calendar", http.StatusInternalServerError, err)
+		return
+	}
+
+	query := `SELECT ` + calendarEventColumns + `
+		FROM appointments a
+		JOIN pets p ON p.id = a.pet_id
+		WHERE a.date >= CURRENT_DATE - 90`
+	name := "Pet Clinic"
+	if scope == "vet" {
+		query += " AND a.vet = $1"
+		name += " - " + subject
+	} else {
+		query += " AND " + ownedPetsCondition("p.id", 1)
+	}
+	query += " ORDER BY a.date, a.time"
+
+	rows, err := db.DB.Query(query, subject)
+	if err != nil {
+		ErrorResponse(w, "Failed to load calendar", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	cal := &ical.Calendar{Name: name, Method: "PUBLISH"}
+	for rows.Next() {
+		e, err := scanCalendarEvent(rows)
+		if err != nil {
+			ErrorResponse(w, "Error building calendar", http.StatusInternalServerError, err)
+			return
+		}
+		cal.Events = append(cal.Events, e)
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"scope": scope, "subject": subject, "events": len(cal.Events)}).Info("Calendar feed served")
+	writeCalendar(w, cal, "pet-clinic.ics", false)
+}
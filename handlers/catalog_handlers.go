@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"pet-clinic/catalog"
+	"pet-clinic/db"
+	"pet-clinic/models"
+	"pet-clinic/utils"
+	"strings"
+)
+
+// catalogError is returned when species or breed is not in the catalog
+type catalogError struct {
+	Error       string   `json:"error"`
+	Field       string   `json:"field"`
+	Suggestions []string `json:"suggestions"`
+}
+
+func writeCatalogError(w http.ResponseWriter, field, value string, suggestions []string) {
+	if suggestions == nil {
+		suggestions = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(catalogError{
+		Error:       "Unknown " + field + " '" + value + "'",
+		Field:       field,
+		Suggestions: suggestions,
+	})
+}
+
+// normalizePetBreed replaces species and breed with their catalog names. Unknown
+// values are rejected with suggestions; breeds of species without a breed list
+// stay free text. Writes the error response itself.
+func normalizePetBreed(w http.ResponseWriter, p *models.Pet) bool {
+	c, err := catalog.Current()
+	if err != nil {
+		ErrorResponse(w, "Failed to load species catalog", http.StatusInternalServerError, err)
+		return false
+	}
+
+	species := c.ResolveSpecies(p.Species)
+	if species.Name == "" {
+		writeCatalogError(w, "species", p.Species, species.Suggestions)
+		return false
+	}
+	p.Species = species.Name
+
+	p.Breed = strings.TrimSpace(p.Breed)
+	if p.Breed == "" || !c.HasBreeds(p.Species) {
+		return true
+	}
+	breed := c.ResolveBreed(p.Species, p.Breed)
+	if breed.Name == "" {
+		writeCatalogError(w, "breed", p.Breed, breed.Suggestions)
+		return false
+	}
+	p.Breed = breed.Name
+	return true
+}
+
+// GetCatalog - species with their breeds and aliases
+func GetCatalog(w http.ResponseWriter, r *http.Request) {
+	c, err := catalog.Current()
+	if err != nil {
+		ErrorResponse(w, "Failed to load species catalog", http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// SuggestCatalog - resolve ?species= and optional ?breed= for form autocomplete
+func SuggestCatalog(w http.ResponseWriter, r *http.Request) {
+	c, err := catalog.Current()
+	if err != nil {
+		ErrorResponse(w, "Failed to load species catalog", http.StatusInternalServerError, err)
+		return
+	}
+
+	result := map[string]catalog.Match{"species": c.ResolveSpecies(r.URL.Query().Get("species"))}
+	if breed := r.URL.Query().Get("breed"); breed != "" && result["species"].Name != "" {
+		result["breed"] = c.ResolveBreed(result["species"].Name, breed)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// AddCatalogEntry - staff add a species, or a breed when breed is set, with aliases
+func AddCatalogEntry(w http.ResponseWriter, r *http.Request) {
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Species string   `json:"species"`
+		Breed   string   `json:"breed"`
+		Aliases []string `json:"aliases"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || strings.TrimSpace(body.Species) == "" {
+		http.Error(w, "species is required", http.StatusBadRequest)
+		return
+	}
+
+	c, err := catalog.Current()
+	if err != nil {
+		ErrorResponse(w, "Failed to load species catalog", http.StatusInternalServerError, err)
+		return
+	}
+	// names that already resolve (case, punctuation, aliases) are stored under
+	// their catalog name, so "dog" adds to "Dog" instead of creating a twin
+	entry := catalog.Species{Name: strings.TrimSpace(body.Species)}
+	species := c.ResolveSpecies(entry.Name)
+	if species.Name != "" {
+		entry.Name = species.Name
+	}
+	if body.Breed != "" {
+		// a breed is added to an existing species
+		if species.Name == "" {
+			writeCatalogError(w, "species", entry.Name, species.Suggestions)
+			return
+		}
+		breed := catalog.Breed{Name: strings.TrimSpace(body.Breed), Aliases: body.Aliases}
+		if m := c.ResolveBreed(entry.Name, breed.Name); m.Name != "" {
+			breed.Name = m.Name
+		}
+		entry.Breeds = []catalog.Breed{breed}
+	} else {
+		entry.Aliases = body.Aliases
+	}
+
+	err = catalog.Add(entry)
+	var conflict *catalog.AliasConflictError
+	if errors.As(err, &conflict) {
+		http.Error(w, "Alias '"+conflict.Alias+"' already maps to "+conflict.Name, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		ErrorResponse(w, "Failed to update species catalog", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"species": entry.Name, "breed": body.Breed, "by": username}).Info("Species catalog updated")
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte("Catalog updated"))
+}
+
+// DeleteCatalogAlias - staff remove an alias that maps to the wrong name (?alias=, for breeds also ?species=&breed=1)
+func DeleteCatalogAlias(w http.ResponseWriter, r *http.Request) {
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	alias := catalog.Key(q.Get("alias"))
+	query, args := "DELETE FROM species_aliases WHERE alias=$1", []interface{}{alias}
+	if q.Get("breed") != "" {
+		query = `DELETE FROM breed_aliases
+			WHERE alias=$1 AND species_id = (SELECT id FROM species WHERE LOWER(name) = LOWER($2))`
+		args = append(args, q.Get("species"))
+	}
+
+	result, err := db.DB.Exec(query, args...)
+	if err != nil {
+		ErrorResponse(w, "Failed to delete alias", http.StatusInternalServerError, err)
+		return
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		http.Error(w, "Alias not found", http.StatusNotFound)
+		return
+	}
+	catalog.Invalidate()
+
+	utils.Log.WithFields(map[string]interface{}{"alias": alias, "by": username}).Info("Catalog alias deleted")
+	w.Write([]byte("Alias deleted"))
+}
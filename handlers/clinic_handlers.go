@@ -0,0 +1,520 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"pet-clinic/db"
+	"pet-clinic/ical"
+	"pet-clinic/models"
+	"pet-clinic/utils"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const localTimestampFormat = "2006-01-02 15:04:05"
+
+// parseSlot reads an appointment date ("2006-01-02") and time ("15:04" or "15:04:05")
+// as clinic wall-clock time
+func parseSlot(date, tm string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04"} {
+		if t, err := time.Parse(layout, date+" "+tm); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q or time %q", date, tm)
+}
+
+// parseLocalTimestamp reads closure boundaries such as "2025-12-25", "2025-12-25T14:00" or "2025-12-25 14:00:00"
+func parseLocalTimestamp(v string) (time.Time, error) {
+	v = strings.Replace(strings.TrimSpace(v), "T", " ", 1)
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", v)
+}
+
+// clinicClosedReason explains why a slot starting at start cannot be booked; "" means the clinic is open
+func clinicClosedReason(start time.Time) (string, error) {
+	end := start.Add(utils.AppointmentDuration())
+	if end.YearDay() != start.YearDay() && !(end.Hour() == 0 && end.Minute() == 0) {
+		return "outside opening hours", nil
+	}
+	endOfDay := end.Format("15:04:05")
+	if endOfDay == "00:00:00" {
+		endOfDay = "24:00:00"
+	}
+
+	var open bool
+	err := db.DB.QueryRow(`SELECT EXISTS (
+		SELECT 1 FROM opening_hours WHERE weekday=$1 AND opens <= $2::time AND closes >= $3::time)`,
+		int(start.Weekday()), start.Format("15:04:05"), endOfDay).Scan(&open)
+	if err != nil {
+		return "", err
+	}
+	if !open {
+		return "outside opening hours", nil
+	}
+
+	var reason string
+	err = db.DB.QueryRow(`SELECT reason FROM clinic_closures
+		WHERE starts_at < $2::timestamp AND ends_at > $1::timestamp
+		ORDER BY starts_at LIMIT 1`,
+		start.Format(localTimestampFormat), end.Format(localTimestampFormat)).Scan(&reason)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return "closed: " + reason, nil
+}
+
+// flagClashingAppointments marks active appointments overlapping the closure
+func flagClashingAppointments(closureID int) ([]int, error) {
+	rows, err := db.DB.Query(`UPDATE appointments a SET closure_conflict_id = c.id
+		FROM clinic_closures c
+		WHERE c.id = $1
+		  AND a.status <> 'cancelled'
+		  AND (a.date + a.time) < c.ends_at
+		  AND (a.date + a.time) + $2 * INTERVAL '1 minute' > c.starts_at
+		RETURNING a.id`, closureID, int(utils.AppointmentDuration().Minutes()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) > 0 {
+		utils.Log.WithFields(map[string]interface{}{"closure_id": closureID, "appointments": ids}).Warn("Existing appointments clash with new closure")
+	}
+	return ids, rows.Err()
+}
+
+// reflagAppointments re-checks the appointments flagged for closureID after it
+// changed: each keeps the flag of whichever closure still overlaps it, if any
+func reflagAppointments(closureID int) error {
+	_, err := db.DB.Exec(`UPDATE appointments a SET closure_conflict_id = (
+			SELECT c.id FROM clinic_closures c
+			WHERE (a.date + a.time) < c.ends_at
+			  AND (a.date + a.time) + $2 * INTERVAL '1 minute' > c.starts_at
+			ORDER BY c.starts_at LIMIT 1)
+		WHERE a.closure_conflict_id = $1`, closureID, int(utils.AppointmentDuration().Minutes()))
+	return err
+}
+
+// GetOpeningHours - weekly schedule (weekday 0 = Sunday)
+func GetOpeningHours(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.DB.Query(`SELECT weekday, to_char(opens, 'HH24:MI'), to_char(closes, 'HH24:MI')
+		FROM opening_hours ORDER BY weekday, opens`)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch opening hours", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	hours := []models.OpeningHours{}
+	for rows.Next() {
+		var h models.OpeningHours
+		if err := rows.Scan(&h.Weekday, &h.Opens, &h.Closes); err != nil {
+			ErrorResponse(w, "Error scanning opening hours", http.StatusInternalServerError, err)
+			return
+		}
+		hours = append(hours, h)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hours)
+}
+
+// SetOpeningHours - staff replace the whole weekly schedule
+func SetOpeningHours(w http.ResponseWriter, r *http.Request) {
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	var hours []models.OpeningHours
+	if err := json.NewDecoder(r.Body).Decode(&hours); err != nil {
+		ErrorResponse(w, "Invalid opening hours input", http.StatusBadRequest, err)
+		return
+	}
+	for _, h := range hours {
+		opens, err1 := time.Parse("15:04", h.Opens)
+		closes, err2 := time.Parse("15:04", h.Closes)
+		if h.Weekday < 0 || h.Weekday > 6 || err1 != nil || err2 != nil || !closes.After(opens) {
+			http.Error(w, "Each entry needs weekday 0-6 and opens < closes as HH:MM", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		ErrorResponse(w, "Failed to update opening hours", http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM opening_hours"); err != nil {
+		ErrorResponse(w, "Failed to update opening hours", http.StatusInternalServerError, err)
+		return
+	}
+	for _, h := range hours {
+		if _, err := tx.Exec("INSERT INTO opening_hours (weekday, opens, closes) VALUES ($1, $2, $3)",
+			h.Weekday, h.Opens, h.Closes); err != nil {
+			ErrorResponse(w, "Failed to update opening hours", http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		ErrorResponse(w, "Failed to update opening hours", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"user": username, "entries": len(hours)}).Info("Opening hours updated")
+	w.Write([]byte("Opening hours updated"))
+}
+
+// GetClosures - closures overlapping [from, to); defaults to everything from today on
+func GetClosures(w http.ResponseWriter, r *http.Request) {
+	from := r.URL.Query().Get("from")
+	if from == "" {
+		from = time.Now().In(utils.ClinicLocation()).Format("2006-01-02")
+	}
+	to := r.URL.Query().Get("to")
+	if to == "" {
+		to = "infinity"
+	}
+
+	rows, err := db.DB.Query(`SELECT id, starts_at::text, ends_at::text, reason, kind, COALESCE(source_uid, ''), COALESCE(created_by, '')
+		FROM clinic_closures
+		WHERE ends_at > $1::timestamp AND starts_at < $2::timestamp
+		ORDER BY starts_at`, from, to)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch closures", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	closures := []models.ClinicClosure{}
+	for rows.Next() {
+		var c models.ClinicClosure
+		if err := rows.Scan(&c.ID, &c.StartsAt, &c.EndsAt, &c.Reason, &c.Kind, &c.SourceUID, &c.CreatedBy); err != nil {
+			ErrorResponse(w, "Error scanning closures", http.StatusInternalServerError, err)
+			return
+		}
+		closures = append(closures, c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(closures)
+}
+
+// AddClosure - staff add an ad-hoc closure (e.g. a training day); clashing appointments are flagged
+func AddClosure(w http.ResponseWriter, r *http.Request) {
+	utils.Log.Debug("POST /clinic/closures called")
+
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	var c models.ClinicClosure
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		ErrorResponse(w, "Invalid closure input", http.StatusBadRequest, err)
+		return
+	}
+	starts, err1 := parseLocalTimestamp(c.StartsAt)
+	ends, err2 := parseLocalTimestamp(c.EndsAt)
+	if err1 != nil || err2 != nil || !ends.After(starts) || strings.TrimSpace(c.Reason) == "" {
+		http.Error(w, "starts_at < ends_at (YYYY-MM-DD[THH:MM]) and reason are required", http.StatusBadRequest)
+		return
+	}
+
+	c.Kind = "adhoc"
+	c.CreatedBy = username
+	c.StartsAt = starts.Format(localTimestampFormat)
+	c.EndsAt = ends.Format(localTimestampFormat)
+	err := db.DB.QueryRow(`INSERT INTO clinic_closures (starts_at, ends_at, reason, kind, created_by)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		c.StartsAt, c.EndsAt, c.Reason, c.Kind, username).Scan(&c.ID)
+	if err != nil {
+		ErrorResponse(w, "Failed to add closure", http.StatusInternalServerError, err)
+		return
+	}
+
+	flagged, err := flagClashingAppointments(c.ID)
+	if err != nil {
+		ErrorResponse(w, "Closure added but flagging appointments failed", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"id": c.ID, "reason": c.Reason}).Info("Clinic closure added")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.ClosureResult{Closures: []models.ClinicClosure{c}, FlaggedAppointments: flagged})
+}
+
+// ImportHolidays - staff import public holidays from an iCal file (form field "file" or raw body).
+// Re-importing the same file updates events by UID instead of duplicating them.
+func ImportHolidays(w http.ResponseWriter, r *http.Request) {
+	utils.Log.Debug("POST /clinic/closures/import called")
+
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	// the limit applies to both the raw body and multipart uploads
+	r.Body = http.MaxBytesReader(w, r.Body, 5<<20)
+	var src io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			ErrorResponse(w, "Missing file", http.StatusBadRequest, err)
+			return
+		}
+		defer file.Close()
+		src = file
+	}
+
+	loc := utils.ClinicLocation()
+	events, err := ical.Parse(src, loc)
+	if err != nil {
+		ErrorResponse(w, "Invalid iCal file", http.StatusBadRequest, err)
+		return
+	}
+
+	result := models.ClosureResult{Closures: []models.ClinicClosure{}, FlaggedAppointments: []int{}}
+	for _, e := range events {
+		c := models.ClinicClosure{
+			StartsAt:  e.Start.In(loc).Format(localTimestampFormat),
+			EndsAt:    e.End.In(loc).Format(localTimestampFormat),
+			Reason:    e.Summary,
+			Kind:      "holiday",
+			SourceUID: e.UID,
+			CreatedBy: username,
+		}
+		if !e.End.After(e.Start) {
+			continue
+		}
+		if c.Reason == "" {
+			c.Reason = "Public holiday"
+		}
+		if c.SourceUID == "" {
+			c.SourceUID = "import-" + c.StartsAt + "-" + c.Reason
+		}
+
+		err := db.DB.QueryRow(`INSERT INTO clinic_closures (starts_at, ends_at, reason, kind, source_uid, created_by)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (source_uid) DO UPDATE SET starts_at=EXCLUDED.starts_at, ends_at=EXCLUDED.ends_at, reason=EXCLUDED.reason
+			RETURNING id`,
+			c.StartsAt, c.EndsAt, c.Reason, c.Kind, c.SourceUID, username).Scan(&c.ID)
+		if err != nil {
+			ErrorResponse(w, "Failed to import holidays", http.StatusInternalServerError, err)
+			return
+		}
+
+		// a re-imported event may have moved: appointments it no longer covers lose its flag
+		if err := reflagAppointments(c.ID); err != nil {
+			ErrorResponse(w, "Failed to flag clashing appointments", http.StatusInternalServerError, err)
+			return
+		}
+		flagged, err := flagClashingAppointments(c.ID)
+		if err != nil {
+			ErrorResponse(w, "Failed to flag clashing appointments", http.StatusInternalServerError, err)
+			return
+		}
+		result.Closures = append(result.Closures, c)
+		result.FlaggedAppointments = append(result.FlaggedAppointments, flagged...)
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"imported": len(result.Closures), "flagged": len(result.FlaggedAppointments)}).Info("Holidays imported")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// DeleteClosure - reopening clears the conflict flag on affected appointments
+func DeleteClosure(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		ErrorResponse(w, "Failed to delete closure", http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// ON DELETE SET NULL clears the flags; appointments in the deleted window are
+	// then re-checked against the closures that remain
+	var startsAt, endsAt string
+	err = tx.QueryRow(`DELETE FROM clinic_closures WHERE id=$1 RETURNING starts_at::text, ends_at::text`, id).Scan(&startsAt, &endsAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Closure not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ErrorResponse(w, "Failed to delete closure", http.StatusInternalServerError, err)
+		return
+	}
+	_, err = tx.Exec(`UPDATE appointments a SET closure_conflict_id = (
+			SELECT c.id FROM clinic_closures c
+			WHERE (a.date + a.time) < c.ends_at
+			  AND (a.date + a.time) + $3 * INTERVAL '1 minute' > c.starts_at
+			ORDER BY c.starts_at LIMIT 1)
+		WHERE a.status <> 'cancelled' AND a.closure_conflict_id IS NULL
+		  AND (a.date + a.time) < $2::timestamp
+		  AND (a.date + a.time) + $3 * INTERVAL '1 minute' > $1::timestamp`,
+		startsAt, endsAt, int(utils.AppointmentDuration().Minutes()))
+	if err != nil {
+		ErrorResponse(w, "Failed to delete closure", http.StatusInternalServerError, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		ErrorResponse(w, "Failed to delete closure", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"id": id, "user": username}).Warn("Clinic closure deleted")
+	w.Write([]byte("Closure deleted"))
+}
+
+// GetClosureConflicts - appointments that need rebooking because of a closure
+func GetClosureConflicts(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireStaff(w, r); !ok {
+		return
+	}
+
+	rows, err := db.DB.Query(`SELECT id, date::text, to_char(time, 'HH24:MI'), pet_id, COALESCE(reason, ''),
+			COALESCE(vet, ''), COALESCE(appointment_type, ''), status, closure_conflict_id
+		FROM appointments
+		WHERE closure_conflict_id IS NOT NULL AND status <> 'cancelled'
+		ORDER BY date, time`)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch conflicts", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	appts := []models.Appointment{}
+	for rows.Next() {
+		var a models.Appointment
+		if err := rows.Scan(&a.ID, &a.Date, &a.Time, &a.PetID, &a.Reason, &a.Vet, &a.Type, &a.Status, &a.ClosureConflictID); err != nil {
+			ErrorResponse(w, "Error scanning conflicts", http.StatusInternalServerError, err)
+			return
+		}
+		appts = append(appts, a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(appts)
+}
+
+// GetAvailability - free slots on ?date=YYYY-MM-DD, optionally for one ?vet=
+func GetAvailability(w http.ResponseWriter, r *http.Request) {
+	date := r.URL.Query().Get("date")
+	vet := r.URL.Query().Get("vet")
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		http.Error(w, "date must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	rows, err := db.DB.Query(`SELECT to_char(opens, 'HH24:MI'), to_char(closes, 'HH24:MI')
+		FROM opening_hours WHERE weekday=$1 ORDER BY opens`, int(day.Weekday()))
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch availability", http.StatusInternalServerError, err)
+		return
+	}
+	var candidates []time.Time
+	step := utils.AppointmentDuration()
+	for rows.Next() {
+		var opens, closes string
+		rows.Scan(&opens, &closes)
+		start, _ := parseSlot(date, opens)
+		end, _ := parseSlot(date, closes)
+		for t := start; !t.Add(step).After(end); t = t.Add(step) {
+			candidates = append(candidates, t)
+		}
+	}
+	rows.Close()
+
+	taken := map[string]bool{}
+	rows, err = db.DB.Query(`SELECT to_char(time, 'HH24:MI') FROM appointments
+			WHERE date=$1 AND status <> 'cancelled' AND COALESCE(vet, '') = $2
+		UNION
+		SELECT to_char(time, 'HH24:MI') FROM waitlist_offers
+			WHERE date=$1 AND status='pending' AND expires_at > NOW() AND COALESCE(vet, '') = $2`, date, vet)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch availability", http.StatusInternalServerError, err)
+		return
+	}
+	for rows.Next() {
+		var t string
+		rows.Scan(&t)
+		taken[t] = true
+	}
+	rows.Close()
+
+	// the day's closures, loaded once; candidates already lie within opening hours
+	type closure struct{ start, end time.Time }
+	var closures []closure
+	rows, err = db.DB.Query(`SELECT to_char(starts_at, 'YYYY-MM-DD HH24:MI:SS'), to_char(ends_at, 'YYYY-MM-DD HH24:MI:SS')
+		FROM clinic_closures WHERE starts_at < $1::date + 1 AND ends_at > $1::date`, date)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch availability", http.StatusInternalServerError, err)
+		return
+	}
+	for rows.Next() {
+		var from, to string
+		if err := rows.Scan(&from, &to); err != nil {
+			rows.Close()
+			ErrorResponse(w, "Failed to fetch availability", http.StatusInternalServerError, err)
+			return
+		}
+		var c closure
+		c.start, _ = parseLocalTimestamp(from)
+		c.end, _ = parseLocalTimestamp(to)
+		closures = append(closures, c)
+	}
+	rows.Close()
+
+	result := models.Availability{Date: date, Vet: vet, Slots: []string{}}
+	now := time.Now().In(utils.ClinicLocation()).Format(localTimestampFormat)
+	for _, t := range candidates {
+		closed := false
+		for _, c := range closures {
+			closed = closed || t.Before(c.end) && t.Add(step).After(c.start)
+		}
+		if closed {
+			continue
+		}
+		result.Open = true
+
+		slot := t.Format("15:04")
+		if !taken[slot] && t.Format(localTimestampFormat) >= now {
+			result.Slots = append(result.Slots, slot)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"pet-clinic/db"
+	"pet-clinic/models"
+	"pet-clinic/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxLinkHours caps how long a download link can live (30 days)
+const maxLinkHours = 30 * 24
+
+// downloadLinkKey is DOWNLOAD_LINK_SECRET, or a key derived from JWT_SECRET so the
+// two never share a key directly
+func downloadLinkKey() []byte {
+	if secret := os.Getenv("DOWNLOAD_LINK_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		return nil
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("download-links"))
+	return mac.Sum(nil)
+}
+
+// signDownloadLink authenticates link id, file and expiry, so a URL cannot be
+// guessed from a link id or stretched by editing its expires parameter
+func signDownloadLink(key []byte, linkID int, attachmentID string, expires int64) string {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%d.%s.%d", linkID, attachmentID, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+const downloadLinkColumns = `id, attachment_id, COALESCE(recipient, ''), expires_at::text, max_downloads, download_count,
+	created_by, created_at::text, COALESCE(revoked_by, ''), COALESCE(revoked_at::text, ''),
+	EXTRACT(EPOCH FROM expires_at::timestamptz)::bigint`
+
+func scanDownloadLink(row interface{ Scan(...interface{}) error }, l *models.DownloadLink) error {
+	return row.Scan(&l.ID, &l.AttachmentID, &l.Recipient, &l.ExpiresAt, &l.MaxDownloads, &l.DownloadCount,
+		&l.CreatedBy, &l.CreatedAt, &l.RevokedBy, &l.RevokedAt, &l.ExpiresUnix)
+}
+
+// CreateDownloadLink - staff mint a link to one attachment:
+// {"expires_in_hours": 72, "max_downloads": 3, "recipient": "City Referral Hospital"}.
+// The returned url needs no login and is only shown once.
+func CreateDownloadLink(w http.ResponseWriter, r *http.Request) {
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if !validFileID(id) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	var input struct {
+		ExpiresInHours int    `json:"expires_in_hours"`
+		MaxDownloads   *int   `json:"max_downloads"`
+		Recipient      string `json:"recipient"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		ErrorResponse(w, "Invalid link input", http.StatusBadRequest, err)
+		return
+	}
+	if input.ExpiresInHours == 0 {
+		input.ExpiresInHours = 72
+	}
+	if input.ExpiresInHours < 1 || input.ExpiresInHours > maxLinkHours {
+		http.Error(w, fmt.Sprintf("expires_in_hours must be between 1 and %d", maxLinkHours), http.StatusBadRequest)
+		return
+	}
+	if input.MaxDownloads != nil && *input.MaxDownloads < 1 {
+		http.Error(w, "max_downloads must be at least 1", http.StatusBadRequest)
+		return
+	}
+	key := downloadLinkKey()
+	if key == nil {
+		ErrorResponse(w, "Download links are not configured (DOWNLOAD_LINK_SECRET)", http.StatusInternalServerError, nil)
+		return
+	}
+
+	if _, err := loadAttachment(id); errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ErrorResponse(w, "Failed to create download link", http.StatusInternalServerError, err)
+		return
+	}
+
+	// the expiry comes from the database clock, which enforces it, in whole
+	// seconds so the expiry in the URL and in the table agree
+	var l models.DownloadLink
+	err := scanDownloadLink(db.DB.QueryRow(`INSERT INTO download_links (attachment_id, recipient, expires_at, max_downloads, created_by)
+		VALUES ($1, NULLIF($2, ''), date_trunc('second', NOW() + $3 * INTERVAL '1 hour'), $4, $5) RETURNING `+downloadLinkColumns,
+		id, strings.TrimSpace(input.Recipient), input.ExpiresInHours, input.MaxDownloads, username), &l)
+	if err != nil {
+		ErrorResponse(w, "Failed to create download link", http.StatusInternalServerError, err)
+		return
+	}
+	l.URL = fmt.Sprintf("/files/%d?expires=%d&sig=%s", l.ID, l.ExpiresUnix, signDownloadLink(key, l.ID, id, l.ExpiresUnix))
+
+	utils.Log.WithFields(map[string]interface{}{"link_id": l.ID, "attachment_id": id, "recipient": l.Recipient,
+		"expires_at": l.ExpiresAt, "user": username}).Info("Download link created")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(l)
+}
+
+// GetDownloadLinks - staff list of the links made for an attachment
+func GetDownloadLinks(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireStaff(w, r); !ok {
+		return
+	}
+	rows, err := db.DB.Query(`SELECT `+downloadLinkColumns+` FROM download_links WHERE attachment_id=$1 ORDER BY created_at DESC`,
+		mux.Vars(r)["id"])
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch download links", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	list := []models.DownloadLink{}
+	for rows.Next() {
+		var l models.DownloadLink
+		if err := scanDownloadLink(rows, &l); err != nil {
+			ErrorResponse(w, "Error scanning download links", http.StatusInternalServerError, err)
+			return
+		}
+		list = append(list, l)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// RevokeDownloadLink - staff stop a link from working immediately
+func RevokeDownloadLink(w http.ResponseWriter, r *http.Request) {
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["id"]
+
+	res, err := db.DB.Exec(`UPDATE download_links SET revoked_at=NOW(), revoked_by=$2 WHERE id=$1 AND revoked_at IS NULL`, id, username)
+	if n, _ := rowsAffected(res, err); n == 0 {
+		if err != nil {
+			ErrorResponse(w, "Failed to revoke download link", http.StatusInternalServerError, err)
+		} else {
+			http.Error(w, "Download link not found or already revoked", http.StatusNotFound)
+		}
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"link_id": id, "user": username}).Warn("Download link revoked")
+	w.Write([]byte("Download link revoked"))
+}
+
+// GetDownloadLinkAccesses - staff view of every request made with a link
+func GetDownloadLinkAccesses(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireStaff(w, r); !ok {
+		return
+	}
+	rows, err := db.DB.Query(`SELECT id, link_id, outcome, COALESCE(remote_addr, ''), COALESCE(user_agent, ''), accessed_at::text
+		FROM download_link_accesses WHERE link_id=$1 ORDER BY accessed_at DESC`, mux.Vars(r)["id"])
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch link accesses", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	list := []models.DownloadLinkAccess{}
+	for rows.Next() {
+		var a models.DownloadLinkAccess
+		if err := rows.Scan(&a.ID, &a.LinkID, &a.Outcome, &a.RemoteAddr, &a.UserAgent, &a.AccessedAt); err != nil {
+			ErrorResponse(w, "Error scanning link accesses", http.StatusInternalServerError, err)
+			return
+		}
+		list = append(list, a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func logLinkAccess(r *http.Request, linkID int, outcome string) {
+	utils.Log.WithFields(map[string]interface{}{"link_id": linkID, "outcome": outcome, "remote_addr": r.RemoteAddr}).Info("Download link used")
+	_, err := db.DB.Exec(`INSERT INTO download_link_accesses (link_id, outcome, remote_addr, user_agent) VALUES ($1, $2, $3, $4)`,
+		linkID, outcome, r.RemoteAddr, r.UserAgent())
+	if err != nil {
+		utils.Log.WithError(err).WithField("link_id", linkID).Error("Failed to record download link access")
+	}
+}
+
+// SharedDownload - GET /files/{id}?expires=&sig= serves the linked file without a
+// login. The signature covers the link's attachment, so only that one lookup
+// runs before it is checked; forged or edited URLs are never counted or logged
+// against the link. A download is reserved atomically with the other checks and
+// given back only when no byte of the file was sent.
+func SharedDownload(w http.ResponseWriter, r *http.Request) {
+	linkID, err := strconv.Atoi(mux.Vars(r)["id"])
+	expires, expErr := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	sig := r.URL.Query().Get("sig")
+	key := downloadLinkKey()
+	if err != nil || expErr != nil || sig == "" || key == nil {
+		http.Error(w, "Link not found", http.StatusNotFound)
+		return
+	}
+
+	var attachmentID string
+	err = db.DB.QueryRow(`SELECT attachment_id FROM download_links WHERE id=$1`, linkID).Scan(&attachmentID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		ErrorResponse(w, "Error reading file", http.StatusInternalServerError, err)
+		return
+	}
+	if err != nil || !hmac.Equal([]byte(sig), []byte(signDownloadLink(key, linkID, attachmentID, expires))) {
+		utils.Log.WithFields(map[string]interface{}{"link_id": linkID, "remote_addr": r.RemoteAddr}).Warn("Download link with bad signature")
+		http.Error(w, "Link not found", http.StatusNotFound)
+		return
+	}
+	if time.Now().Unix() >= expires {
+		logLinkAccess(r, linkID, "expired")
+		http.Error(w, "This link has expired", http.StatusGone)
+		return
+	}
+
+	err = db.DB.QueryRow(`UPDATE download_links SET download_count = download_count + 1
+		WHERE id=$1 AND revoked_at IS NULL AND expires_at > NOW()
+		  AND (max_downloads IS NULL OR download_count < max_downloads)
+		RETURNING attachment_id`, linkID).Scan(&attachmentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		outcome := "limit_reached"
+		var revoked, expired bool
+		db.DB.QueryRow(`SELECT revoked_at IS NOT NULL, expires_at <= NOW() FROM download_links WHERE id=$1`, linkID).Scan(&revoked, &expired)
+		if revoked {
+			outcome = "revoked"
+		} else if expired {
+			outcome = "expired"
+		}
+		logLinkAccess(r, linkID, outcome)
+		http.Error(w, "This link is no longer valid", http.StatusGone)
+		return
+	}
+	if err != nil {
+		ErrorResponse(w, "Error reading file", http.StatusInternalServerError, err)
+		return
+	}
+
+	a, err := loadAttachment(attachmentID)
+	if err != nil {
+		ErrorResponse(w, "Error reading file", http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	// no ranges and no revalidation: each request is one whole download, so a
+	// seeking player cannot use up max_downloads in seconds
+	r.Header.Del("If-None-Match")
+	r.Header.Del("If-Modified-Since")
+	sent := serveAttachment(w, r, a, false)
+	if sent.Complete && sent.Status == http.StatusOK {
+		logLinkAccess(r, linkID, "served")
+		return
+	}
+
+	// a download that sent nothing is given back; a partial one still counts, or
+	// dropping the connection before the last byte would make the limit useless
+	if sent.Bytes == 0 {
+		if _, err := db.DB.Exec(`UPDATE download_links SET download_count = download_count - 1
+			WHERE id=$1 AND download_count > 0`, linkID); err != nil {
+			utils.Log.WithError(err).WithField("link_id", linkID).Error("Failed to release download link count")
+		}
+	}
+	utils.Log.WithFields(map[string]interface{}{"link_id": linkID, "status": sent.Status, "bytes": sent.Bytes}).Warn("Shared download not completed")
+	logLinkAccess(r, linkID, "interrupted")
+}
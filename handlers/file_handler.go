@@ -1,20 +1,134 @@
 package handlers
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"mime"
 	"net/http"
 	"net/url"
-	"os"
 	"path/filepath"
+	"pet-clinic/db"
+	"pet-clinic/filetype"
+	"pet-clinic/imaging"
+	"pet-clinic/models"
+	"pet-clinic/scan"
+	"pet-clinic/storage"
 	"pet-clinic/utils"
+	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/gorilla/mux"
 )
 
-// UploadFile handles file upload
+// newFileID returns a random 128-bit id in hex; it is the only key used in the blob store
+func newFileID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func validFileID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil && strings.ToLower(id) == id
+}
+
+// cleanFilename keeps the last path element of a client-supplied name and drops
+// control characters; the result is only ever stored as metadata
+func cleanFilename(name string) string {
+	name = strings.ReplaceAll(name, `\`, "/")
+	name = filepath.Base("/" + name)
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "" || name == "/" || name == "." || name == ".." {
+		name = "file"
+	}
+	if runes := []rune(name); len(runes) > 255 {
+		name = string(runes[len(runes)-255:])
+	}
+	return name
+}
+
+// contentDisposition builds an RFC 6266 header with an ASCII fallback filename
+// and the exact UTF-8 name in filename* (RFC 5987 encoding)
+func contentDisposition(disposition, name string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' || r == '%' {
+			return '_'
+		}
+		return r
+	}, name)
+
+	var encoded strings.Builder
+	for _, b := range []byte(name) {
+		if isAttrChar(b) {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`, disposition, fallback, encoded.String())
+}
+
+// isAttrChar reports the characters RFC 5987 allows unencoded in ext-value
+func isAttrChar(b byte) bool {
+	switch {
+	case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", b) >= 0
+}
+
+// parseAttachmentVisit checks an optional visit_id value belongs to the pet
+func parseAttachmentVisit(petID int, value string) (*int, error) {
+	if value == "" {
+		return nil, nil
+	}
+	visitID, err := strconv.Atoi(value)
+	var visitPetID int
+	if err == nil {
+		err = db.DB.QueryRow("SELECT pet_id FROM visits WHERE id=$1", visitID).Scan(&visitPetID)
+	}
+	if err != nil || visitPetID != petID {
+		return nil, errors.New("visit_id must be a visit of this pet")
+	}
+	return &visitID, nil
+}
+
+// detectUploadType identifies an upload from its leading bytes and checks it
+// against the allow-list and the extension of filename; the error is client-facing
+func detectUploadType(filename string, head []byte) (filetype.Type, error) {
+	ft, ok := filetype.Detect(head)
+	if !ok || !filetype.Allowed()[ft.Name] {
+		utils.Log.WithFields(map[string]interface{}{"filename": filename, "type": ft.Name}).Warn("Rejected upload of a file type that is not allowed")
+		return ft, errors.New("File type not allowed")
+	}
+	if !ft.MatchesName(filename) {
+		utils.Log.WithFields(map[string]interface{}{"filename": filename, "type": ft.Name}).Warn("Rejected upload whose extension does not match its content")
+		return ft, fmt.Errorf("File extension does not match its content (%s)", ft.Name)
+	}
+	return ft, nil
+}
+
+// UploadFile handles file upload; form fields pet_id (required), visit_id and description
+// link the file to a pet's record, or attachment_id uploads a new version of an
+// existing file. Same access rules as UpdatePet.
 func UploadFile(w http.ResponseWriter, r *http.Request) {
 	utils.Log.Debug("Received file upload request")
 
@@ -26,6 +140,35 @@ func UploadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var a models.Attachment
+	if id := r.FormValue("attachment_id"); id != "" {
+		if a, err = loadAttachment(id); errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Attachment not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			ErrorResponse(w, "Could not save file", http.StatusInternalServerError, err)
+			return
+		}
+		if v := r.FormValue("pet_id"); v != "" && v != strconv.Itoa(a.PetID) {
+			http.Error(w, "attachment_id belongs to another pet", http.StatusBadRequest)
+			return
+		}
+	} else if a.PetID, err = strconv.Atoi(r.FormValue("pet_id")); err != nil {
+		http.Error(w, "pet_id is required", http.StatusBadRequest)
+		return
+	}
+	username, _, ok := authorizePetManage(w, r, a.PetID)
+	if !ok {
+		return
+	}
+	if a.ID == "" {
+		a.Description = strings.TrimSpace(r.FormValue("description"))
+		if a.VisitID, err = parseAttachmentVisit(a.PetID, r.FormValue("visit_id")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Get uploaded file from form-data
 	file, handler, err := r.FormFile("file")
 	if err != nil {
@@ -35,78 +178,256 @@ func UploadFile(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	// Ensure uploads folder exists
-	if _, err := os.Stat("uploads"); os.IsNotExist(err) {
-		os.Mkdir("uploads", os.ModePerm)
+	store, err := storage.Default()
+	if err != nil {
+		ErrorResponse(w, "Could not save file", http.StatusInternalServerError, err)
+		return
 	}
 
-	// Save file to uploads/ folder
-	filePath := filepath.Join("uploads", handler.Filename)
-	dest, err := os.Create(filePath)
-	if err != nil {
-		utils.Log.WithError(err).Error("Failed to create file on disk")
-		http.Error(w, "Could not save file", http.StatusInternalServerError)
+	if !checkStorageQuota(w, a.PetID, handler.Size) {
+		return
+	}
+
+	a.Filename, a.UploadedBy = cleanFilename(handler.Filename), username
+	if a.BlobKey, err = newFileID(); err != nil {
+		ErrorResponse(w, "Could not save file", http.StatusInternalServerError, err)
 		return
 	}
-	defer dest.Close()
 
-	// Copy file contents
-	_, err = io.Copy(dest, file)
+	// The type comes from the content, never from the name or the client's Content-Type
+	head := make([]byte, filetype.HeaderSize)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		ErrorResponse(w, "Could not read file", http.StatusBadRequest, err)
+		return
+	}
+	ft, err := detectUploadType(a.Filename, head[:n])
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	a.ContentType = ft.MIME
+
+	if scanner := scan.FromEnv(); scanner != nil {
+		result, err := scanFile(r.Context(), scanner, file)
+		if errors.Is(err, scan.ErrTooLarge) {
+			utils.Log.WithFields(map[string]interface{}{"filename": a.Filename, "size": handler.Size}).Warn("Upload too large for the malware scanner")
+			http.Error(w, "File is too large to be scanned", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			ErrorResponse(w, "File scanning unavailable, try again later", http.StatusServiceUnavailable, err)
+			return
+		}
+		if result.Infected {
+			q := a
+			q.ID, q.Size = a.BlobKey, handler.Size
+			if _, err := file.Seek(0, io.SeekStart); err == nil {
+				quarantineUpload(r.Context(), store, file, q, result.Signature)
+			}
+			http.Error(w, "File rejected: malware detected", http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		ErrorResponse(w, "Could not save file", http.StatusInternalServerError, err)
+		return
+	}
+
+	// Stream into the store without location metadata, hashing on the way;
+	// Put never overwrites an existing key
+	body := imaging.StripLocation(file, a.ContentType)
+	defer body.Close()
+	hash := sha256.New()
+	counter := &countingReader{r: io.TeeReader(body, hash)}
+	if err := store.Put(r.Context(), a.BlobKey, counter, handler.Size); err != nil {
 		utils.Log.WithError(err).Error("Error saving file data")
 		http.Error(w, "File save failed", http.StatusInternalServerError)
 		return
 	}
+	a.Size, a.SHA256 = counter.n, hex.EncodeToString(hash.Sum(nil))
 
-	utils.Log.WithField("filename", handler.Filename).Info("File uploaded successfully")
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "File uploaded successfully: %v", handler.Filename)
+	if err := saveAttachment(&a, nil); err != nil {
+		store.Delete(context.Background(), a.BlobKey)
+		ErrorResponse(w, "Could not save file", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"id": a.ID, "version": a.Version, "pet_id": a.PetID, "filename": a.Filename, "size": a.Size}).Info("File uploaded successfully")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(a)
 }
 
-// handles file download
+// handles file download by server-generated id, the current version unless
+// ?version= asks for an older one; same access rules as UpdatePet. Range and
+// conditional requests are honoured, so browsers can seek in videos and revalidate
+// cached copies; ?disposition=inline shows images, video, audio and PDFs in the browser.
 func DownloadFile(w http.ResponseWriter, r *http.Request) {
-	filename := mux.Vars(r)["filename"]
+	id := mux.Vars(r)["id"]
 
-	// Decode URL-encoded parts
-	decodedFilename, err := url.QueryUnescape(filename)
+	// only ids we generated are accepted; names from the client never reach the filesystem
+	if !validFileID(id) {
+		utils.Log.WithField("id", url.PathEscape(id)).Warn("Rejected download with invalid file id")
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	a, err := loadAttachment(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		utils.Log.WithError(err).Error("Failed to decode filename")
-		http.Error(w, "Invalid filename encoding", http.StatusBadRequest)
+		ErrorResponse(w, "Error reading file", http.StatusInternalServerError, err)
+		return
+	}
+	username, _, ok := authorizePetManage(w, r, a.PetID)
+	if !ok {
 		return
 	}
+	if v := r.URL.Query().Get("version"); v != "" {
+		version, err := strconv.Atoi(v)
+		if err == nil {
+			err = useAttachmentVersion(&a, version)
+		}
+		if err != nil {
+			http.Error(w, "Version not found", http.StatusNotFound)
+			return
+		}
+	}
 
-	// Remove any stray newlines or spaces
-	decodedFilename = strings.TrimSpace(decodedFilename)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	sent := serveAttachment(w, r, a, true)
+	fields := map[string]interface{}{"id": id, "version": a.Version, "filename": a.Filename, "user": username,
+		"status": sent.Status, "bytes": sent.Bytes}
+	switch {
+	case sent.Status >= 400:
+	case !sent.Complete:
+		utils.Log.WithFields(fields).Warn("Download interrupted")
+	case sent.Status == http.StatusOK && r.Method == http.MethodGet:
+		utils.Log.WithFields(fields).Info("File downloaded successfully")
+	default:
+		// revalidations, HEAD and the many range requests of a seeking video player
+		utils.Log.WithFields(fields).Debug("File partially downloaded or revalidated")
+	}
+}
 
-	utils.Log.WithField("filename", decodedFilename).Debug("Received download request")
+// inlineType reports content the browser may display in place; everything else
+// is always sent as an attachment
+func inlineType(contentType string) bool {
+	return strings.HasPrefix(contentType, "image/") || strings.HasPrefix(contentType, "video/") ||
+		strings.HasPrefix(contentType, "audio/") || contentType == "application/pdf"
+}
 
-	filePath := filepath.Join("uploads", decodedFilename)
+// downloadResult is what serveAttachment sent, for the caller's access log
+type downloadResult struct {
+	Status   int
+	Bytes    int64 // body bytes written
+	Complete bool  // the whole response body was written
+}
 
-	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		utils.Log.WithField("filename", decodedFilename).Warn("Requested file not found")
-		http.Error(w, "File not found", http.StatusNotFound)
-		return
+// serveAttachment streams a stored file with its original name. The ETag is the
+// content hash and Last-Modified the upload time of the version, so a cached copy
+// stays valid until a new version is uploaded. With allowRanges a single byte
+// range is served as 206 (If-Range is respected); multiple ranges get the whole file.
+// Error responses are written here and reported with their status.
+func serveAttachment(w http.ResponseWriter, r *http.Request, a models.Attachment, allowRanges bool) downloadResult {
+	etag := `"` + a.SHA256 + `"`
+	modTime := parseDBTime(a.UploadedAt)
+	h := w.Header()
+	h.Set("ETag", etag)
+	if !modTime.IsZero() {
+		h.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	if notModified(r, etag, modTime) {
+		w.WriteHeader(http.StatusNotModified)
+		return downloadResult{Status: http.StatusNotModified, Complete: true}
+	}
+
+	status, start, length := http.StatusOK, int64(0), a.Size
+	if allowRanges {
+		h.Set("Accept-Ranges", "bytes")
+		if header := r.Header.Get("Range"); header != "" && r.Method == http.MethodGet && rangeStillValid(r, etag, modTime) {
+			s, n, result := parseRange(header, a.Size)
+			switch result {
+			case rangeSatisfiable:
+				status, start, length = http.StatusPartialContent, s, n
+			case rangeUnsatisfiable:
+				h.Del("ETag")
+				h.Del("Last-Modified")
+				h.Set("Content-Range", fmt.Sprintf("bytes */%d", a.Size))
+				http.Error(w, "Requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+				return downloadResult{Status: http.StatusRequestedRangeNotSatisfiable}
+			}
+		}
+	} else {
+		h.Set("Accept-Ranges", "none")
 	}
 
-	// Open and serve file
-	file, err := os.Open(filePath)
+	fail := func(code int, msg string) downloadResult {
+		h.Del("ETag")
+		h.Del("Last-Modified")
+		h.Del("Accept-Ranges")
+		http.Error(w, msg, code)
+		return downloadResult{Status: code}
+	}
+	store, err := storage.Default()
 	if err != nil {
 		utils.Log.WithError(err).Error("Error opening file for download")
-		http.Error(w, "Error reading file", http.StatusInternalServerError)
-		return
+		return fail(http.StatusInternalServerError, "Error reading file")
+	}
+	var file io.ReadCloser
+	if r.Method != http.MethodHead {
+		if status == http.StatusPartialContent {
+			file, err = store.GetRange(r.Context(), a.BlobKey, start, length)
+		} else {
+			file, err = store.Get(r.Context(), a.BlobKey)
+		}
+	} else {
+		_, err = store.Stat(r.Context(), a.BlobKey)
+	}
+	if errors.Is(err, storage.ErrNotExist) {
+		utils.Log.WithFields(map[string]interface{}{"id": a.ID, "version": a.Version}).Warn("Requested file missing from storage")
+		return fail(http.StatusNotFound, "File not found")
+	}
+	if err != nil {
+		utils.Log.WithError(err).Error("Error opening file for download")
+		return fail(http.StatusInternalServerError, "Error reading file")
 	}
-	defer file.Close()
 
-	mimeType := "application/octet-stream"
-	if detected := mime.TypeByExtension(filepath.Ext(decodedFilename)); detected != "" {
-		mimeType = detected
+	disposition := "attachment"
+	if r.URL.Query().Get("disposition") == "inline" && inlineType(a.ContentType) {
+		disposition = "inline"
+	}
+	h.Set("Content-Disposition", contentDisposition(disposition, a.Filename))
+	h.Set("Content-Type", a.ContentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Length", strconv.FormatInt(length, 10))
+	if status == http.StatusPartialContent {
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, a.Size))
 	}
+	w.WriteHeader(status)
+	if file == nil {
+		return downloadResult{Status: status, Complete: true}
+	}
+	defer file.Close()
 
-	w.Header().Set("Content-Disposition", "attachment; filename="+decodedFilename)
-	w.Header().Set("Content-Type", mimeType)
+	n, err := io.Copy(w, file)
+	if err != nil && r.Context().Err() == nil {
+		utils.Log.WithError(err).WithField("id", a.ID).Warn("Error streaming file")
+	}
+	return downloadResult{Status: status, Bytes: n, Complete: err == nil && n == length}
+}
 
-	http.ServeFile(w, r, filePath)
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
 
-	utils.Log.WithField("filename", decodedFilename).Info("File downloaded successfully")
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
 }
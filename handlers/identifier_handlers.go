@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"pet-clinic/db"
+	"pet-clinic/identifiers"
+	"pet-clinic/models"
+	"pet-clinic/utils"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+const petIdentifierColumns = `id, pet_id, kind, value, issuer, COALESCE(notes, ''), COALESCE(added_by, ''), added_at::text`
+
+func scanPetIdentifier(row interface{ Scan(...interface{}) error }, pi *models.PetIdentifier) error {
+	return row.Scan(&pi.ID, &pi.PetID, &pi.Kind, &pi.Value, &pi.Issuer, &pi.Notes, &pi.AddedBy, &pi.AddedAt)
+}
+
+// AddPetIdentifier - staff, primary and co-owners register a microchip, tattoo or tag
+func AddPetIdentifier(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	username, _, ok := authorizePetManage(w, r, petID)
+	if !ok {
+		return
+	}
+
+	var pi models.PetIdentifier
+	if err := json.NewDecoder(r.Body).Decode(&pi); err != nil {
+		ErrorResponse(w, "Invalid identifier input", http.StatusBadRequest, err)
+		return
+	}
+	pi.Kind = strings.ToLower(strings.TrimSpace(pi.Kind))
+	if pi.Value, err = identifiers.Normalize(pi.Kind, pi.Value); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pi.Issuer = strings.TrimSpace(pi.Issuer)
+	if pi.Kind == "microchip" {
+		pi.Issuer = ""
+	}
+
+	err = scanPetIdentifier(db.DB.QueryRow(`INSERT INTO pet_identifiers (pet_id, kind, value, issuer, notes, added_by)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6) RETURNING `+petIdentifierColumns,
+		petID, pi.Kind, pi.Value, pi.Issuer, pi.Notes, username), &pi)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		// do not reveal which pet holds it; staff can use the lookup
+		utils.Log.WithFields(map[string]interface{}{"pet_id": petID, "kind": pi.Kind}).Warn("Duplicate pet identifier rejected")
+		http.Error(w, "This "+pi.Kind+" is already registered", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		ErrorResponse(w, "Failed to add identifier", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"id": pi.ID, "pet_id": petID, "kind": pi.Kind}).Info("Pet identifier added")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(pi)
+}
+
+// GetPetIdentifiers - identifiers registered for a pet
+func GetPetIdentifiers(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	if _, _, ok := authorizePetAccess(w, r, petID); !ok {
+		return
+	}
+
+	rows, err := db.DB.Query(`SELECT `+petIdentifierColumns+` FROM pet_identifiers WHERE pet_id=$1 ORDER BY kind, added_at`, petID)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch identifiers", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	list := []models.PetIdentifier{}
+	for rows.Next() {
+		var pi models.PetIdentifier
+		if err := scanPetIdentifier(rows, &pi); err != nil {
+			ErrorResponse(w, "Error scanning identifiers", http.StatusInternalServerError, err)
+			return
+		}
+		list = append(list, pi)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// DeletePetIdentifier - staff remove an identifier entered by mistake
+func DeletePetIdentifier(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := db.DB.Exec("DELETE FROM pet_identifiers WHERE id=$1", id)
+	if err != nil {
+		ErrorResponse(w, "Failed to delete identifier", http.StatusInternalServerError, err)
+		return
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		http.Error(w, "Identifier not found", http.StatusNotFound)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"id": id, "by": username}).Warn("Pet identifier deleted")
+	w.Write([]byte("Identifier deleted"))
+}
+
+// LookupIdentifier - find a pet by microchip or tag (?value=, optional ?kind=).
+// Anyone signed in sees the pet; owner contact details are only returned to staff.
+func LookupIdentifier(w http.ResponseWriter, r *http.Request) {
+	username, role, ok := getUserFromRequest(r)
+	if !ok {
+		ErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	value := r.URL.Query().Get("value")
+	candidates := identifiers.Candidates(value)
+	if kind := r.URL.Query().Get("kind"); kind != "" {
+		v, err := identifiers.Normalize(kind, value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		candidates = map[string]string{kind: v}
+	}
+	if len(candidates) == 0 {
+		http.Error(w, "value is not a valid microchip, tattoo or tag", http.StatusBadRequest)
+		return
+	}
+
+	var kinds, values []string
+	for k, v := range candidates {
+		kinds = append(kinds, k)
+		values = append(values, v)
+	}
+	rows, err := db.DB.Query(`SELECT i.id, i.pet_id, i.kind, i.value, i.issuer, COALESCE(i.notes, ''),
+			COALESCE(i.added_by, ''), i.added_at::text, p.name, COALESCE(p.species, ''), COALESCE(p.breed, ''), p.status
+		FROM pet_identifiers i
+		JOIN pets p ON p.id = i.pet_id
+		JOIN unnest($1::text[], $2::text[]) AS c(kind, value) ON c.kind = i.kind AND c.value = i.value
+		ORDER BY i.kind = 'microchip' DESC, i.id`, pq.Array(kinds), pq.Array(values))
+	if err != nil {
+		ErrorResponse(w, "Failed to look up identifier", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	matches := []models.IdentifierMatch{}
+	for rows.Next() {
+		var m models.IdentifierMatch
+		pi := &m.Identifier
+		if err := rows.Scan(&pi.ID, &pi.PetID, &pi.Kind, &pi.Value, &pi.Issuer, &pi.Notes, &pi.AddedBy, &pi.AddedAt,
+			&m.PetName, &m.Species, &m.Breed, &m.PetStatus); err != nil {
+			ErrorResponse(w, "Error scanning identifier matches", http.StatusInternalServerError, err)
+			return
+		}
+		if role != "staff" {
+			pi.Notes, pi.AddedBy = "", ""
+		}
+		matches = append(matches, m)
+	}
+	rows.Close()
+
+	if role == "staff" {
+		for i := range matches {
+			owners, err := loadOwnerContacts(matches[i].Identifier.PetID)
+			if err != nil {
+				ErrorResponse(w, "Failed to fetch owner contacts", http.StatusInternalServerError, err)
+				return
+			}
+			matches[i].Owners = owners
+		}
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"user": username, "role": role, "matches": len(matches)}).Info("Identifier lookup")
+	if len(matches) == 0 {
+		http.Error(w, "No pet registered with this identifier", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(matches)
+}
+
+// loadOwnerContacts returns everyone currently linked to the pet, primary owner first
+func loadOwnerContacts(petID int) ([]models.OwnerContact, error) {
+	rows, err := db.DB.Query(`SELECT o.id, o.name, po.role, COALESCE(o.contact, ''), COALESCE(o.email, '')
+		FROM pet_owners po JOIN owners o ON o.id = po.owner_id
+		WHERE po.pet_id = $1 AND po.removed_at IS NULL
+		ORDER BY po.role = 'primary' DESC, po.added_at`, petID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var owners []models.OwnerContact
+	for rows.Next() {
+		var c models.OwnerContact
+		if err := rows.Scan(&c.OwnerID, &c.Name, &c.Role, &c.Contact, &c.Email); err != nil {
+			return nil, err
+		}
+		owners = append(owners, c)
+	}
+	return owners, rows.Err()
+}
@@ -0,0 +1,578 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"pet-clinic/db"
+	"pet-clinic/labs"
+	"pet-clinic/models"
+	"pet-clinic/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// maxLabFileSize caps uploaded and watched result files (analyzer files are a few KB)
+const maxLabFileSize = 5 << 20
+
+const labOrderColumns = `id, pet_id, visit_id, accession, panel, COALESCE(notes, ''), status,
+	COALESCE(ordered_by, ''), ordered_at::text, COALESCE(resulted_at::text, '')`
+
+func scanLabOrder(row interface{ Scan(...interface{}) error }, o *models.LabOrder) error {
+	return row.Scan(&o.ID, &o.PetID, &o.VisitID, &o.Accession, &o.Panel, &o.Notes, &o.Status,
+		&o.OrderedBy, &o.OrderedAt, &o.ResultedAt)
+}
+
+const labImportColumns = `id, filename, format, source, status, unmatched, COALESCE(error, ''),
+	COALESCE(imported_by, ''), received_at::text`
+
+func scanLabImport(row interface{ Scan(...interface{}) error }, li *models.LabImport) error {
+	return row.Scan(&li.ID, &li.Filename, &li.Format, &li.Source, &li.Status, pq.Array(&li.Unmatched),
+		&li.Error, &li.ImportedBy, &li.ReceivedAt)
+}
+
+// reportLabel names a report in import summaries
+func reportLabel(rep labs.Report) string {
+	if rep.Accession != "" {
+		return rep.Accession
+	}
+	return "pet " + rep.PatientID
+}
+
+// matchLabOrder finds the open order a report belongs to: by accession, or for
+// files without one, the pet's only open order. A pet id that disagrees with the
+// order is treated as no match so results never land on the wrong animal, and an
+// order that already has results is never matched again, so a file dropped twice
+// does not duplicate them.
+func matchLabOrder(tx *sql.Tx, rep labs.Report) (orderID int, ok bool, err error) {
+	var petID int
+	if rep.Accession != "" {
+		err = tx.QueryRow(`SELECT id, pet_id FROM lab_orders WHERE accession=$1 AND status = 'ordered'`,
+			rep.Accession).Scan(&orderID, &petID)
+		if err == sql.ErrNoRows {
+			return 0, false, nil
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		if rep.PatientID != "" && rep.PatientID != strconv.Itoa(petID) {
+			return 0, false, nil
+		}
+		return orderID, true, nil
+	}
+
+	if _, convErr := strconv.Atoi(rep.PatientID); convErr != nil {
+		return 0, false, nil
+	}
+	rows, err := tx.Query(`SELECT id FROM lab_orders WHERE pet_id=$1 AND status='ordered' LIMIT 2`, rep.PatientID)
+	if err != nil {
+		return 0, false, err
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return 0, false, err
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) != 1 {
+		return 0, false, rows.Err()
+	}
+	return ids[0], true, rows.Err()
+}
+
+// storeLabResults writes a report's analytes against the order and marks it resulted
+func storeLabResults(tx *sql.Tx, orderID, importID int, rep labs.Report) error {
+	for _, a := range rep.Analytes {
+		name := a.Name
+		if name == "" {
+			name = a.Code
+		}
+		code := a.Code
+		if code == "" {
+			code = a.Name
+		}
+		_, err := tx.Exec(`INSERT INTO lab_results (order_id, import_id, code, name, value, numeric_value, units, ref_low, ref_high, flag)
+			VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8, $9, $10)`,
+			orderID, importID, code, name, a.Value, a.Numeric, a.Units, a.RefLow, a.RefHigh, a.Flag)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(`UPDATE lab_orders SET status='resulted', resulted_at=NOW() WHERE id=$1`, orderID)
+	return err
+}
+
+// importLabFile parses a result file, stores it and attaches every report that
+// matches an order. Files that cannot be parsed are kept with status 'failed'.
+func importLabFile(filename, source, username string, data []byte) (models.LabImport, error) {
+	li := models.LabImport{Filename: filename, Source: source, ImportedBy: username, Unmatched: []string{}}
+	reports, format, parseErr := labs.Parse(data)
+	li.Format = format
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return li, err
+	}
+	defer tx.Rollback()
+
+	li.Status = "failed"
+	if parseErr != nil {
+		li.Error = parseErr.Error()
+	}
+	err = tx.QueryRow(`INSERT INTO lab_imports (filename, format, source, content, status, error, imported_by)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, '')) RETURNING id, received_at::text`,
+		filename, format, source, string(data), li.Status, li.Error, username).Scan(&li.ID, &li.ReceivedAt)
+	if err != nil {
+		return li, err
+	}
+	if parseErr != nil {
+		return li, tx.Commit()
+	}
+
+	abnormal := 0
+	for _, rep := range reports {
+		orderID, ok, err := matchLabOrder(tx, rep)
+		if err != nil {
+			return li, err
+		}
+		if !ok {
+			li.Unmatched = append(li.Unmatched, reportLabel(rep))
+			continue
+		}
+		if err := storeLabResults(tx, orderID, li.ID, rep); err != nil {
+			return li, err
+		}
+		li.Matched = append(li.Matched, reportLabel(rep))
+		for _, a := range rep.Analytes {
+			if a.Flag != "" {
+				abnormal++
+			}
+		}
+	}
+
+	li.Status = "matched"
+	if len(li.Unmatched) > 0 {
+		li.Status = "unmatched"
+	}
+	if _, err := tx.Exec(`UPDATE lab_imports SET status=$1, unmatched=$2 WHERE id=$3`,
+		li.Status, pq.Array(li.Unmatched), li.ID); err != nil {
+		return li, err
+	}
+	if err := tx.Commit(); err != nil {
+		return li, err
+	}
+
+	utils.Log.WithFields(map[string]interface{}{
+		"import_id": li.ID, "file": filename, "format": format, "matched": len(li.Matched),
+		"unmatched": len(li.Unmatched), "abnormal": abnormal,
+	}).Info("Lab results imported")
+	return li, nil
+}
+
+// RunLabInbox imports result files the analyzer drops into LAB_INBOX_DIR.
+// Imported files move to processed/, unreadable ones to failed/.
+func RunLabInbox(interval time.Duration) {
+	dir := os.Getenv("LAB_INBOX_DIR")
+	if dir == "" {
+		return
+	}
+	for _, sub := range []string{"processed", "failed"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			utils.Log.WithError(err).Error("Failed to prepare lab inbox")
+			return
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		scanLabInbox(dir)
+	}
+}
+
+func scanLabInbox(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		utils.Log.WithError(err).Error("Failed to read lab inbox")
+		return
+	}
+
+	for _, e := range entries {
+		// skip folders and files the analyzer is still writing (dotfiles / .tmp)
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") || strings.HasSuffix(e.Name(), ".tmp") {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		log := utils.Log.WithField("file", e.Name())
+
+		data, err := readLabFile(path)
+		if err != nil {
+			log.WithError(err).Warn("Unreadable lab file")
+			moveLabFile(dir, e.Name(), "failed")
+			continue
+		}
+
+		li, err := importLabFile(e.Name(), "inbox", "", data)
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code.Class() == "22" {
+			// the database refuses the content itself (a data exception); it
+			// would fail the same way on every pass
+			log.WithError(err).Warn("Lab file cannot be stored")
+			moveLabFile(dir, e.Name(), "failed")
+			continue
+		}
+		if err != nil {
+			// database trouble: leave the file for the next pass
+			log.WithError(err).Error("Failed to import lab file")
+			continue
+		}
+		if li.Status == "failed" {
+			moveLabFile(dir, e.Name(), "failed")
+		} else {
+			moveLabFile(dir, e.Name(), "processed")
+		}
+	}
+}
+
+func readLabFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxLabFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxLabFileSize {
+		return nil, fmt.Errorf("file larger than %d bytes", maxLabFileSize)
+	}
+	// analyzer files are text; a NUL byte cannot be stored in a TEXT column
+	if bytes.IndexByte(data, 0) >= 0 {
+		return nil, errors.New("file contains NUL bytes")
+	}
+	return data, nil
+}
+
+func moveLabFile(dir, name, sub string) {
+	target := filepath.Join(dir, sub, time.Now().Format("20060102-150405-")+name)
+	if err := os.Rename(filepath.Join(dir, name), target); err != nil {
+		utils.Log.WithError(err).WithField("file", name).Error("Failed to move lab file")
+	}
+}
+
+// CreateLabOrder - staff order a panel; the response carries the accession for the sample label
+func CreateLabOrder(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	var o models.LabOrder
+	if err := json.NewDecoder(r.Body).Decode(&o); err != nil {
+		ErrorResponse(w, "Invalid lab order input", http.StatusBadRequest, err)
+		return
+	}
+	if strings.TrimSpace(o.Panel) == "" {
+		http.Error(w, "panel is required", http.StatusBadRequest)
+		return
+	}
+	if o.VisitID != nil {
+		var visitPetID int
+		if err := db.DB.QueryRow("SELECT pet_id FROM visits WHERE id=$1", *o.VisitID).Scan(&visitPetID); err != nil || visitPetID != petID {
+			http.Error(w, "visit_id must be a visit of this pet", http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = scanLabOrder(db.DB.QueryRow(`INSERT INTO lab_orders (pet_id, visit_id, panel, notes, ordered_by)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5) RETURNING `+labOrderColumns,
+		petID, o.VisitID, strings.TrimSpace(o.Panel), o.Notes, username), &o)
+	if err != nil {
+		ErrorResponse(w, "Failed to create lab order", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"id": o.ID, "pet_id": petID, "accession": o.Accession}).Info("Lab order created")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(o)
+}
+
+// loadLabOrders returns orders with their results attached
+func loadLabOrders(condition string, args ...interface{}) ([]models.LabOrder, error) {
+	rows, err := db.DB.Query(`SELECT `+labOrderColumns+` FROM lab_orders WHERE `+condition+` ORDER BY ordered_at DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []models.LabOrder{}
+	index := map[int]int{}
+	var ids []int
+	for rows.Next() {
+		var o models.LabOrder
+		if err := scanLabOrder(rows, &o); err != nil {
+			return nil, err
+		}
+		index[o.ID] = len(orders)
+		ids = append(ids, o.ID)
+		orders = append(orders, o)
+	}
+	if err := rows.Err(); err != nil || len(ids) == 0 {
+		return orders, err
+	}
+
+	results, err := db.DB.Query(`SELECT id, order_id, code, name, value, numeric_value::float8, COALESCE(units, ''),
+			ref_low::float8, ref_high::float8, flag, received_at::text
+		FROM lab_results WHERE order_id = ANY($1) ORDER BY id`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	for results.Next() {
+		var res models.LabResult
+		if err := results.Scan(&res.ID, &res.OrderID, &res.Code, &res.Name, &res.Value, &res.NumericValue, &res.Units,
+			&res.RefLow, &res.RefHigh, &res.Flag, &res.ReceivedAt); err != nil {
+			return nil, err
+		}
+		res.Abnormal = res.Flag != ""
+		o := &orders[index[res.OrderID]]
+		o.Results = append(o.Results, res)
+	}
+	return orders, results.Err()
+}
+
+// GetPetLabOrders - a pet's lab orders with results and abnormal flags
+func GetPetLabOrders(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	if _, _, ok := authorizePetAccess(w, r, petID); !ok {
+		return
+	}
+
+	orders, err := loadLabOrders("pet_id=$1", petID)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch lab orders", http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(orders)
+}
+
+// GetLabOrders - staff worklist, filtered by ?status= (default ordered)
+func GetLabOrders(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireStaff(w, r); !ok {
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "ordered"
+	}
+
+	orders, err := loadLabOrders("status=$1", status)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch lab orders", http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(orders)
+}
+
+// ImportLabResults - staff upload an analyzer CSV/HL7 file (form field "file")
+func ImportLabResults(w http.ResponseWriter, r *http.Request) {
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxLabFileSize+1<<10)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "Missing file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		ErrorResponse(w, "Could not read file", http.StatusBadRequest, err)
+		return
+	}
+	if bytes.IndexByte(data, 0) >= 0 {
+		http.Error(w, "Lab files must be text (CSV or HL7)", http.StatusBadRequest)
+		return
+	}
+
+	li, err := importLabFile(filepath.Base(header.Filename), "upload", username, data)
+	if err != nil {
+		ErrorResponse(w, "Failed to import lab results", http.StatusInternalServerError, err)
+		return
+	}
+
+	status := http.StatusCreated
+	if li.Status == "failed" {
+		status = http.StatusUnprocessableEntity
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(li)
+}
+
+// GetLabImports - staff review received files, e.g. ?status=unmatched
+func GetLabImports(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireStaff(w, r); !ok {
+		return
+	}
+
+	query := `SELECT ` + labImportColumns + ` FROM lab_imports`
+	var args []interface{}
+	if status := r.URL.Query().Get("status"); status != "" {
+		query += ` WHERE status=$1`
+		args = append(args, status)
+	}
+	rows, err := db.DB.Query(query+` ORDER BY received_at DESC LIMIT 200`, args...)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch lab imports", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	list := []models.LabImport{}
+	for rows.Next() {
+		var li models.LabImport
+		if err := scanLabImport(rows, &li); err != nil {
+			ErrorResponse(w, "Error scanning lab imports", http.StatusInternalServerError, err)
+			return
+		}
+		list = append(list, li)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// AssignLabImport - staff attach an unmatched report from a file to an order
+func AssignLabImport(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Report  string `json:"report"` // label from the import's unmatched list
+		OrderID int    `json:"order_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Report == "" || body.OrderID == 0 {
+		http.Error(w, "report and order_id are required", http.StatusBadRequest)
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		ErrorResponse(w, "Failed to start transaction", http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var li models.LabImport
+	var content string
+	err = tx.QueryRow(`SELECT `+labImportColumns+`, content FROM lab_imports WHERE id=$1 FOR UPDATE`, id).
+		Scan(&li.ID, &li.Filename, &li.Format, &li.Source, &li.Status, pq.Array(&li.Unmatched),
+			&li.Error, &li.ImportedBy, &li.ReceivedAt, &content)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Lab import not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch lab import", http.StatusInternalServerError, err)
+		return
+	}
+
+	remaining := []string{}
+	found := false
+	for _, label := range li.Unmatched {
+		if label == body.Report && !found {
+			found = true
+			continue
+		}
+		remaining = append(remaining, label)
+	}
+	if !found {
+		http.Error(w, "report is not unmatched in this import", http.StatusConflict)
+		return
+	}
+
+	var orderStatus string
+	if err := tx.QueryRow(`SELECT status FROM lab_orders WHERE id=$1 FOR UPDATE`, body.OrderID).Scan(&orderStatus); err != nil || orderStatus != "ordered" {
+		http.Error(w, "order_id must be an open lab order", http.StatusBadRequest)
+		return
+	}
+
+	reports, _, err := labs.Parse([]byte(content))
+	if err != nil {
+		ErrorResponse(w, "Stored lab file no longer parses", http.StatusInternalServerError, err)
+		return
+	}
+	stored := false
+	for _, rep := range reports {
+		if reportLabel(rep) == body.Report {
+			if err := storeLabResults(tx, body.OrderID, li.ID, rep); err != nil {
+				ErrorResponse(w, "Failed to store lab results", http.StatusInternalServerError, err)
+				return
+			}
+			stored = true
+			break
+		}
+	}
+	// the label stays unmatched unless results were actually attached
+	if !stored {
+		http.Error(w, "report not found in the stored lab file", http.StatusConflict)
+		return
+	}
+
+	li.Unmatched = remaining
+	if len(remaining) == 0 {
+		li.Status = "matched"
+	}
+	if _, err := tx.Exec(`UPDATE lab_imports SET status=$1, unmatched=$2 WHERE id=$3`,
+		li.Status, pq.Array(li.Unmatched), li.ID); err != nil {
+		ErrorResponse(w, "Failed to update lab import", http.StatusInternalServerError, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		ErrorResponse(w, "Failed to assign lab results", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"import_id": li.ID, "order_id": body.OrderID, "by": username}).Info("Lab report assigned")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(li)
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"pet-clinic/filetype"
+	"pet-clinic/imaging"
+	"pet-clinic/models"
+	"pet-clinic/scan"
+	"pet-clinic/storage"
+)
+
+// ImportLegacyFile stores a file saved by the old name-based upload handler
+// (uploads/<original name>) as a new attachment of petID. The file goes through
+// the same type check, malware scan and hashing as UploadFile; quotas are not
+// applied. The source file is left in place.
+func ImportLegacyFile(ctx context.Context, petID int, f *os.File, importedBy string) (models.Attachment, error) {
+	a := models.Attachment{PetID: petID, Filename: cleanFilename(filepath.Base(f.Name())), UploadedBy: importedBy,
+		Description: "Imported from legacy uploads"}
+	info, err := f.Stat()
+	if err != nil {
+		return a, err
+	}
+	store, err := storage.Default()
+	if err != nil {
+		return a, err
+	}
+
+	head := make([]byte, filetype.HeaderSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return a, err
+	}
+	ft, err := detectUploadType(a.Filename, head[:n])
+	if err != nil {
+		return a, err
+	}
+	a.ContentType = ft.MIME
+
+	if scanner := scan.FromEnv(); scanner != nil {
+		result, err := scanFile(ctx, scanner, f)
+		if err != nil {
+			return a, fmt.Errorf("scan: %w", err)
+		}
+		if result.Infected {
+			return a, fmt.Errorf("malware detected (%s)", result.Signature)
+		}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return a, err
+	}
+
+	if a.BlobKey, err = newFileID(); err != nil {
+		return a, err
+	}
+	body := imaging.StripLocation(f, a.ContentType)
+	defer body.Close()
+	hash := sha256.New()
+	counter := &countingReader{r: io.TeeReader(body, hash)}
+	if err := store.Put(ctx, a.BlobKey, counter, info.Size()); err != nil {
+		return a, err
+	}
+	a.Size, a.SHA256 = counter.n, hex.EncodeToString(hash.Sum(nil))
+
+	if err := saveAttachment(&a, nil); err != nil {
+		store.Delete(context.Background(), a.BlobKey)
+		return a, err
+	}
+	return a, nil
+}
@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"pet-clinic/auth"
@@ -12,6 +14,7 @@ import (
 	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/lib/pq"
 )
 
 // helper: extract role & username from claims
@@ -25,6 +28,81 @@ func getUserFromRequest(r *http.Request) (username, role string, ok bool) {
 	return u, rl, true
 }
 
+// helper: only staff may continue; writes the error response otherwise
+func requireStaff(w http.ResponseWriter, r *http.Request) (username string, ok bool) {
+	username, role, ok := getUserFromRequest(r)
+	if !ok {
+		ErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return "", false
+	}
+	if role != "staff" {
+		utils.Log.WithField("user", username).Warn("Non-staff user attempted a staff-only action")
+		http.Error(w, "Staff access required", http.StatusForbidden)
+		return "", false
+	}
+	return username, true
+}
+
+// helper: extract owner id from usernames like "owner1" -> 1
+func ownerIDFromUsername(username string) (int, bool) {
+	if !strings.HasPrefix(username, "owner") {
+		return 0, false
+	}
+	ownerID, err := strconv.Atoi(strings.TrimPrefix(username, "owner"))
+	if err != nil {
+		return 0, false
+	}
+	return ownerID, true
+}
+
+// helper: staff may act on any pet, owners only on pets they hold one of roles for
+// (primary, co-owner, caregiver). Writes the error response itself and returns
+// ok=false when access is denied.
+func authorizePetRole(w http.ResponseWriter, r *http.Request, petID int, roles ...string) (username, role string, ok bool) {
+	username, role, ok = getUserFromRequest(r)
+	if !ok {
+		ErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return "", "", false
+	}
+	if role != "owner" {
+		return username, role, true
+	}
+
+	ownerID, valid := ownerIDFromUsername(username)
+	if !valid {
+		http.Error(w, "Invalid owner identity", http.StatusForbidden)
+		return "", "", false
+	}
+
+	petRole, err := petOwnerRole(petID, ownerID)
+	if err != nil {
+		ErrorResponse(w, "Pet not found", http.StatusNotFound, err)
+		return "", "", false
+	}
+	for _, allowed := range roles {
+		if petRole == allowed {
+			return username, role, true
+		}
+	}
+	utils.Log.WithFields(map[string]interface{}{"user": username, "pet_id": petID, "pet_role": petRole}).Warn("Owner attempted to access pet without the required role")
+	if petRole == "" {
+		http.Error(w, "You can only access your own pets", http.StatusForbidden)
+	} else {
+		http.Error(w, "Your role for this pet does not allow this", http.StatusForbidden)
+	}
+	return "", "", false
+}
+
+// helper: any current owner or caregiver may view a pet and book for it
+func authorizePetAccess(w http.ResponseWriter, r *http.Request, petID int) (username, role string, ok bool) {
+	return authorizePetRole(w, r, petID, "primary", "co-owner", "caregiver")
+}
+
+// helper: primary and co-owners may change a pet's details; caregivers may not
+func authorizePetManage(w http.ResponseWriter, r *http.Request, petID int) (username, role string, ok bool) {
+	return authorizePetRole(w, r, petID, "primary", "co-owner")
+}
+
 // Add Pet (any authenticated user can add; owners usually add their pets)
 func AddPet(w http.ResponseWriter, r *http.Request) {
 	utils.Log.Info("POST /pets called")
@@ -33,25 +111,49 @@ func AddPet(w http.ResponseWriter, r *http.Request) {
 		ErrorResponse(w, "Invalid pet input", http.StatusBadRequest, err)
 		return
 	}
+	if !normalizePetBreed(w, &p) {
+		return
+	}
 
-	_, err := db.DB.Exec(`INSERT INTO pets (name, species, breed, owner_id, medical_history)
-        VALUES ($1, $2, $3, $4, $5)`,
-		p.Name, p.Species, p.Breed, p.OwnerID, p.MedicalHistory)
+	username, _, _ := getUserFromRequest(r)
+	err := db.DB.QueryRow(`WITH pet AS (
+			INSERT INTO pets (name, species, breed, owner_id, medical_history)
+			VALUES ($1, $2, $3, $4, $5) RETURNING id, owner_id)
+		INSERT INTO pet_owners (pet_id, owner_id, role, added_by)
+		SELECT id, owner_id, 'primary', $6 FROM pet RETURNING pet_id`,
+		p.Name, p.Species, p.Breed, p.OwnerID, p.MedicalHistory, username).Scan(&p.ID)
 
 	if err != nil {
 		ErrorResponse(w, "Failed to create pet", http.StatusInternalServerError, err)
 		return
 	}
 
+	// free-text history is kept as a legacy medical record entry
+	if strings.TrimSpace(p.MedicalHistory) != "" {
+		if err := addLegacyNote(p.ID, p.MedicalHistory, username); err != nil {
+			utils.Log.WithError(err).WithField("pet_id", p.ID).Error("Failed to record medical history note")
+		}
+	}
+
 	utils.Log.WithField("name", p.Name).Info("Pet added successfully")
 	w.WriteHeader(http.StatusCreated)
 	w.Write([]byte("Pet created"))
 }
 
-// GetPets
+// GetPets - active pets by default; ?status=deceased|transferred|lost|all
 func GetPets(w http.ResponseWriter, r *http.Request) {
 	utils.Log.Info("GET /pets called")
-	rows, err := db.DB.Query("SELECT id, name, species, breed, owner_id, medical_history FROM pets")
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "active"
+	}
+	// owners only see alerts of the pets they are linked to, as with GetPetAlerts
+	username, role, _ := getUserFromRequest(r)
+	ownerID, _ := ownerIDFromUsername(username)
+	rows, err := db.DB.Query(`SELECT id, name, species, breed, owner_id, medical_history, status,
+		COALESCE(status_date::text, ''), COALESCE(cause_of_death, ''), COALESCE(status_note, ''),
+		`+ownedPetsCondition("id", 2)+`
+		FROM pets WHERE $1 = 'all' OR status = $1`, status, ownerID)
 	if err != nil {
 		ErrorResponse(w, "Failed to fetch pets", http.StatusInternalServerError, err)
 		return
@@ -59,51 +161,96 @@ func GetPets(w http.ResponseWriter, r *http.Request) {
 	defer rows.Close()
 
 	var pets []models.Pet
+	var ids []int
 	for rows.Next() {
 		var p models.Pet
-		rows.Scan(&p.ID, &p.Name, &p.Species, &p.Breed, &p.OwnerID, &p.MedicalHistory)
+		var owned bool
+		rows.Scan(&p.ID, &p.Name, &p.Species, &p.Breed, &p.OwnerID, &p.MedicalHistory,
+			&p.Status, &p.StatusDate, &p.CauseOfDeath, &p.StatusNote, &owned)
 		pets = append(pets, p)
+		if role == "staff" || owned {
+			ids = append(ids, p.ID)
+		}
+	}
+
+	// allergies and alerts travel with every pet so nobody misses them
+	alerts, err := loadActiveAlerts(ids)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch pet alerts", http.StatusInternalServerError, err)
+		return
+	}
+	for i := range pets {
+		pets[i].Alerts = alerts[pets[i].ID]
+		if pets[i].Alerts == nil {
+			pets[i].Alerts = []models.PetAlert{}
+		}
 	}
 
 	json.NewEncoder(w).Encode(pets)
 }
 
-// UpdatePet - owner can update only their pets; staff can update any pet
-func UpdatePet(w http.ResponseWriter, r *http.Request) {
-	idStr := mux.Vars(r)["id"]
-	id := idStr
+// GetPet - a single pet with its active alerts; owners only their own pets
+func GetPet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	if _, _, ok := authorizePetAccess(w, r, id); !ok {
+		return
+	}
 
-	username, role, ok := getUserFromRequest(r)
-	if !ok {
-		ErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+	p, err := loadPet(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		ErrorResponse(w, "Pet not found", http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch pet", http.StatusInternalServerError, err)
 		return
 	}
 
-	// If role is owner, verify ownership
-	if role == "owner" {
-		// extract owner id from username like "owner1" -> 1
-		if !strings.HasPrefix(username, "owner") {
-			http.Error(w, "Invalid owner identity", http.StatusForbidden)
-			return
-		}
-		ownerIDStr := strings.TrimPrefix(username, "owner")
-		ownerID, err := strconv.Atoi(ownerIDStr)
-		if err != nil {
-			http.Error(w, "Invalid owner identity", http.StatusForbidden)
-			return
-		}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
 
-		var petOwnerID int
-		err = db.DB.QueryRow("SELECT owner_id FROM pets WHERE id=$1", id).Scan(&petOwnerID)
-		if err != nil {
-			ErrorResponse(w, "Pet not found", http.StatusNotFound, err)
-			return
-		}
-		if petOwnerID != ownerID {
-			utils.Log.WithFields(map[string]interface{}{"user": username, "pet_id": id}).Warn("Owner attempted to update other user's pet")
-			http.Error(w, "You can only update your own pets", http.StatusForbidden)
-			return
-		}
+// loadPet reads one pet with its active alerts
+func loadPet(id int) (models.Pet, error) {
+	var p models.Pet
+	var history sql.NullString
+	err := db.DB.QueryRow(`SELECT id, name, COALESCE(species, ''), COALESCE(breed, ''), owner_id, medical_history, status,
+		COALESCE(status_date::text, ''), COALESCE(cause_of_death, ''), COALESCE(status_note, '')
+		FROM pets WHERE id=$1`, id).
+		Scan(&p.ID, &p.Name, &p.Species, &p.Breed, &p.OwnerID, &history, &p.Status, &p.StatusDate, &p.CauseOfDeath, &p.StatusNote)
+	if err != nil {
+		return p, err
+	}
+	p.MedicalHistory = history.String
+
+	alerts, err := loadActiveAlerts([]int{p.ID})
+	if err != nil {
+		return p, err
+	}
+	p.Alerts = alerts[p.ID]
+	if p.Alerts == nil {
+		p.Alerts = []models.PetAlert{}
+	}
+	return p, nil
+}
+
+// UpdatePet - primary and co-owners can update their pets; staff can update any pet.
+// Ownership is not changed here; that goes through a transfer.
+func UpdatePet(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	petID, err := strconv.Atoi(id)
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+
+	username, _, ok := authorizePetManage(w, r, petID)
+	if !ok {
+		return
 	}
 
 	// proceed with update
@@ -112,56 +259,51 @@ func UpdatePet(w http.ResponseWriter, r *http.Request) {
 		ErrorResponse(w, "Invalid update body", http.StatusBadRequest, err)
 		return
 	}
+	if !normalizePetBreed(w, &p) {
+		return
+	}
+
+	var previousHistory sql.NullString
+	db.DB.QueryRow("SELECT medical_history FROM pets WHERE id=$1", id).Scan(&previousHistory)
 
-	_, err := db.DB.Exec(`UPDATE pets SET name=$1, species=$2, breed=$3, owner_id=$4, medical_history=$5 WHERE id=$6`,
-		p.Name, p.Species, p.Breed, p.OwnerID, p.MedicalHistory, id)
+	_, err = db.DB.Exec(`UPDATE pets SET name=$1, species=$2, breed=$3, medical_history=$4 WHERE id=$5`,
+		p.Name, p.Species, p.Breed, p.MedicalHistory, id)
 
 	if err != nil {
 		ErrorResponse(w, "Failed to update pet", http.StatusInternalServerError, err)
 		return
 	}
 
+	// the column only holds the latest text; every change is also appended to the medical record
+	if strings.TrimSpace(p.MedicalHistory) != "" && p.MedicalHistory != previousHistory.String {
+		if err := addLegacyNote(petID, p.MedicalHistory, username); err != nil {
+			utils.Log.WithError(err).WithField("pet_id", id).Error("Failed to record medical history note")
+		}
+	}
+
 	utils.Log.WithField("id", id).Info("Pet updated successfully by " + username)
 	w.Write([]byte("Pet updated successfully"))
 }
 
-// DeletePet - owner can delete only their pets; staff can delete any pet
+// DeletePet - the primary owner can delete their pet; staff can delete any pet
 func DeletePet(w http.ResponseWriter, r *http.Request) {
-	idStr := mux.Vars(r)["id"]
-	id := idStr
+	id := mux.Vars(r)["id"]
+	petID, err := strconv.Atoi(id)
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
 
-	username, role, ok := getUserFromRequest(r)
+	username, _, ok := authorizePetRole(w, r, petID, "primary")
 	if !ok {
-		ErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
 		return
 	}
 
-	if role == "owner" {
-		if !strings.HasPrefix(username, "owner") {
-			http.Error(w, "Invalid owner identity", http.StatusForbidden)
-			return
-		}
-		ownerIDStr := strings.TrimPrefix(username, "owner")
-		ownerID, err := strconv.Atoi(ownerIDStr)
-		if err != nil {
-			http.Error(w, "Invalid owner identity", http.StatusForbidden)
-			return
-		}
-
-		var petOwnerID int
-		err = db.DB.QueryRow("SELECT owner_id FROM pets WHERE id=$1", id).Scan(&petOwnerID)
-		if err != nil {
-			ErrorResponse(w, "Pet not found", http.StatusNotFound, err)
-			return
-		}
-		if petOwnerID != ownerID {
-			utils.Log.WithFields(map[string]interface{}{"user": username, "pet_id": id}).Warn("Owner attempted to delete other user's pet")
-			http.Error(w, "You can only delete your own pets", http.StatusForbidden)
-			return
-		}
+	_, err = db.DB.Exec("DELETE FROM pets WHERE id=$1", id)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23503" {
+		ErrorResponse(w, "Pet has medical records and cannot be deleted; set its status instead", http.StatusConflict, err)
+		return
 	}
-
-	_, err := db.DB.Exec("DELETE FROM pets WHERE id=$1", id)
 	if err != nil {
 		ErrorResponse(w, "Failed to delete pet", http.StatusInternalServerError, err)
 		return
@@ -0,0 +1,390 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"pet-clinic/db"
+	"pet-clinic/models"
+	"pet-clinic/utils"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// roles an owner can be given for a pet besides primary (which only moves by transfer)
+var secondaryPetRoles = map[string]bool{"co-owner": true, "caregiver": true}
+
+// petOwnerRole returns the owner's current role for the pet, "" for none.
+// sql.ErrNoRows means the pet does not exist.
+func petOwnerRole(petID, ownerID int) (string, error) {
+	var role string
+	err := db.DB.QueryRow(`SELECT COALESCE((
+			SELECT role FROM pet_owners WHERE pet_id = p.id AND owner_id = $2 AND removed_at IS NULL), '')
+		FROM pets p WHERE p.id = $1`, petID, ownerID).Scan(&role)
+	return role, err
+}
+
+// ownedPetsCondition restricts petColumn to pets the owner in placeholder $arg currently holds any role for
+func ownedPetsCondition(petColumn string, arg int) string {
+	return fmt.Sprintf("%s IN (SELECT pet_id FROM pet_owners WHERE owner_id = $%d::int AND removed_at IS NULL)", petColumn, arg)
+}
+
+// GetPetOwners - current owners and caregivers of a pet; ?history=true includes ended links
+func GetPetOwners(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	if _, _, ok := authorizePetAccess(w, r, petID); !ok {
+		return
+	}
+
+	query := `SELECT po.pet_id, po.owner_id, o.name, po.role, COALESCE(po.added_by, ''), po.added_at::text,
+			COALESCE(po.removed_by, ''), COALESCE(po.removed_at::text, '')
+		FROM pet_owners po JOIN owners o ON o.id = po.owner_id
+		WHERE po.pet_id = $1`
+	if r.URL.Query().Get("history") != "true" {
+		query += " AND po.removed_at IS NULL"
+	}
+	rows, err := db.DB.Query(query+" ORDER BY po.removed_at IS NOT NULL, po.role = 'primary' DESC, po.added_at", petID)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch pet owners", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	list := []models.PetOwner{}
+	for rows.Next() {
+		var po models.PetOwner
+		if err := rows.Scan(&po.PetID, &po.OwnerID, &po.OwnerName, &po.Role, &po.AddedBy, &po.AddedAt, &po.RemovedBy, &po.RemovedAt); err != nil {
+			ErrorResponse(w, "Error scanning pet owners", http.StatusInternalServerError, err)
+			return
+		}
+		list = append(list, po)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// AddPetOwner - the primary owner or staff add a co-owner or caregiver
+func AddPetOwner(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	username, _, ok := authorizePetRole(w, r, petID, "primary")
+	if !ok {
+		return
+	}
+
+	var po models.PetOwner
+	if err := json.NewDecoder(r.Body).Decode(&po); err != nil {
+		ErrorResponse(w, "Invalid pet owner input", http.StatusBadRequest, err)
+		return
+	}
+	if !secondaryPetRoles[po.Role] {
+		http.Error(w, "role must be co-owner or caregiver; primary ownership moves by transfer", http.StatusBadRequest)
+		return
+	}
+
+	err = db.DB.QueryRow(`INSERT INTO pet_owners (pet_id, owner_id, role, added_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING pet_id, owner_id, (SELECT name FROM owners WHERE id = $2), role, added_by, added_at::text`,
+		petID, po.OwnerID, po.Role, username).
+		Scan(&po.PetID, &po.OwnerID, &po.OwnerName, &po.Role, &po.AddedBy, &po.AddedAt)
+	if pqErr, ok := err.(*pq.Error); ok {
+		switch pqErr.Code {
+		case "23505":
+			http.Error(w, "Owner is already linked to this pet", http.StatusConflict)
+			return
+		case "23503":
+			http.Error(w, "Owner not found", http.StatusBadRequest)
+			return
+		}
+	}
+	if err != nil {
+		ErrorResponse(w, "Failed to add pet owner", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"pet_id": petID, "owner_id": po.OwnerID, "role": po.Role, "by": username}).Info("Pet owner added")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(po)
+}
+
+// RemovePetOwner - the primary owner or staff end a co-owner/caregiver link;
+// co-owners and caregivers may also remove themselves
+func RemovePetOwner(w http.ResponseWriter, r *http.Request) {
+	petID, err1 := strconv.Atoi(mux.Vars(r)["id"])
+	ownerID, err2 := strconv.Atoi(mux.Vars(r)["ownerId"])
+	if err1 != nil || err2 != nil {
+		http.Error(w, "Invalid id", http.StatusBadRequest)
+		return
+	}
+
+	username, role, ok := authorizePetAccess(w, r, petID)
+	if !ok {
+		return
+	}
+	if role == "owner" {
+		self, _ := ownerIDFromUsername(username)
+		if self != ownerID {
+			if _, _, ok := authorizePetRole(w, r, petID, "primary"); !ok {
+				return
+			}
+		}
+	}
+
+	result, err := db.DB.Exec(`UPDATE pet_owners SET removed_at=NOW(), removed_by=$1
+		WHERE pet_id=$2 AND owner_id=$3 AND removed_at IS NULL AND role <> 'primary'`, username, petID, ownerID)
+	if err != nil {
+		ErrorResponse(w, "Failed to remove pet owner", http.StatusInternalServerError, err)
+		return
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		http.Error(w, "No co-owner or caregiver link found; the primary owner changes by transfer", http.StatusNotFound)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"pet_id": petID, "owner_id": ownerID, "by": username}).Info("Pet owner removed")
+	w.Write([]byte("Pet owner removed"))
+}
+
+const petTransferColumns = `t.id, t.pet_id, p.name, t.from_owner_id, t.to_owner_id, COALESCE(t.note, ''), t.status,
+	COALESCE(t.initiated_by, ''), t.created_at::text, COALESCE(t.decided_by, ''), COALESCE(t.decided_at::text, '')`
+
+func scanPetTransfer(row interface{ Scan(...interface{}) error }, t *models.PetTransfer) error {
+	return row.Scan(&t.ID, &t.PetID, &t.PetName, &t.FromOwnerID, &t.ToOwnerID, &t.Note, &t.Status,
+		&t.InitiatedBy, &t.CreatedAt, &t.DecidedBy, &t.DecidedAt)
+}
+
+// InitiatePetTransfer - the primary owner (or staff) offers the pet to a new owner
+func InitiatePetTransfer(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	username, _, ok := authorizePetRole(w, r, petID, "primary")
+	if !ok {
+		return
+	}
+
+	var t models.PetTransfer
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil || t.ToOwnerID == 0 {
+		http.Error(w, "to_owner_id is required", http.StatusBadRequest)
+		return
+	}
+
+	err = db.DB.QueryRow(`WITH t AS (
+			INSERT INTO pet_transfers (pet_id, from_owner_id, to_owner_id, note, initiated_by)
+			SELECT id, owner_id, $2, NULLIF($3, ''), $4 FROM pets WHERE id = $1 AND owner_id <> $2
+			RETURNING *)
+		SELECT `+petTransferColumns+` FROM t JOIN pets p ON p.id = t.pet_id`,
+		petID, t.ToOwnerID, t.Note, username).Scan(
+		&t.ID, &t.PetID, &t.PetName, &t.FromOwnerID, &t.ToOwnerID, &t.Note, &t.Status,
+		&t.InitiatedBy, &t.CreatedAt, &t.DecidedBy, &t.DecidedAt)
+	if err == sql.ErrNoRows {
+		http.Error(w, "The new owner is already the primary owner", http.StatusBadRequest)
+		return
+	}
+	if pqErr, ok := err.(*pq.Error); ok {
+		switch pqErr.Code {
+		case "23505":
+			http.Error(w, "A transfer for this pet is already pending", http.StatusConflict)
+			return
+		case "23503":
+			http.Error(w, "New owner not found", http.StatusBadRequest)
+			return
+		}
+	}
+	if err != nil {
+		ErrorResponse(w, "Failed to start transfer", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"id": t.ID, "pet_id": petID, "from": t.FromOwnerID, "to": t.ToOwnerID}).Info("Pet transfer initiated")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+// GetPetTransfers - owners see transfers to or from them, staff see all; ?status= filters
+func GetPetTransfers(w http.ResponseWriter, r *http.Request) {
+	username, role, ok := getUserFromRequest(r)
+	if !ok {
+		ErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	query := `SELECT ` + petTransferColumns + ` FROM pet_transfers t JOIN pets p ON p.id = t.pet_id WHERE TRUE`
+	var args []interface{}
+	if role == "owner" {
+		ownerID, valid := ownerIDFromUsername(username)
+		if !valid {
+			http.Error(w, "Invalid owner identity", http.StatusForbidden)
+			return
+		}
+		args = append(args, ownerID)
+		query += " AND (t.from_owner_id = $1 OR t.to_owner_id = $1)"
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		args = append(args, status)
+		query += " AND t.status = $" + strconv.Itoa(len(args))
+	}
+
+	rows, err := db.DB.Query(query+" ORDER BY t.created_at DESC", args...)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch transfers", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	list := []models.PetTransfer{}
+	for rows.Next() {
+		var t models.PetTransfer
+		if err := scanPetTransfer(rows, &t); err != nil {
+			ErrorResponse(w, "Error scanning transfers", http.StatusInternalServerError, err)
+			return
+		}
+		list = append(list, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// decidePetTransfer locks a pending transfer and checks the caller may take the decision.
+// party is "to" for accept/decline and "from" for cancel; staff may always decide.
+func decidePetTransfer(w http.ResponseWriter, r *http.Request, tx *sql.Tx, party string) (t models.PetTransfer, username string, ok bool) {
+	username, role, ok := getUserFromRequest(r)
+	if !ok {
+		ErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return t, "", false
+	}
+
+	err := scanPetTransfer(tx.QueryRow(`SELECT `+petTransferColumns+`
+		FROM pet_transfers t JOIN pets p ON p.id = t.pet_id
+		WHERE t.id = $1 FOR UPDATE OF t`, mux.Vars(r)["id"]), &t)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Transfer not found", http.StatusNotFound)
+		return t, "", false
+	}
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch transfer", http.StatusInternalServerError, err)
+		return t, "", false
+	}
+
+	if role == "owner" {
+		ownerID, _ := ownerIDFromUsername(username)
+		expected := t.ToOwnerID
+		if party == "from" {
+			expected = t.FromOwnerID
+		}
+		if ownerID != expected {
+			http.Error(w, "This transfer is not yours to decide", http.StatusForbidden)
+			return t, "", false
+		}
+	}
+	if t.Status != "pending" {
+		http.Error(w, "Transfer is already "+t.Status, http.StatusConflict)
+		return t, "", false
+	}
+	return t, username, true
+}
+
+// AcceptPetTransfer - the new owner (or staff on their behalf) takes over as primary.
+// All other current links end; the new family adds its own co-owners and caregivers.
+func AcceptPetTransfer(w http.ResponseWriter, r *http.Request) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		ErrorResponse(w, "Failed to start transaction", http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	t, username, ok := decidePetTransfer(w, r, tx, "to")
+	if !ok {
+		return
+	}
+
+	var currentOwner int
+	if err := tx.QueryRow("SELECT owner_id FROM pets WHERE id=$1 FOR UPDATE", t.PetID).Scan(&currentOwner); err != nil {
+		ErrorResponse(w, "Pet not found", http.StatusNotFound, err)
+		return
+	}
+	if currentOwner != t.FromOwnerID {
+		http.Error(w, "Pet has changed owner since the transfer was started", http.StatusConflict)
+		return
+	}
+
+	steps := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`UPDATE pet_owners SET removed_at=NOW(), removed_by=$1
+			WHERE pet_id=$2 AND removed_at IS NULL`, []interface{}{username, t.PetID}},
+		{`INSERT INTO pet_owners (pet_id, owner_id, role, added_by) VALUES ($1, $2, 'primary', $3)`,
+			[]interface{}{t.PetID, t.ToOwnerID, username}},
+		{`UPDATE pets SET owner_id=$1 WHERE id=$2`, []interface{}{t.ToOwnerID, t.PetID}},
+		{`UPDATE attachments SET owner_id=$1 WHERE pet_id=$2`, []interface{}{t.ToOwnerID, t.PetID}},
+		{`UPDATE pet_transfers SET status='accepted', decided_by=$1, decided_at=NOW() WHERE id=$2`,
+			[]interface{}{username, t.ID}},
+	}
+	for _, step := range steps {
+		if _, err := tx.Exec(step.query, step.args...); err != nil {
+			ErrorResponse(w, "Failed to complete transfer", http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		ErrorResponse(w, "Failed to complete transfer", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"id": t.ID, "pet_id": t.PetID, "from": t.FromOwnerID, "to": t.ToOwnerID, "by": username}).Info("Pet transfer accepted")
+	w.Write([]byte("Transfer accepted"))
+}
+
+// DeclinePetTransfer - the new owner (or staff) turns the transfer down
+func DeclinePetTransfer(w http.ResponseWriter, r *http.Request) {
+	closePetTransfer(w, r, "to", "declined")
+}
+
+// CancelPetTransfer - the current owner (or staff) withdraws a pending transfer
+func CancelPetTransfer(w http.ResponseWriter, r *http.Request) {
+	closePetTransfer(w, r, "from", "cancelled")
+}
+
+func closePetTransfer(w http.ResponseWriter, r *http.Request, party, status string) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		ErrorResponse(w, "Failed to start transaction", http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	t, username, ok := decidePetTransfer(w, r, tx, party)
+	if !ok {
+		return
+	}
+	if _, err := tx.Exec(`UPDATE pet_transfers SET status=$1, decided_by=$2, decided_at=NOW() WHERE id=$3`,
+		status, username, t.ID); err != nil {
+		ErrorResponse(w, "Failed to update transfer", http.StatusInternalServerError, err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		ErrorResponse(w, "Failed to update transfer", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"id": t.ID, "pet_id": t.PetID, "status": status, "by": username}).Info("Pet transfer closed")
+	w.Write([]byte("Transfer " + status))
+}
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"pet-clinic/db"
+	"pet-clinic/models"
+	"pet-clinic/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var petStatuses = map[string]bool{"active": true, "deceased": true, "transferred": true, "lost": true}
+
+// requireActivePet refuses new bookings for deceased, transferred or lost pets;
+// writes the error response itself
+func requireActivePet(w http.ResponseWriter, petID int) bool {
+	var status string
+	err := db.DB.QueryRow("SELECT status FROM pets WHERE id=$1", petID).Scan(&status)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Pet not found", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		ErrorResponse(w, "Failed to check pet status", http.StatusInternalServerError, err)
+		return false
+	}
+	if status != "active" {
+		http.Error(w, "Pet is "+status+" and cannot be booked", http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// cancelForDeceasedPet cancels future appointments, open waitlist entries and
+// pending reminders of a pet. Returns the freed slots so they can go to the waitlist.
+func cancelForDeceasedPet(tx *sql.Tx, petID int) (slots []freedSlot, reminders int, err error) {
+	rows, err := tx.Query(`UPDATE appointments SET status='cancelled', sequence=sequence+1, updated_at=NOW()
+		WHERE pet_id=$1 AND status <> 'cancelled' AND (date + time) > $2::timestamp
+		RETURNING date::text, to_char(time, 'HH24:MI'), COALESCE(vet, ''), COALESCE(appointment_type, '')`,
+		petID, time.Now().In(utils.ClinicLocation()).Format(localTimestampFormat))
+	if err != nil {
+		return nil, 0, err
+	}
+	for rows.Next() {
+		var s freedSlot
+		if err := rows.Scan(&s.Date, &s.Time, &s.Vet, &s.Type); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		slots = append(slots, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if _, err := tx.Exec(`UPDATE waitlist_offers SET status='declined'
+		WHERE status='pending' AND entry_id IN (SELECT id FROM waitlist_entries WHERE pet_id=$1)`, petID); err != nil {
+		return nil, 0, err
+	}
+	if _, err := tx.Exec(`UPDATE waitlist_entries SET status='cancelled'
+		WHERE pet_id=$1 AND status IN ('waiting', 'offered')`, petID); err != nil {
+		return nil, 0, err
+	}
+
+	result, err := tx.Exec(`UPDATE reminder_sends SET status='cancelled'
+		WHERE status IN ('pending', 'failed')
+		  AND (appointment_id IN (SELECT id FROM appointments WHERE pet_id=$1)
+		    OR vaccination_id IN (SELECT id FROM vaccinations WHERE pet_id=$1))`, petID)
+	if err != nil {
+		return nil, 0, err
+	}
+	count, _ := result.RowsAffected()
+	return slots, int(count), nil
+}
+
+// SetPetStatus - mark a pet deceased, transferred, lost or active again.
+// Primary owners and co-owners may report their pet deceased or lost; caregivers may
+// not. Transfers and corrections of a deceased status are staff-only. Marking a pet deceased cancels its future
+// appointments, waitlist entries and pending reminders.
+func SetPetStatus(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	username, role, ok := authorizePetManage(w, r, petID)
+	if !ok {
+		return
+	}
+
+	var c models.PetStatusChange
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		ErrorResponse(w, "Invalid status input", http.StatusBadRequest, err)
+		return
+	}
+	c.Status = strings.ToLower(strings.TrimSpace(c.Status))
+	if !petStatuses[c.Status] {
+		http.Error(w, "status must be active, deceased, transferred or lost", http.StatusBadRequest)
+		return
+	}
+	today := time.Now().In(utils.ClinicLocation()).Format("2006-01-02")
+	if c.Status == "deceased" && c.Date == "" {
+		c.Date = today
+	}
+	if c.Date != "" {
+		// compared as clinic-local dates; YYYY-MM-DD sorts as text
+		if _, err := time.Parse("2006-01-02", c.Date); err != nil || c.Date > today {
+			http.Error(w, "date must be a past date (YYYY-MM-DD)", http.StatusBadRequest)
+			return
+		}
+	}
+	if c.Status != "deceased" {
+		c.CauseOfDeath = ""
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		ErrorResponse(w, "Failed to start transaction", http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var current string
+	if err := tx.QueryRow("SELECT status FROM pets WHERE id=$1 FOR UPDATE", petID).Scan(&current); err != nil {
+		ErrorResponse(w, "Pet not found", http.StatusNotFound, err)
+		return
+	}
+	if role != "staff" && (c.Status == "transferred" || current == "deceased") {
+		http.Error(w, "Only staff can transfer a pet or change a deceased status", http.StatusForbidden)
+		return
+	}
+
+	_, err = tx.Exec(`UPDATE pets SET status=$1, status_date=NULLIF($2, '')::date, cause_of_death=NULLIF($3, ''),
+		status_note=NULLIF($4, '') WHERE id=$5`, c.Status, c.Date, c.CauseOfDeath, c.Note, petID)
+	if err != nil {
+		ErrorResponse(w, "Failed to update pet status", http.StatusInternalServerError, err)
+		return
+	}
+	err = tx.QueryRow(`INSERT INTO pet_status_changes (pet_id, status, status_date, cause_of_death, note, changed_by)
+		VALUES ($1, $2, NULLIF($3, '')::date, NULLIF($4, ''), NULLIF($5, ''), $6) RETURNING id, changed_at::text`,
+		petID, c.Status, c.Date, c.CauseOfDeath, c.Note, username).Scan(&c.ID, &c.ChangedAt)
+	if err != nil {
+		ErrorResponse(w, "Failed to record status change", http.StatusInternalServerError, err)
+		return
+	}
+
+	var freed []freedSlot
+	if c.Status == "deceased" && current != "deceased" {
+		freed, c.CancelledReminders, err = cancelForDeceasedPet(tx, petID)
+		if err != nil {
+			ErrorResponse(w, "Failed to cancel appointments", http.StatusInternalServerError, err)
+			return
+		}
+		c.CancelledAppointments = len(freed)
+	}
+
+	if err := tx.Commit(); err != nil {
+		ErrorResponse(w, "Failed to update pet status", http.StatusInternalServerError, err)
+		return
+	}
+
+	// no cancellation emails go to the family; the slots are only passed on to the waitlist
+	for _, s := range freed {
+		go offerFreedSlot(s)
+	}
+
+	c.PetID = petID
+	c.ChangedBy = username
+	utils.Log.WithFields(map[string]interface{}{
+		"pet_id": petID, "from": current, "to": c.Status, "by": username,
+		"cancelled_appointments": c.CancelledAppointments,
+	}).Info("Pet status changed")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// GetPetStatusHistory - lifecycle changes of a pet, newest first
+func GetPetStatusHistory(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	if _, _, ok := authorizePetAccess(w, r, petID); !ok {
+		return
+	}
+
+	rows, err := db.DB.Query(`SELECT id, pet_id, status, COALESCE(status_date::text, ''), COALESCE(cause_of_death, ''),
+			COALESCE(note, ''), COALESCE(changed_by, ''), changed_at::text
+		FROM pet_status_changes WHERE pet_id=$1 ORDER BY changed_at DESC, id DESC`, petID)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch status history", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	list := []models.PetStatusChange{}
+	for rows.Next() {
+		var c models.PetStatusChange
+		if err := rows.Scan(&c.ID, &c.PetID, &c.Status, &c.Date, &c.CauseOfDeath, &c.Note, &c.ChangedBy, &c.ChangedAt); err != nil {
+			ErrorResponse(w, "Error scanning status history", http.StatusInternalServerError, err)
+			return
+		}
+		list = append(list, c)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
@@ -0,0 +1,457 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"pet-clinic/db"
+	"pet-clinic/models"
+	"pet-clinic/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+var medicationRoutes = map[string]bool{
+	"oral": true, "topical": true, "subcutaneous": true, "intramuscular": true,
+	"intravenous": true, "ophthalmic": true, "otic": true, "inhaled": true, "rectal": true,
+}
+
+const prescriptionColumns = `id, pet_id, visit_id, drug, COALESCE(drug_class, ''), dose, frequency, route, duration_days,
+	refills_allowed, refills_used, COALESCE(instructions, ''), prescribing_vet, COALESCE(allergy_override, ''), status,
+	COALESCE(created_by, ''), created_at::text`
+
+func scanPrescription(row interface{ Scan(...interface{}) error }, p *models.Prescription) error {
+	return row.Scan(&p.ID, &p.PetID, &p.VisitID, &p.Drug, &p.DrugClass, &p.Dose, &p.Frequency, &p.Route, &p.DurationDays,
+		&p.RefillsAllowed, &p.RefillsUsed, &p.Instructions, &p.PrescribingVet, &p.AllergyOverride, &p.Status,
+		&p.CreatedBy, &p.CreatedAt)
+}
+
+// loadPrescriptionPet finds the pet a prescription belongs to and checks the caller may see it
+func loadPrescriptionPet(w http.ResponseWriter, r *http.Request, id string) (petID int, username, role string, ok bool) {
+	if err := db.DB.QueryRow("SELECT pet_id FROM prescriptions WHERE id=$1", id).Scan(&petID); err != nil {
+		ErrorResponse(w, "Prescription not found", http.StatusNotFound, err)
+		return 0, "", "", false
+	}
+	username, role, ok = authorizePetAccess(w, r, petID)
+	return petID, username, role, ok
+}
+
+// AddPrescription - staff prescribe for a pet as part of a visit
+func AddPrescription(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	utils.Log.WithField("pet_id", petID).Debug("POST /pets/{id}/prescriptions called")
+
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	var p models.Prescription
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		ErrorResponse(w, "Invalid prescription input", http.StatusBadRequest, err)
+		return
+	}
+	p.Drug = strings.TrimSpace(p.Drug)
+	p.Route = strings.ToLower(strings.TrimSpace(p.Route))
+	if p.Drug == "" || p.Dose == "" || p.Frequency == "" || p.PrescribingVet == "" {
+		http.Error(w, "drug, dose, frequency and prescribing_vet are required", http.StatusBadRequest)
+		return
+	}
+	if !medicationRoutes[p.Route] {
+		http.Error(w, "Unknown route: "+p.Route, http.StatusBadRequest)
+		return
+	}
+	if p.RefillsAllowed < 0 || (p.DurationDays != nil && *p.DurationDays <= 0) {
+		http.Error(w, "refills_allowed must be >= 0 and duration_days > 0", http.StatusBadRequest)
+		return
+	}
+
+	var visitPetID int
+	if err := db.DB.QueryRow("SELECT pet_id FROM visits WHERE id=$1", p.VisitID).Scan(&visitPetID); err != nil || visitPetID != petID {
+		http.Error(w, "visit_id must be a visit of this pet", http.StatusBadRequest)
+		return
+	}
+
+	// allergy check: severe allergies block unless overridden with a reason, milder ones warn
+	p.DrugClass = drugClassFor(p.Drug, p.DrugClass)
+	blocking, warnings, err := checkDrugAllergies(petID, p.Drug, p.DrugClass)
+	if err != nil {
+		ErrorResponse(w, "Failed to check allergies", http.StatusInternalServerError, err)
+		return
+	}
+	for _, a := range append(blocking, warnings...) {
+		p.Warnings = append(p.Warnings, a.Severity+" allergy: "+a.Name)
+	}
+	if len(blocking) > 0 && strings.TrimSpace(p.AllergyOverride) == "" {
+		utils.Log.WithFields(map[string]interface{}{"pet_id": petID, "drug": p.Drug, "user": username}).Warn("Prescription blocked by allergy")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":    "Pet has a severe allergy to this drug; set allergy_override with a reason to prescribe anyway",
+			"warnings": p.Warnings,
+		})
+		return
+	}
+	if len(blocking) > 0 {
+		utils.Log.WithFields(map[string]interface{}{"pet_id": petID, "drug": p.Drug, "user": username, "reason": p.AllergyOverride}).Warn("Allergy block overridden")
+	}
+
+	p.PetID = petID
+	p.Status = "active"
+	p.CreatedBy = username
+	err = db.DB.QueryRow(`INSERT INTO prescriptions (pet_id, visit_id, drug, drug_class, dose, frequency, route, duration_days,
+			refills_allowed, instructions, prescribing_vet, allergy_override, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NULLIF($12, ''), $13) RETURNING id, created_at::text`,
+		p.PetID, p.VisitID, p.Drug, p.DrugClass, p.Dose, p.Frequency, p.Route, p.DurationDays,
+		p.RefillsAllowed, p.Instructions, p.PrescribingVet, p.AllergyOverride, username).Scan(&p.ID, &p.CreatedAt)
+	if err != nil {
+		ErrorResponse(w, "Failed to create prescription", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"id": p.ID, "pet_id": petID, "drug": p.Drug, "vet": p.PrescribingVet}).Info("Prescription created")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(p)
+}
+
+// GetPetPrescriptions - all prescriptions of a pet, newest first
+func GetPetPrescriptions(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	if _, _, ok := authorizePetAccess(w, r, petID); !ok {
+		return
+	}
+
+	list, err := loadPetPrescriptions(petID)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch prescriptions", http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+func loadPetPrescriptions(petID int) ([]models.Prescription, error) {
+	rows, err := db.DB.Query(`SELECT `+prescriptionColumns+` FROM prescriptions WHERE pet_id=$1 ORDER BY created_at DESC`, petID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []models.Prescription{}
+	for rows.Next() {
+		var p models.Prescription
+		if err := scanPrescription(rows, &p); err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
+
+// UpdatePrescriptionStatus - staff complete or discontinue a prescription
+func UpdatePrescriptionStatus(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Status string `json:"status"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	if body.Status != "active" && body.Status != "completed" && body.Status != "discontinued" {
+		http.Error(w, "status must be active, completed or discontinued", http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.DB.Exec("UPDATE prescriptions SET status=$1 WHERE id=$2", body.Status, id)
+	if err != nil {
+		ErrorResponse(w, "Failed to update prescription", http.StatusInternalServerError, err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Prescription not found", http.StatusNotFound)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"id": id, "status": body.Status, "user": username}).Info("Prescription status updated")
+	w.Write([]byte("Prescription updated"))
+}
+
+// RecordAdministration - staff log a dose given (or missed/refused) to an inpatient
+func RecordAdministration(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	var a models.MedicationAdministration
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		ErrorResponse(w, "Invalid administration input", http.StatusBadRequest, err)
+		return
+	}
+	if a.Outcome == "" {
+		a.Outcome = "given"
+	}
+	if a.Outcome != "given" && a.Outcome != "missed" && a.Outcome != "refused" {
+		http.Error(w, "outcome must be given, missed or refused", http.StatusBadRequest)
+		return
+	}
+
+	var status, dose, route string
+	if err := db.DB.QueryRow("SELECT status, dose, route FROM prescriptions WHERE id=$1", id).Scan(&status, &dose, &route); err != nil {
+		ErrorResponse(w, "Prescription not found", http.StatusNotFound, err)
+		return
+	}
+	if status != "active" {
+		http.Error(w, "Prescription is "+status, http.StatusConflict)
+		return
+	}
+	if a.DoseGiven == "" {
+		a.DoseGiven = dose
+	}
+	if a.Route == "" {
+		a.Route = route
+	}
+	var administeredAt interface{}
+	if a.AdministeredAt != "" {
+		t, err := parseLocalTimestamp(a.AdministeredAt)
+		if err != nil {
+			http.Error(w, "administered_at must be YYYY-MM-DDTHH:MM", http.StatusBadRequest)
+			return
+		}
+		administeredAt = t.Format(localTimestampFormat)
+	} else {
+		administeredAt = time.Now().In(utils.ClinicLocation()).Format(localTimestampFormat)
+	}
+
+	err := db.DB.QueryRow(`INSERT INTO medication_administrations (prescription_id, administered_at, dose_given, route, outcome, notes, administered_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, prescription_id, administered_at::text`,
+		id, administeredAt, a.DoseGiven, a.Route, a.Outcome, a.Notes, username).Scan(&a.ID, &a.PrescriptionID, &a.AdministeredAt)
+	if err != nil {
+		ErrorResponse(w, "Failed to record administration", http.StatusInternalServerError, err)
+		return
+	}
+	a.AdministeredBy = username
+
+	utils.Log.WithFields(map[string]interface{}{"prescription_id": id, "outcome": a.Outcome, "user": username}).Info("Medication administration recorded")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(a)
+}
+
+// GetAdministrations - administration log of a prescription
+func GetAdministrations(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, _, _, ok := loadPrescriptionPet(w, r, id); !ok {
+		return
+	}
+
+	rows, err := db.DB.Query(`SELECT id, prescription_id, administered_at::text, dose_given, COALESCE(route, ''), outcome,
+			COALESCE(notes, ''), administered_by
+		FROM medication_administrations WHERE prescription_id=$1 ORDER BY administered_at`, id)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch administrations", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	list := []models.MedicationAdministration{}
+	for rows.Next() {
+		var a models.MedicationAdministration
+		if err := rows.Scan(&a.ID, &a.PrescriptionID, &a.AdministeredAt, &a.DoseGiven, &a.Route, &a.Outcome, &a.Notes, &a.AdministeredBy); err != nil {
+			ErrorResponse(w, "Error scanning administrations", http.StatusInternalServerError, err)
+			return
+		}
+		list = append(list, a)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// RequestRefill - owners ask for a refill of an active prescription with refills left
+func RequestRefill(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	_, username, _, ok := loadPrescriptionPet(w, r, id)
+	if !ok {
+		return
+	}
+
+	var req models.RefillRequest
+	json.NewDecoder(r.Body).Decode(&req)
+
+	var status string
+	var allowed, used int
+	var pending bool
+	err := db.DB.QueryRow(`SELECT status, refills_allowed, refills_used,
+			EXISTS (SELECT 1 FROM refill_requests WHERE prescription_id=$1 AND status='pending')
+		FROM prescriptions WHERE id=$1`, id).Scan(&status, &allowed, &used, &pending)
+	if err != nil {
+		ErrorResponse(w, "Failed to request refill", http.StatusInternalServerError, err)
+		return
+	}
+	switch {
+	case status != "active":
+		http.Error(w, "Prescription is "+status, http.StatusConflict)
+		return
+	case used >= allowed:
+		http.Error(w, "No refills remaining", http.StatusConflict)
+		return
+	case pending:
+		http.Error(w, "A refill request is already pending", http.StatusConflict)
+		return
+	}
+
+	// the partial unique index catches a request that raced past the check above
+	err = db.DB.QueryRow(`INSERT INTO refill_requests (prescription_id, note, requested_by)
+		VALUES ($1, $2, $3) RETURNING id, prescription_id, status, requested_at::text`,
+		id, req.Note, username).Scan(&req.ID, &req.PrescriptionID, &req.Status, &req.RequestedAt)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		http.Error(w, "A refill request is already pending", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		ErrorResponse(w, "Failed to request refill", http.StatusInternalServerError, err)
+		return
+	}
+	req.RequestedBy = username
+
+	utils.Log.WithFields(map[string]interface{}{"id": req.ID, "prescription_id": id, "user": username}).Info("Refill requested")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(req)
+}
+
+// GetRefillRequests - staff see all requests, owners their own pets'; filter with ?status=
+func GetRefillRequests(w http.ResponseWriter, r *http.Request) {
+	username, role, ok := getUserFromRequest(r)
+	if !ok {
+		ErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	query := `SELECT rr.id, rr.prescription_id, p.pet_id, p.drug, COALESCE(rr.note, ''), rr.status, rr.requested_by,
+			rr.requested_at::text, COALESCE(rr.decided_by, ''), COALESCE(rr.decided_at::text, ''), COALESCE(rr.decision_note, '')
+		FROM refill_requests rr
+		JOIN prescriptions p ON p.id = rr.prescription_id
+		JOIN pets pt ON pt.id = p.pet_id
+		WHERE TRUE`
+	var args []interface{}
+	if role == "owner" {
+		ownerID, valid := ownerIDFromUsername(username)
+		if !valid {
+			http.Error(w, "Invalid owner identity", http.StatusForbidden)
+			return
+		}
+		args = append(args, ownerID)
+		query += " AND " + ownedPetsCondition("pt.id", len(args))
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		args = append(args, status)
+		query += " AND rr.status = $" + strconv.Itoa(len(args))
+	}
+	query += " ORDER BY rr.requested_at"
+
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch refill requests", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	list := []models.RefillRequest{}
+	for rows.Next() {
+		var rr models.RefillRequest
+		if err := rows.Scan(&rr.ID, &rr.PrescriptionID, &rr.PetID, &rr.Drug, &rr.Note, &rr.Status, &rr.RequestedBy,
+			&rr.RequestedAt, &rr.DecidedBy, &rr.DecidedAt, &rr.DecisionNote); err != nil {
+			ErrorResponse(w, "Error scanning refill requests", http.StatusInternalServerError, err)
+			return
+		}
+		list = append(list, rr)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// decideRefill approves or denies a pending request; approval uses up one refill
+func decideRefill(w http.ResponseWriter, r *http.Request, decision string) {
+	id := mux.Vars(r)["id"]
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Note string `json:"note"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		ErrorResponse(w, "Failed to decide refill request", http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	var prescriptionID int
+	err = tx.QueryRow(`UPDATE refill_requests SET status=$1, decided_by=$2, decided_at=NOW(), decision_note=$3
+		WHERE id=$4 AND status='pending' RETURNING prescription_id`,
+		decision, username, body.Note, id).Scan(&prescriptionID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "No pending refill request with that id", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ErrorResponse(w, "Failed to decide refill request", http.StatusInternalServerError, err)
+		return
+	}
+
+	if decision == "approved" {
+		result, err := tx.Exec(`UPDATE prescriptions SET refills_used = refills_used + 1
+			WHERE id=$1 AND status='active' AND refills_used < refills_allowed`, prescriptionID)
+		if err != nil {
+			ErrorResponse(w, "Failed to approve refill", http.StatusInternalServerError, err)
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			http.Error(w, "Prescription is no longer active or has no refills left", http.StatusConflict)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		ErrorResponse(w, "Failed to decide refill request", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"id": id, "prescription_id": prescriptionID, "decision": decision, "user": username}).Info("Refill request decided")
+	w.Write([]byte("Refill request " + decision))
+}
+
+// ApproveRefill - staff approve a pending refill request
+func ApproveRefill(w http.ResponseWriter, r *http.Request) {
+	decideRefill(w, r, "approved")
+}
+
+// DenyRefill - staff deny a pending refill request
+func DenyRefill(w http.ResponseWriter, r *http.Request) {
+	decideRefill(w, r, "denied")
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"pet-clinic/db"
+	"pet-clinic/imaging"
+	"pet-clinic/storage"
+	"pet-clinic/utils"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// maxPreviewSource is the largest original the preview worker loads into memory
+const maxPreviewSource = 100 << 20
+
+// previewKey is per blob, so each version of an attachment has its own previews
+func previewKey(blobKey, variant string) string {
+	return "previews/" + blobKey + "/" + variant
+}
+
+// RunPreviewWorker makes thumbnails and previews for newly uploaded images and PDFs.
+// Rows are claimed with SKIP LOCKED, so several replicas can run it side by side;
+// a claim older than ten minutes (a crashed worker) is picked up again.
+func RunPreviewWorker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for processPreviews(5) > 0 {
+		}
+	}
+}
+
+// processPreviews handles up to limit queued attachments and returns how many it claimed
+func processPreviews(limit int) int {
+	rows, err := db.DB.Query(`UPDATE attachments SET preview_status='processing', preview_claimed_at=NOW()
+		WHERE id IN (
+			SELECT id FROM attachments
+			WHERE preview_status='pending'
+			   OR preview_status='processing' AND preview_claimed_at < NOW() - INTERVAL '10 minutes'
+			ORDER BY uploaded_at LIMIT $1 FOR UPDATE SKIP LOCKED)
+		RETURNING id, blob_key, content_type, size_bytes`, limit)
+	if err != nil {
+		utils.Log.WithError(err).Error("Failed to claim attachments for previews")
+		return 0
+	}
+	type job struct {
+		id, blobKey, contentType string
+		size                     int64
+	}
+	var jobs []job
+	for rows.Next() {
+		var j job
+		if err := rows.Scan(&j.id, &j.blobKey, &j.contentType, &j.size); err == nil {
+			jobs = append(jobs, j)
+		}
+	}
+	rows.Close()
+
+	for _, j := range jobs {
+		status, errText := "ready", ""
+		if err := makePreviews(j.blobKey, j.contentType, j.size); err != nil {
+			status, errText = "failed", err.Error()
+			if errors.Is(err, imaging.ErrUnsupported) {
+				status = "none"
+			}
+			utils.Log.WithError(err).WithField("id", j.id).Warn("Preview generation failed")
+		}
+		// a new version uploaded meanwhile has been queued again; leave it alone
+		if _, err := db.DB.Exec(`UPDATE attachments SET preview_status=$3, preview_error=NULLIF($4, ''), preview_claimed_at=NULL
+			WHERE id=$1 AND blob_key=$2`, j.id, j.blobKey, status, errText); err != nil {
+			utils.Log.WithError(err).WithField("id", j.id).Error("Failed to record preview status")
+		}
+	}
+	return len(jobs)
+}
+
+func makePreviews(blobKey, contentType string, size int64) error {
+	if size > maxPreviewSource {
+		return errors.New("file too large to preview")
+	}
+	store, err := storage.Default()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	rc, err := store.Get(ctx, blobKey)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(rc, maxPreviewSource))
+	rc.Close()
+	if err != nil {
+		return err
+	}
+
+	images, err := imaging.Render(ctx, data, contentType)
+	if err != nil {
+		return err
+	}
+	for _, v := range imaging.Variants {
+		// a retry after a crash, or a restored version, may find the variant already written
+		if _, err := store.Stat(ctx, previewKey(blobKey, v.Name)); err == nil {
+			continue
+		}
+		img := images[v.Name]
+		if err := store.Put(ctx, previewKey(blobKey, v.Name), bytes.NewReader(img), int64(len(img))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAttachmentPreview - GET /api/attachments/{id}/preview?size=thumb|preview serves
+// the generated JPEG of the current version; same access rules as DownloadFile.
+// The ETag names the version's blob, so clients revalidate cheaply and pick up
+// a new version as soon as it is uploaded.
+func GetAttachmentPreview(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	variant := r.URL.Query().Get("size")
+	if variant == "" {
+		variant = "thumb"
+	}
+	known := false
+	for _, v := range imaging.Variants {
+		known = known || v.Name == variant
+	}
+	if !known {
+		http.Error(w, "size must be thumb or preview", http.StatusBadRequest)
+		return
+	}
+	if !validFileID(id) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	a, err := loadAttachment(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ErrorResponse(w, "Error reading preview", http.StatusInternalServerError, err)
+		return
+	}
+	if _, _, ok := authorizePetManage(w, r, a.PetID); !ok {
+		return
+	}
+
+	switch a.PreviewStatus {
+	case "ready":
+	case "pending", "processing":
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, "Preview not ready yet", http.StatusNotFound)
+		return
+	default:
+		http.Error(w, "No preview for this file", http.StatusNotFound)
+		return
+	}
+
+	etag := `"` + a.BlobKey + "-" + variant + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	store, err := storage.Default()
+	if err != nil {
+		ErrorResponse(w, "Error reading preview", http.StatusInternalServerError, err)
+		return
+	}
+	key := previewKey(a.BlobKey, variant)
+	info, err := store.Stat(r.Context(), key)
+	if err == nil {
+		var rc io.ReadCloser
+		if rc, err = store.Get(r.Context(), key); err == nil {
+			defer rc.Close()
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			io.Copy(w, rc)
+			return
+		}
+	}
+	w.Header().Del("ETag")
+	w.Header().Del("Cache-Control")
+	if errors.Is(err, storage.ErrNotExist) {
+		http.Error(w, "No preview for this file", http.StatusNotFound)
+		return
+	}
+	ErrorResponse(w, "Error reading preview", http.StatusInternalServerError, err)
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"pet-clinic/db"
+	"pet-clinic/models"
+	"pet-clinic/scan"
+	"pet-clinic/storage"
+	"pet-clinic/utils"
+
+	"github.com/gorilla/mux"
+)
+
+// scanFile runs the whole upload through the scanner from the start
+func scanFile(ctx context.Context, scanner scan.Scanner, file multipart.File) (scan.Result, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return scan.Result{}, err
+	}
+	return scanner.Scan(ctx, file)
+}
+
+// quarantineUpload keeps the content read from r (a.Size bytes) under quarantine/<id>,
+// out of reach of the download routes, and records it for staff review. Failures
+// are only logged: the upload is rejected either way.
+func quarantineUpload(ctx context.Context, store storage.BlobStore, r io.Reader, a models.Attachment, signature string) {
+	log := utils.Log.WithFields(map[string]interface{}{"id": a.ID, "pet_id": a.PetID, "filename": a.Filename, "signature": signature, "user": a.UploadedBy})
+	log.Warn("Malware detected in upload, quarantining")
+
+	hash := sha256.New()
+	counter := &countingReader{r: io.TeeReader(r, hash)}
+	if err := store.Put(ctx, "quarantine/"+a.ID, counter, a.Size); err != nil {
+		log.WithError(err).Error("Could not quarantine upload")
+		return
+	}
+	_, err := db.DB.Exec(`INSERT INTO quarantined_files (id, pet_id, filename, content_type, size_bytes, sha256, signature, uploaded_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''))`,
+		a.ID, a.PetID, a.Filename, a.ContentType, counter.n, hex.EncodeToString(hash.Sum(nil)), signature, a.UploadedBy)
+	if err != nil {
+		log.WithError(err).Error("Could not record quarantined upload")
+	}
+}
+
+// GetQuarantinedFiles - staff list of rejected uploads, newest first
+func GetQuarantinedFiles(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireStaff(w, r); !ok {
+		return
+	}
+
+	rows, err := db.DB.Query(`SELECT id, pet_id, filename, content_type, size_bytes, sha256, signature,
+			COALESCE(uploaded_by, ''), quarantined_at::text
+		FROM quarantined_files ORDER BY quarantined_at DESC`)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch quarantined files", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	list := []models.QuarantinedFile{}
+	for rows.Next() {
+		var q models.QuarantinedFile
+		if err := rows.Scan(&q.ID, &q.PetID, &q.Filename, &q.ContentType, &q.Size, &q.SHA256, &q.Signature,
+			&q.UploadedBy, &q.QuarantinedAt); err != nil {
+			ErrorResponse(w, "Error scanning quarantined files", http.StatusInternalServerError, err)
+			return
+		}
+		list = append(list, q)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// DeleteQuarantinedFile - staff purge of a quarantined upload and its content
+func DeleteQuarantinedFile(w http.ResponseWriter, r *http.Request) {
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if !validFileID(id) {
+		http.Error(w, "Quarantined file not found", http.StatusNotFound)
+		return
+	}
+
+	var found string
+	err := db.DB.QueryRow("SELECT id FROM quarantined_files WHERE id=$1", id).Scan(&found)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Quarantined file not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ErrorResponse(w, "Failed to delete quarantined file", http.StatusInternalServerError, err)
+		return
+	}
+
+	store, err := storage.Default()
+	if err == nil {
+		err = store.Delete(r.Context(), "quarantine/"+id)
+	}
+	if err != nil && !errors.Is(err, storage.ErrNotExist) {
+		ErrorResponse(w, "Failed to delete quarantined file", http.StatusInternalServerError, err)
+		return
+	}
+	if _, err := db.DB.Exec("DELETE FROM quarantined_files WHERE id=$1", id); err != nil {
+		ErrorResponse(w, "Failed to delete quarantined file", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"id": id, "user": username}).Info("Quarantined file purged")
+	w.WriteHeader(http.StatusNoContent)
+}
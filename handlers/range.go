@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rangeResult is how a Range header applies to a representation
+type rangeResult int
+
+const (
+	rangeIgnored       rangeResult = iota // absent, malformed or several ranges: send everything
+	rangeSatisfiable                      // send start..start+length-1 as 206
+	rangeUnsatisfiable                    // answer 416
+)
+
+// parseRange reads a single "bytes=a-b", "bytes=a-" or "bytes=-n" range against
+// size. Multi-range requests are served whole, which RFC 9110 allows; browsers
+// seeking in a video only ever ask for one range.
+func parseRange(header string, size int64) (start, length int64, result rangeResult) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, rangeIgnored
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, rangeIgnored
+	}
+
+	if first == "" {
+		// suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, rangeIgnored
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, rangeUnsatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, rangeSatisfiable
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, rangeIgnored
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, rangeIgnored
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return 0, 0, rangeUnsatisfiable
+	}
+	return start, end - start + 1, rangeSatisfiable
+}
+
+// etagMatches reports whether etag is in an If-None-Match or If-Match list.
+// Weak comparison ignores the W/ prefix; "*" matches any representation.
+func etagMatches(list, etag string, weak bool) bool {
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified applies If-None-Match, or If-Modified-Since when there is no
+// If-None-Match, to a GET or HEAD (RFC 9110 section 13.2.2)
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag, true)
+	}
+	if modTime.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !modTime.Truncate(time.Second).After(since)
+}
+
+// rangeStillValid applies If-Range: a range is only honoured if the client's
+// copy is the current one, otherwise the whole file is sent again
+func rangeStillValid(r *http.Request, etag string, modTime time.Time) bool {
+	ifRange := strings.TrimSpace(r.Header.Get("If-Range"))
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		// If-Range needs a strong match
+		return ifRange == etag
+	}
+	date, err := http.ParseTime(ifRange)
+	return err == nil && !modTime.IsZero() && modTime.Truncate(time.Second).Equal(date)
+}
+
+// parseDBTime reads a timestamp selected with ::text; zero if it does not parse
+func parseDBTime(value string) time.Time {
+	t, err := time.Parse("2006-01-02 15:04:05.999999999", value)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"pet-clinic/db"
+	"pet-clinic/models"
+	"pet-clinic/utils"
+)
+
+// GetReminderSends - staff view of every reminder send, optionally for one appointment or vaccination
+func GetReminderSends(w http.ResponseWriter, r *http.Request) {
+	utils.Log.Debug("GET /reminders called")
+
+	if _, ok := requireStaff(w, r); !ok {
+		return
+	}
+
+	query := `SELECT id, appointment_id, vaccination_id, offset_minutes, channel, recipient, status, attempts,
+		COALESCE(last_error, ''), COALESCE(sent_at::text, '')
+		FROM reminder_sends`
+	var args []interface{}
+	if apptID := r.URL.Query().Get("appointment_id"); apptID != "" {
+		query += " WHERE appointment_id = $1"
+		args = append(args, apptID)
+	} else if vaccinationID := r.URL.Query().Get("vaccination_id"); vaccinationID != "" {
+		query += " WHERE vaccination_id = $1"
+		args = append(args, vaccinationID)
+	}
+	query += " ORDER BY id DESC LIMIT 500"
+
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch reminders", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	sends := []models.ReminderSend{}
+	for rows.Next() {
+		var s models.ReminderSend
+		if err := rows.Scan(&s.ID, &s.AppointmentID, &s.VaccinationID, &s.OffsetMinutes, &s.Channel, &s.Recipient,
+			&s.Status, &s.Attempts, &s.LastError, &s.SentAt); err != nil {
+			ErrorResponse(w, "Error scanning reminder data", http.StatusInternalServerError, err)
+			return
+		}
+		sends = append(sends, s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sends)
+}
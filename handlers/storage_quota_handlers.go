@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"pet-clinic/db"
+	"pet-clinic/models"
+	"pet-clinic/utils"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// quotaFromEnv reads a byte limit; unset or 0 means unlimited (nil)
+func quotaFromEnv(name string) *int64 {
+	n, err := strconv.ParseInt(os.Getenv(name), 10, 64)
+	if err != nil || n <= 0 {
+		return nil
+	}
+	return &n
+}
+
+// ownerStorageQuota is the owner's own limit if staff set one, otherwise
+// STORAGE_QUOTA_OWNER_BYTES
+func ownerStorageQuota(ownerID int) (*int64, error) {
+	var quota sql.NullInt64
+	if err := db.DB.QueryRow("SELECT storage_quota_bytes FROM owners WHERE id=$1", ownerID).Scan(&quota); err != nil {
+		return nil, err
+	}
+	if quota.Valid {
+		return &quota.Int64, nil
+	}
+	return quotaFromEnv("STORAGE_QUOTA_OWNER_BYTES"), nil
+}
+
+// storageUsedExpr sums the stored bytes of pets matching ownerCond (on pets p):
+// every version's blob counted once (a restore shares its blob) plus resumable
+// uploads still in progress, which reserve their full length. Both halves go
+// through the pet's current owner, so a transfer moves the usage with the pet.
+// Previews are not counted.
+func storageUsedExpr(ownerCond string) string {
+	return `(COALESCE((SELECT SUM(size_bytes) FROM (
+			SELECT DISTINCT v.blob_key, v.size_bytes FROM attachment_versions v
+			JOIN attachments a ON a.id = v.attachment_id JOIN pets p ON p.id = a.pet_id
+			WHERE ` + ownerCond + `) blobs), 0)
+		+ COALESCE((SELECT SUM(s.upload_length) FROM upload_sessions s JOIN pets p ON p.id = s.pet_id
+			WHERE s.attachment_id IS NULL AND s.expires_at > NOW() AND ` + ownerCond + `), 0))::bigint`
+}
+
+// storageUsed returns the bytes held for one owner, or for the clinic when ownerID is nil
+func storageUsed(ownerID *int) (int64, error) {
+	var used int64
+	var err error
+	if ownerID == nil {
+		err = db.DB.QueryRow(`SELECT ` + storageUsedExpr("TRUE")).Scan(&used)
+	} else {
+		err = db.DB.QueryRow(`SELECT `+storageUsedExpr("p.owner_id = $1"), *ownerID).Scan(&used)
+	}
+	return used, err
+}
+
+// checkStorageQuota refuses an upload of size bytes for a pet that would take its
+// primary owner or the clinic (STORAGE_QUOTA_CLINIC_BYTES) over quota. Uploads
+// running at the same moment can overshoot a quota by at most their own size.
+func checkStorageQuota(w http.ResponseWriter, petID int, size int64) bool {
+	var ownerID sql.NullInt64
+	if err := db.DB.QueryRow("SELECT owner_id FROM pets WHERE id=$1", petID).Scan(&ownerID); err != nil {
+		ErrorResponse(w, "Could not check storage quota", http.StatusInternalServerError, err)
+		return false
+	}
+
+	if ownerID.Valid {
+		id := int(ownerID.Int64)
+		quota, err := ownerStorageQuota(id)
+		var used int64
+		if err == nil && quota != nil {
+			used, err = storageUsed(&id)
+		}
+		if err != nil {
+			ErrorResponse(w, "Could not check storage quota", http.StatusInternalServerError, err)
+			return false
+		}
+		if quota != nil && used+size > *quota {
+			utils.Log.WithFields(map[string]interface{}{"owner_id": id, "used": used, "quota": *quota, "size": size}).Warn("Upload refused: owner storage quota exceeded")
+			http.Error(w, fmt.Sprintf("Storage quota exceeded for this owner (%d of %d bytes used)", used, *quota), http.StatusInsufficientStorage)
+			return false
+		}
+	}
+
+	if quota := quotaFromEnv("STORAGE_QUOTA_CLINIC_BYTES"); quota != nil {
+		used, err := storageUsed(nil)
+		if err != nil {
+			ErrorResponse(w, "Could not check storage quota", http.StatusInternalServerError, err)
+			return false
+		}
+		if used+size > *quota {
+			utils.Log.WithFields(map[string]interface{}{"used": used, "quota": *quota, "size": size}).Warn("Upload refused: clinic storage quota exceeded")
+			http.Error(w, "Clinic storage quota exceeded", http.StatusInsufficientStorage)
+			return false
+		}
+	}
+	return true
+}
+
+// GetStorageUsage - owners see their own usage and quota; staff get the clinic
+// total and every owner
+func GetStorageUsage(w http.ResponseWriter, r *http.Request) {
+	username, role, ok := getUserFromRequest(r)
+	if !ok {
+		ErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	if role != "staff" {
+		ownerID, valid := ownerIDFromUsername(username)
+		if !valid {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		u := models.StorageUsage{OwnerID: &ownerID}
+		var err error
+		if u.QuotaBytes, err = ownerStorageQuota(ownerID); err == nil {
+			u.UsedBytes, err = storageUsed(&ownerID)
+		}
+		if err != nil {
+			ErrorResponse(w, "Failed to fetch storage usage", http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(u)
+		return
+	}
+
+	clinic := models.StorageUsage{QuotaBytes: quotaFromEnv("STORAGE_QUOTA_CLINIC_BYTES")}
+	var err error
+	if clinic.UsedBytes, err = storageUsed(nil); err != nil {
+		ErrorResponse(w, "Failed to fetch storage usage", http.StatusInternalServerError, err)
+		return
+	}
+
+	rows, err := db.DB.Query(`SELECT o.id, o.name, o.storage_quota_bytes, ` +
+		storageUsedExpr("p.owner_id = o.id") + ` FROM owners o ORDER BY o.id`)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch storage usage", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	defaultQuota := quotaFromEnv("STORAGE_QUOTA_OWNER_BYTES")
+	owners := []models.StorageUsage{}
+	for rows.Next() {
+		var u models.StorageUsage
+		var id int
+		if err := rows.Scan(&id, &u.OwnerName, &u.QuotaBytes, &u.UsedBytes); err != nil {
+			ErrorResponse(w, "Error scanning storage usage", http.StatusInternalServerError, err)
+			return
+		}
+		u.OwnerID = &id
+		if u.QuotaBytes == nil {
+			u.QuotaBytes = defaultQuota
+		}
+		owners = append(owners, u)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"clinic": clinic, "owners": owners})
+}
+
+// SetOwnerStorageQuota - staff set {"quota_bytes": 1073741824} for one owner, or
+// null to fall back to STORAGE_QUOTA_OWNER_BYTES
+func SetOwnerStorageQuota(w http.ResponseWriter, r *http.Request) {
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+	id := mux.Vars(r)["id"]
+
+	var input struct {
+		QuotaBytes *int64 `json:"quota_bytes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		ErrorResponse(w, "Invalid quota input", http.StatusBadRequest, err)
+		return
+	}
+	if input.QuotaBytes != nil && *input.QuotaBytes < 0 {
+		http.Error(w, "quota_bytes must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.DB.Exec("UPDATE owners SET storage_quota_bytes=$2 WHERE id=$1", id, input.QuotaBytes)
+	if n, _ := rowsAffected(res, err); n == 0 {
+		if err != nil {
+			ErrorResponse(w, "Failed to set storage quota", http.StatusInternalServerError, err)
+		} else {
+			http.Error(w, "Owner not found", http.StatusNotFound)
+		}
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"owner_id": id, "quota_bytes": input.QuotaBytes, "user": username}).Info("Owner storage quota set")
+	w.Write([]byte("Storage quota updated"))
+}
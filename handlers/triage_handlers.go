@@ -0,0 +1,416 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"pet-clinic/db"
+	"pet-clinic/models"
+	"pet-clinic/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+var triagePriorityLabels = map[int]string{
+	1: "critical",
+	2: "emergency",
+	3: "urgent",
+	4: "standard",
+	5: "non-urgent",
+}
+
+// triageHub fans out "queue changed" signals to connected board streams
+type triageHub struct {
+	mu   sync.Mutex
+	subs map[chan struct{}]struct{}
+}
+
+var triageUpdates = &triageHub{subs: map[chan struct{}]struct{}{}}
+
+func (h *triageHub) subscribe() chan struct{} {
+	ch := make(chan struct{}, 1)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *triageHub) unsubscribe(ch chan struct{}) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+}
+
+// publish never blocks: a subscriber that already has a pending signal will re-read the whole board anyway
+func (h *triageHub) publish() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// triageVetsOnDuty is used for wait estimates (TRIAGE_VETS_ON_DUTY, default 1)
+func triageVetsOnDuty() int {
+	if n, err := strconv.Atoi(os.Getenv("TRIAGE_VETS_ON_DUTY")); err == nil && n > 0 {
+		return n
+	}
+	return 1
+}
+
+// loadTriageQueue returns active check-ins (in progress first, then waiting by priority and arrival)
+// with waiting-time estimates based on recent consult lengths
+func loadTriageQueue() ([]models.CheckIn, error) {
+	var avgMinutes sql.NullFloat64
+	db.DB.QueryRow(`SELECT AVG(EXTRACT(EPOCH FROM (completed_at - called_at)) / 60)
+		FROM checkins WHERE status='done' AND completed_at > NOW() - INTERVAL '7 days'`).Scan(&avgMinutes)
+	consult := utils.AppointmentDuration().Minutes()
+	if avgMinutes.Valid && avgMinutes.Float64 > 0 {
+		consult = avgMinutes.Float64
+	}
+
+	rows, err := db.DB.Query(`SELECT id, pet_id, patient_name, COALESCE(complaint, ''), priority, COALESCE(assigned_vet, ''),
+			status, arrived_at::text, COALESCE(called_at::text, ''), EXTRACT(EPOCH FROM (NOW() - arrived_at))::int / 60
+		FROM checkins
+		WHERE status IN ('waiting', 'in_progress')
+		ORDER BY status = 'waiting', priority, arrived_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	vets := triageVetsOnDuty()
+	queue := []models.CheckIn{}
+	inProgress, position := 0, 0
+	for rows.Next() {
+		var c models.CheckIn
+		var petID sql.NullInt64
+		if err := rows.Scan(&c.ID, &petID, &c.PatientName, &c.Complaint, &c.Priority, &c.AssignedVet,
+			&c.Status, &c.ArrivedAt, &c.CalledAt, &c.WaitedMinutes); err != nil {
+			return nil, err
+		}
+		if petID.Valid {
+			id := int(petID.Int64)
+			c.PetID = &id
+		}
+		c.PriorityLabel = triagePriorityLabels[c.Priority]
+
+		if c.Status == "in_progress" {
+			inProgress++
+		} else {
+			// critical patients are seen immediately; others wait for a free vet
+			est := 0
+			if c.Priority > 1 {
+				est = int(math.Ceil(float64(position+inProgress) / float64(vets) * consult))
+			}
+			c.EstimatedWait = &est
+			position++
+		}
+		queue = append(queue, c)
+	}
+	return queue, rows.Err()
+}
+
+// patientInitials shortens a name for public display, e.g. "Bruno Smith" -> "B.S."
+func patientInitials(name string) string {
+	var b strings.Builder
+	for _, word := range strings.Fields(name) {
+		r, _ := utf8.DecodeRuneInString(word)
+		b.WriteRune(unicode.ToUpper(r))
+		b.WriteByte('.')
+	}
+	return b.String()
+}
+
+// loadTriageBoard is the anonymised queue shown on the waiting room screen
+func loadTriageBoard() ([]models.TriageBoardEntry, error) {
+	queue, err := loadTriageQueue()
+	if err != nil {
+		return nil, err
+	}
+	board := make([]models.TriageBoardEntry, 0, len(queue))
+	for _, c := range queue {
+		board = append(board, models.TriageBoardEntry{
+			Number:        c.ID,
+			Initials:      patientInitials(c.PatientName),
+			PriorityLabel: c.PriorityLabel,
+			Status:        c.Status,
+			AssignedVet:   c.AssignedVet,
+			EstimatedWait: c.EstimatedWait,
+		})
+	}
+	return board, nil
+}
+
+// streamTriage pushes the board as Server-Sent Events whenever the queue changes
+func streamTriage(w http.ResponseWriter, r *http.Request, build func() (interface{}, error)) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	updates := triageUpdates.subscribe()
+	defer triageUpdates.unsubscribe(updates)
+
+	send := func() {
+		v, err := build()
+		if err != nil {
+			utils.Log.WithError(err).Error("Failed to build triage board")
+			return
+		}
+		data, _ := json.Marshal(v)
+		fmt.Fprintf(w, "event: board\ndata: %s\n\n", data)
+		flusher.Flush()
+	}
+
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+
+	utils.Log.WithField("path", r.URL.Path).Info("Triage board stream opened")
+	send()
+	for {
+		select {
+		case <-r.Context().Done():
+			utils.Log.WithField("path", r.URL.Path).Debug("Triage board stream closed")
+			return
+		case <-updates:
+			send()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+// CheckInPatient - staff register a walk-in or emergency arrival
+func CheckInPatient(w http.ResponseWriter, r *http.Request) {
+	utils.Log.Debug("POST /triage/checkins called")
+
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	var c models.CheckIn
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		ErrorResponse(w, "Invalid check-in input", http.StatusBadRequest, err)
+		return
+	}
+	if c.Priority == 0 {
+		c.Priority = 3
+	}
+	if _, valid := triagePriorityLabels[c.Priority]; !valid {
+		http.Error(w, "priority must be between 1 (critical) and 5 (non-urgent)", http.StatusBadRequest)
+		return
+	}
+	if c.PatientName == "" && c.PetID != nil {
+		db.DB.QueryRow("SELECT name FROM pets WHERE id=$1", *c.PetID).Scan(&c.PatientName)
+	}
+	if c.PatientName == "" {
+		http.Error(w, "patient_name or a valid pet_id is required", http.StatusBadRequest)
+		return
+	}
+
+	err := db.DB.QueryRow(`INSERT INTO checkins (pet_id, patient_name, complaint, priority, assigned_vet, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, status, arrived_at::text`,
+		c.PetID, c.PatientName, c.Complaint, c.Priority, c.AssignedVet, username).
+		Scan(&c.ID, &c.Status, &c.ArrivedAt)
+	if err != nil {
+		ErrorResponse(w, "Failed to check in patient", http.StatusInternalServerError, err)
+		return
+	}
+	c.PriorityLabel = triagePriorityLabels[c.Priority]
+	triageUpdates.publish()
+
+	utils.Log.WithFields(map[string]interface{}{"id": c.ID, "priority": c.Priority}).Info("Patient checked in")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+// GetTriageQueue - full queue with estimates for staff
+func GetTriageQueue(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireStaff(w, r); !ok {
+		return
+	}
+
+	queue, err := loadTriageQueue()
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch triage queue", http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(queue)
+}
+
+// UpdateCheckIn - staff re-triage, reassign or mark a patient as left/done
+func UpdateCheckIn(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	utils.Log.WithField("id", id).Debug("PUT /triage/checkins/{id} called")
+
+	if _, ok := requireStaff(w, r); !ok {
+		return
+	}
+
+	// every field is optional; only the ones sent are changed
+	var input struct {
+		Priority    *int    `json:"priority"`
+		AssignedVet *string `json:"assigned_vet"`
+		Complaint   *string `json:"complaint"`
+		Status      *string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		ErrorResponse(w, "Invalid check-in input", http.StatusBadRequest, err)
+		return
+	}
+	fields := map[string]interface{}{"id": id}
+	if input.Priority != nil {
+		if _, valid := triagePriorityLabels[*input.Priority]; !valid {
+			http.Error(w, "priority must be between 1 (critical) and 5 (non-urgent)", http.StatusBadRequest)
+			return
+		}
+		fields["priority"] = *input.Priority
+	}
+	if input.Status != nil {
+		switch *input.Status {
+		case "waiting", "in_progress", "done", "left":
+		default:
+			http.Error(w, "status must be waiting, in_progress, done or left", http.StatusBadRequest)
+			return
+		}
+		fields["status"] = *input.Status
+	}
+
+	result, err := db.DB.Exec(`UPDATE checkins SET priority=COALESCE($1, priority), assigned_vet=COALESCE($2, assigned_vet),
+			complaint=COALESCE($3, complaint), status=COALESCE($4, status),
+			called_at = CASE WHEN $4 = 'in_progress' THEN COALESCE(called_at, NOW()) ELSE called_at END,
+			completed_at = CASE WHEN $4 IS NULL THEN completed_at WHEN $4 IN ('done', 'left') THEN NOW() ELSE NULL END
+		WHERE id=$5`,
+		input.Priority, input.AssignedVet, input.Complaint, input.Status, id)
+	if err != nil {
+		ErrorResponse(w, "Failed to update check-in", http.StatusInternalServerError, err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Check-in not found", http.StatusNotFound)
+		return
+	}
+	triageUpdates.publish()
+
+	utils.Log.WithFields(fields).Info("Check-in updated")
+	w.Write([]byte("Check-in updated"))
+}
+
+// CallNextPatient - hands the most urgent waiting patient to the calling vet.
+// Patients assigned to another vet are skipped.
+func CallNextPatient(w http.ResponseWriter, r *http.Request) {
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Vet string `json:"vet"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	if body.Vet == "" {
+		body.Vet = username
+	}
+
+	var id int
+	err := db.DB.QueryRow(`UPDATE checkins SET status='in_progress', called_at=NOW(), assigned_vet=$1
+		WHERE id = (
+			SELECT id FROM checkins
+			WHERE status='waiting' AND (COALESCE(assigned_vet, '') = '' OR assigned_vet = $1)
+			ORDER BY priority, arrived_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING id`, body.Vet).Scan(&id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "No patients waiting", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ErrorResponse(w, "Failed to call next patient", http.StatusInternalServerError, err)
+		return
+	}
+	triageUpdates.publish()
+
+	var c models.CheckIn
+	var petID sql.NullInt64
+	db.DB.QueryRow(`SELECT id, pet_id, patient_name, COALESCE(complaint, ''), priority, assigned_vet, status,
+			arrived_at::text, called_at::text, EXTRACT(EPOCH FROM (called_at - arrived_at))::int / 60
+		FROM checkins WHERE id=$1`, id).
+		Scan(&c.ID, &petID, &c.PatientName, &c.Complaint, &c.Priority, &c.AssignedVet, &c.Status,
+			&c.ArrivedAt, &c.CalledAt, &c.WaitedMinutes)
+	if petID.Valid {
+		p := int(petID.Int64)
+		c.PetID = &p
+	}
+	c.PriorityLabel = triagePriorityLabels[c.Priority]
+
+	utils.Log.WithFields(map[string]interface{}{"id": id, "vet": body.Vet}).Info("Next patient called")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// StreamTriageQueue - staff push feed of the full queue
+func StreamTriageQueue(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireStaff(w, r); !ok {
+		return
+	}
+	streamTriage(w, r, func() (interface{}, error) { return loadTriageQueue() })
+}
+
+// checkBoardKey guards the public waiting room board with TRIAGE_BOARD_KEY; unset disables it
+func checkBoardKey(w http.ResponseWriter, r *http.Request) bool {
+	key := os.Getenv("TRIAGE_BOARD_KEY")
+	if key == "" || subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("key")), []byte(key)) != 1 {
+		http.Error(w, "Board not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// GetTriageBoard - anonymised board for the waiting room screen
+func GetTriageBoard(w http.ResponseWriter, r *http.Request) {
+	if !checkBoardKey(w, r) {
+		return
+	}
+
+	board, err := loadTriageBoard()
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch triage board", http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(board)
+}
+
+// StreamTriageBoard - push feed of the anonymised board
+func StreamTriageBoard(w http.ResponseWriter, r *http.Request) {
+	if !checkBoardKey(w, r) {
+		return
+	}
+	streamTriage(w, r, func() (interface{}, error) { return loadTriageBoard() })
+}
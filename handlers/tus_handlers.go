@@ -0,0 +1,655 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"pet-clinic/db"
+	"pet-clinic/filetype"
+	"pet-clinic/imaging"
+	"pet-clinic/models"
+	"pet-clinic/scan"
+	"pet-clinic/storage"
+	"pet-clinic/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Resumable uploads follow the tus 1.0.0 protocol (https://tus.io/protocols/resumable-upload)
+// with the creation, checksum, termination and expiration extensions. Every PATCH
+// is streamed straight into the blob store as its own part; when the last byte
+// arrives the parts are checked, scanned and joined into a normal attachment.
+const tusVersion = "1.0.0"
+
+// statusChecksumMismatch is the tus checksum extension's status code
+const statusChecksumMismatch = 460
+
+// uploadMaxSize caps a resumable upload (UPLOAD_MAX_BYTES, default 5 GiB)
+func uploadMaxSize() int64 {
+	if n, err := strconv.ParseInt(os.Getenv("UPLOAD_MAX_BYTES"), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return 5 << 30
+}
+
+// uploadExpiry is how long an upload may sit idle before it is discarded
+// (UPLOAD_EXPIRY_HOURS, default 24); every chunk extends it
+func uploadExpiry() time.Duration {
+	if h, err := strconv.Atoi(os.Getenv("UPLOAD_EXPIRY_HOURS")); err == nil && h > 0 {
+		return time.Duration(h) * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+func tusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// tusVersionOK rejects requests from clients speaking another protocol version
+func tusVersionOK(w http.ResponseWriter, r *http.Request) bool {
+	tusHeaders(w)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// parseUploadMetadata decodes "key base64value,key2 base64value2"
+func parseUploadMetadata(header string) (map[string]string, error) {
+	meta := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("metadata %q is not base64", key)
+		}
+		meta[key] = string(value)
+	}
+	return meta, nil
+}
+
+// parseUploadChecksum reads "Upload-Checksum: <algorithm> <base64 digest>"
+func parseUploadChecksum(header string) (hash.Hash, []byte, error) {
+	algorithm, encoded, _ := strings.Cut(strings.TrimSpace(header), " ")
+	digest, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, nil, errors.New("Upload-Checksum digest is not base64")
+	}
+	switch algorithm {
+	case "sha256":
+		return sha256.New(), digest, nil
+	case "sha1":
+		return sha1.New(), digest, nil
+	case "md5":
+		return md5.New(), digest, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+}
+
+const uploadSessionColumns = `id, pet_id, visit_id, filename, COALESCE(description, ''), COALESCE(expected_sha256, ''),
+	upload_length, upload_offset, parts, created_by, expires_at::timestamptz, attachment_id, version_of`
+
+func scanUploadSession(row interface{ Scan(...interface{}) error }, s *models.UploadSession) error {
+	return row.Scan(&s.ID, &s.PetID, &s.VisitID, &s.Filename, &s.Description, &s.ExpectedSHA256,
+		&s.Length, &s.Offset, pq.Array(&s.Parts), &s.CreatedBy, &s.ExpiresAt, &s.AttachmentID, &s.VersionOf)
+}
+
+// loadOwnUploadSession finds an unexpired upload started by the calling user
+func loadOwnUploadSession(w http.ResponseWriter, r *http.Request) (models.UploadSession, bool) {
+	var s models.UploadSession
+	username, _, ok := getUserFromRequest(r)
+	if !ok {
+		ErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return s, false
+	}
+	id := mux.Vars(r)["id"]
+	if !validFileID(id) {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return s, false
+	}
+	err := scanUploadSession(db.DB.QueryRow(`SELECT `+uploadSessionColumns+` FROM upload_sessions
+		WHERE id=$1 AND expires_at > NOW()`, id), &s)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && s.CreatedBy != username {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return s, false
+	}
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch upload", http.StatusInternalServerError, err)
+		return s, false
+	}
+	return s, true
+}
+
+// UploadOptions - OPTIONS /api/uploads advertises what the server supports
+func UploadOptions(w http.ResponseWriter, r *http.Request) {
+	tusHeaders(w)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,checksum,termination,expiration")
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(uploadMaxSize(), 10))
+	w.Header().Set("Tus-Checksum-Algorithm", "sha256,sha1,md5")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateUpload - POST /api/uploads with Upload-Length and Upload-Metadata carrying
+// filename, pet_id and optionally visit_id, description and sha256 (hex digest of
+// the whole file, checked once it is complete); attachment_id instead of pet_id
+// makes the upload a new version of that file. Same access rules as UpdatePet.
+func CreateUpload(w http.ResponseWriter, r *http.Request) {
+	if !tusVersionOK(w, r) {
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "Upload-Length is required", http.StatusBadRequest)
+		return
+	}
+	if length > uploadMaxSize() {
+		http.Error(w, "Upload exceeds Tus-Max-Size", http.StatusRequestEntityTooLarge)
+		return
+	}
+	meta, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s := models.UploadSession{
+		Filename:       cleanFilename(meta["filename"]),
+		Description:    strings.TrimSpace(meta["description"]),
+		ExpectedSHA256: strings.ToLower(strings.TrimSpace(meta["sha256"])),
+		Length:         length,
+		Parts:          []string{},
+	}
+	if meta["filename"] == "" {
+		http.Error(w, "filename metadata is required", http.StatusBadRequest)
+		return
+	}
+	if id := meta["attachment_id"]; id != "" {
+		existing, err := loadAttachment(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Attachment not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			ErrorResponse(w, "Could not create upload", http.StatusInternalServerError, err)
+			return
+		}
+		if v := meta["pet_id"]; v != "" && v != strconv.Itoa(existing.PetID) {
+			http.Error(w, "attachment_id belongs to another pet", http.StatusBadRequest)
+			return
+		}
+		s.PetID, s.VersionOf = existing.PetID, &existing.ID
+	} else if s.PetID, err = strconv.Atoi(meta["pet_id"]); err != nil {
+		http.Error(w, "pet_id metadata is required", http.StatusBadRequest)
+		return
+	}
+	if s.ExpectedSHA256 != "" {
+		if b, err := hex.DecodeString(s.ExpectedSHA256); err != nil || len(b) != sha256.Size {
+			http.Error(w, "sha256 metadata must be a hex SHA-256 digest", http.StatusBadRequest)
+			return
+		}
+	}
+	username, _, ok := authorizePetManage(w, r, s.PetID)
+	if !ok {
+		return
+	}
+	if s.VersionOf == nil {
+		if s.VisitID, err = parseAttachmentVisit(s.PetID, meta["visit_id"]); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// reject names no allowed type could match before any bytes are sent
+	if !extensionAllowed(s.Filename) {
+		http.Error(w, "File type not allowed", http.StatusUnsupportedMediaType)
+		return
+	}
+	// the session reserves its full length against the quota until it completes or expires
+	if !checkStorageQuota(w, s.PetID, s.Length) {
+		return
+	}
+
+	if s.ID, err = newFileID(); err != nil {
+		ErrorResponse(w, "Could not create upload", http.StatusInternalServerError, err)
+		return
+	}
+	// expiry uses the database clock, which the cleanup and quota queries compare against
+	s.CreatedBy = username
+	err = db.DB.QueryRow(`INSERT INTO upload_sessions (id, pet_id, visit_id, filename, description, expected_sha256,
+			upload_length, created_by, expires_at, version_of)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7, $8, NOW() + $9 * INTERVAL '1 second', $10)
+		RETURNING expires_at::timestamptz`,
+		s.ID, s.PetID, s.VisitID, s.Filename, s.Description, s.ExpectedSHA256, s.Length, s.CreatedBy,
+		int64(uploadExpiry()/time.Second), s.VersionOf).Scan(&s.ExpiresAt)
+	if err != nil {
+		ErrorResponse(w, "Could not create upload", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"upload_id": s.ID, "pet_id": s.PetID, "filename": s.Filename, "length": s.Length, "user": username}).Info("Resumable upload created")
+	w.Header().Set("Location", "/api/uploads/"+s.ID)
+	w.Header().Set("Upload-Expires", s.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// extensionAllowed reports whether some allowed type uses filename's extension
+func extensionAllowed(filename string) bool {
+	for name := range filetype.Allowed() {
+		if t, ok := filetype.Lookup(name); ok && t.MatchesName(filename) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetUploadOffset - HEAD /api/uploads/{id} tells the client where to resume
+func GetUploadOffset(w http.ResponseWriter, r *http.Request) {
+	if !tusVersionOK(w, r) {
+		return
+	}
+	s, ok := loadOwnUploadSession(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(s.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(s.Length, 10))
+	w.Header().Set("Upload-Expires", s.ExpiresAt.UTC().Format(http.TimeFormat))
+	if s.AttachmentID != nil {
+		w.Header().Set("Attachment-Id", *s.AttachmentID)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// PatchUpload - PATCH /api/uploads/{id} appends one chunk at Upload-Offset. The body
+// is streamed to the blob store, never held in memory, and checked against
+// Upload-Checksum when given. The chunk that completes the file also turns it
+// into an attachment (Attachment-Id header); if that step fails, an empty PATCH
+// at the final offset retries it.
+func PatchUpload(w http.ResponseWriter, r *http.Request) {
+	if !tusVersionOK(w, r) {
+		return
+	}
+	s, ok := loadOwnUploadSession(w, r)
+	if !ok {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != s.Offset {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(s.Offset, 10))
+		http.Error(w, "Upload-Offset does not match the upload", http.StatusConflict)
+		return
+	}
+	if s.AttachmentID != nil {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(s.Offset, 10))
+		w.Header().Set("Attachment-Id", *s.AttachmentID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.ContentLength < 0 {
+		http.Error(w, "Content-Length is required", http.StatusLengthRequired)
+		return
+	}
+	if s.Offset+r.ContentLength > s.Length {
+		http.Error(w, "Chunk goes past Upload-Length", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var sum hash.Hash
+	var expected []byte
+	if h := r.Header.Get("Upload-Checksum"); h != "" {
+		if sum, expected, err = parseUploadChecksum(h); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	store, err := storage.Default()
+	if err != nil {
+		ErrorResponse(w, "Could not store chunk", http.StatusInternalServerError, err)
+		return
+	}
+	log := utils.Log.WithFields(map[string]interface{}{"upload_id": s.ID, "offset": offset, "size": r.ContentLength})
+
+	if r.ContentLength > 0 {
+		suffix, err := newFileID()
+		if err != nil {
+			ErrorResponse(w, "Could not store chunk", http.StatusInternalServerError, err)
+			return
+		}
+		// zero-padded offsets keep the parts in order when listed
+		key := fmt.Sprintf("tus/%s/%016d-%s", s.ID, offset, suffix[:8])
+		var body io.Reader = io.LimitReader(r.Body, r.ContentLength)
+		if sum != nil {
+			body = io.TeeReader(body, sum)
+		}
+		if err := store.Put(r.Context(), key, body, r.ContentLength); err != nil {
+			log.WithError(err).Warn("Upload chunk not stored")
+			http.Error(w, "Could not store chunk", http.StatusInternalServerError)
+			return
+		}
+		if sum != nil && !bytes.Equal(sum.Sum(nil), expected) {
+			store.Delete(context.Background(), key)
+			log.Warn("Upload chunk failed its checksum")
+			http.Error(w, "Checksum Mismatch", statusChecksumMismatch)
+			return
+		}
+
+		// the offset check makes concurrent PATCHes at the same offset lose cleanly
+		err = db.DB.QueryRow(`UPDATE upload_sessions
+			SET upload_offset = upload_offset + $3, parts = array_append(parts, $4), expires_at = NOW() + $5 * INTERVAL '1 second'
+			WHERE id=$1 AND upload_offset=$2
+			RETURNING expires_at::timestamptz`, s.ID, offset, r.ContentLength, key, int64(uploadExpiry()/time.Second)).
+			Scan(&s.ExpiresAt)
+		if err != nil {
+			store.Delete(context.Background(), key)
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Upload-Offset does not match the upload", http.StatusConflict)
+			} else {
+				ErrorResponse(w, "Could not store chunk", http.StatusInternalServerError, err)
+			}
+			return
+		}
+		s.Offset += r.ContentLength
+		s.Parts = append(s.Parts, key)
+	}
+
+	if s.Offset == s.Length {
+		// only one request may complete the upload; a concurrent or retried final
+		// PATCH would otherwise store the file twice. A claim older than 15 minutes
+		// belongs to a request that died and is taken over.
+		res, err := db.DB.Exec(`UPDATE upload_sessions SET completing_since=NOW()
+			WHERE id=$1 AND attachment_id IS NULL AND upload_offset = upload_length
+			  AND (completing_since IS NULL OR completing_since < NOW() - INTERVAL '15 minutes')`, s.ID)
+		if n, _ := rowsAffected(res, err); n == 0 {
+			if err != nil {
+				ErrorResponse(w, "Could not complete upload", http.StatusInternalServerError, err)
+				return
+			}
+			w.Header().Set("Upload-Offset", strconv.FormatInt(s.Offset, 10))
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "Upload is being completed by another request", http.StatusConflict)
+			return
+		}
+		a, ok := completeUpload(w, r, store, s)
+		if !ok {
+			// let a retry try again; a discarded upload has no row left
+			db.DB.Exec(`UPDATE upload_sessions SET completing_since=NULL WHERE id=$1 AND attachment_id IS NULL`, s.ID)
+			return
+		}
+		w.Header().Set("Attachment-Id", a.ID)
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(s.Offset, 10))
+	w.Header().Set("Upload-Expires", s.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func rowsAffected(res sql.Result, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteUpload - DELETE /api/uploads/{id} abandons an upload and its chunks
+func DeleteUpload(w http.ResponseWriter, r *http.Request) {
+	if !tusVersionOK(w, r) {
+		return
+	}
+	s, ok := loadOwnUploadSession(w, r)
+	if !ok {
+		return
+	}
+	if err := discardUpload(r.Context(), s.ID); err != nil {
+		ErrorResponse(w, "Could not delete upload", http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// partsReader reads the stored chunks back to back, opening one at a time
+type partsReader struct {
+	ctx   context.Context
+	store storage.BlobStore
+	keys  []string
+	cur   io.ReadCloser
+}
+
+func (p *partsReader) Read(b []byte) (int, error) {
+	for {
+		if p.cur == nil {
+			if len(p.keys) == 0 {
+				return 0, io.EOF
+			}
+			rc, err := p.store.Get(p.ctx, p.keys[0])
+			if err != nil {
+				return 0, fmt.Errorf("upload part %s: %w", p.keys[0], err)
+			}
+			p.cur, p.keys = rc, p.keys[1:]
+		}
+		n, err := p.cur.Read(b)
+		if err == io.EOF {
+			p.cur.Close()
+			p.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (p *partsReader) Close() error {
+	if p.cur != nil {
+		return p.cur.Close()
+	}
+	return nil
+}
+
+func openParts(ctx context.Context, store storage.BlobStore, keys []string) *partsReader {
+	return &partsReader{ctx: ctx, store: store, keys: append([]string(nil), keys...)}
+}
+
+// completeUpload checks the assembled file's type, full-file SHA-256 and malware
+// verdict, copies it to its attachment key and records it. Rejected uploads are
+// discarded; it writes the error response itself and reports ok=false.
+func completeUpload(w http.ResponseWriter, r *http.Request, store storage.BlobStore, s models.UploadSession) (models.Attachment, bool) {
+	ctx := r.Context()
+	a := models.Attachment{PetID: s.PetID, VisitID: s.VisitID, Description: s.Description}
+	if s.VersionOf != nil {
+		existing, err := loadAttachment(*s.VersionOf)
+		if err != nil {
+			ErrorResponse(w, "Could not save file", http.StatusInternalServerError, err)
+			return a, false
+		}
+		a = existing
+	}
+	a.Filename, a.UploadedBy = s.Filename, s.CreatedBy
+	log := utils.Log.WithFields(map[string]interface{}{"upload_id": s.ID, "pet_id": s.PetID, "filename": s.Filename})
+
+	parts := openParts(ctx, store, s.Parts)
+	head := make([]byte, filetype.HeaderSize)
+	n, err := io.ReadFull(parts, head)
+	parts.Close()
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		ErrorResponse(w, "Could not read upload", http.StatusInternalServerError, err)
+		return a, false
+	}
+	ft, err := detectUploadType(a.Filename, head[:n])
+	if err != nil {
+		discardUpload(context.Background(), s.ID)
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return a, false
+	}
+	a.ContentType = ft.MIME
+
+	// one pass for the digest and the scanner
+	sum := sha256.New()
+	parts = openParts(ctx, store, s.Parts)
+	var result scan.Result
+	if scanner := scan.FromEnv(); scanner != nil {
+		result, err = scanner.Scan(ctx, io.TeeReader(parts, sum))
+		if errors.Is(err, scan.ErrTooLarge) {
+			// no retry can succeed, so the upload and its quota reservation go
+			parts.Close()
+			log.WithField("size", s.Length).Warn("Upload too large for the malware scanner")
+			discardUpload(context.Background(), s.ID)
+			http.Error(w, "File is too large to be scanned", http.StatusRequestEntityTooLarge)
+			return a, false
+		}
+		if err != nil {
+			parts.Close()
+			ErrorResponse(w, "File scanning unavailable, try again later", http.StatusServiceUnavailable, err)
+			return a, false
+		}
+	}
+	_, err = io.Copy(sum, parts) // whatever the scanner did not consume
+	parts.Close()
+	if err != nil {
+		ErrorResponse(w, "Could not read upload", http.StatusInternalServerError, err)
+		return a, false
+	}
+	a.SHA256, a.Size = hex.EncodeToString(sum.Sum(nil)), s.Length
+
+	if s.ExpectedSHA256 != "" && a.SHA256 != s.ExpectedSHA256 {
+		log.WithField("sha256", a.SHA256).Warn("Completed upload does not match its declared SHA-256")
+		discardUpload(context.Background(), s.ID)
+		http.Error(w, "Checksum Mismatch: file does not match its sha256", statusChecksumMismatch)
+		return a, false
+	}
+
+	if a.BlobKey, err = newFileID(); err != nil {
+		ErrorResponse(w, "Could not save file", http.StatusInternalServerError, err)
+		return a, false
+	}
+	if result.Infected {
+		q := a
+		q.ID = a.BlobKey
+		parts = openParts(ctx, store, s.Parts)
+		quarantineUpload(ctx, store, parts, q, result.Signature)
+		parts.Close()
+		discardUpload(context.Background(), s.ID)
+		http.Error(w, "File rejected: malware detected", http.StatusUnprocessableEntity)
+		return a, false
+	}
+
+	// the stored copy has its location metadata stripped, so its digest is taken again
+	parts = openParts(ctx, store, s.Parts)
+	body := imaging.StripLocation(parts, a.ContentType)
+	stored := sha256.New()
+	err = store.Put(ctx, a.BlobKey, io.TeeReader(body, stored), a.Size)
+	body.Close()
+	parts.Close()
+	a.SHA256 = hex.EncodeToString(stored.Sum(nil))
+	if err != nil {
+		ErrorResponse(w, "File save failed", http.StatusInternalServerError, err)
+		return a, false
+	}
+	if err := saveAttachment(&a, nil); err != nil {
+		store.Delete(context.Background(), a.BlobKey)
+		ErrorResponse(w, "Could not save file", http.StatusInternalServerError, err)
+		return a, false
+	}
+
+	// the chunks are no longer needed; the session row stays until it expires so HEAD still answers
+	if _, err := db.DB.Exec(`UPDATE upload_sessions SET attachment_id=$2, parts='{}', completing_since=NULL WHERE id=$1`, s.ID, a.ID); err != nil {
+		log.WithError(err).Error("Failed to mark upload complete")
+	}
+	deleteUploadParts(context.Background(), store, s.ID)
+
+	log.WithFields(map[string]interface{}{"id": a.ID, "version": a.Version, "size": a.Size}).Info("Resumable upload completed")
+	return a, true
+}
+
+// deleteUploadParts removes every blob under tus/<id>/, including chunks whose
+// PATCH failed after they were written
+func deleteUploadParts(ctx context.Context, store storage.BlobStore, id string) error {
+	var keys []string
+	if err := store.List(ctx, "tus/"+id+"/", func(info storage.Info) error {
+		keys = append(keys, info.Key)
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := store.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// discardUpload drops an upload's chunks and its session row
+func discardUpload(ctx context.Context, id string) error {
+	store, err := storage.Default()
+	if err != nil {
+		return err
+	}
+	if err := deleteUploadParts(ctx, store, id); err != nil {
+		return err
+	}
+	_, err = db.DB.Exec(`DELETE FROM upload_sessions WHERE id=$1`, id)
+	return err
+}
+
+// RunUploadCleanup discards resumable uploads that passed their expiry, along
+// with their chunks
+func RunUploadCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cleanupExpiredUploads()
+	}
+}
+
+func cleanupExpiredUploads() {
+	rows, err := db.DB.Query(`SELECT id FROM upload_sessions WHERE expires_at <= NOW()`)
+	if err != nil {
+		utils.Log.WithError(err).Error("Failed to look up expired uploads")
+		return
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if err := discardUpload(context.Background(), id); err != nil {
+			utils.Log.WithError(err).WithField("upload_id", id).Error("Failed to discard expired upload")
+			continue
+		}
+		utils.Log.WithField("upload_id", id).Info("Expired upload discarded")
+	}
+}
@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"os"
+	"pet-clinic/db"
+	"pet-clinic/models"
+	"pet-clinic/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const vaccinationColumns = `v.id, v.pet_id, v.vaccine_id, vc.name, v.visit_id, v.administered_on::text, v.dose_number,
+	COALESCE(v.product, ''), COALESCE(v.lot_number, ''), COALESCE(v.administering_vet, ''),
+	COALESCE(v.next_due_date::text, ''), COALESCE(v.created_by, '')`
+
+func scanVaccination(row interface{ Scan(...interface{}) error }, v *models.Vaccination) error {
+	var visitID sql.NullInt64
+	err := row.Scan(&v.ID, &v.PetID, &v.VaccineID, &v.VaccineName, &visitID, &v.AdministeredOn, &v.DoseNumber,
+		&v.Product, &v.LotNumber, &v.AdministeringVet, &v.NextDueDate, &v.CreatedBy)
+	if visitID.Valid {
+		id := int(visitID.Int64)
+		v.VisitID = &id
+	}
+	return err
+}
+
+// GetVaccines - catalog, optionally filtered by ?species=
+func GetVaccines(w http.ResponseWriter, r *http.Request) {
+	query := `SELECT id, name, species, core, first_dose_age_weeks, initial_doses, initial_interval_days, booster_interval_days
+		FROM vaccines`
+	var args []interface{}
+	if species := r.URL.Query().Get("species"); species != "" {
+		query += " WHERE LOWER(species) = LOWER($1)"
+		args = append(args, species)
+	}
+	query += " ORDER BY species, core DESC, name"
+
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch vaccines", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	vaccines := []models.Vaccine{}
+	for rows.Next() {
+		var v models.Vaccine
+		if err := rows.Scan(&v.ID, &v.Name, &v.Species, &v.Core, &v.FirstDoseAgeWeeks, &v.InitialDoses,
+			&v.InitialIntervalDays, &v.BoosterIntervalDays); err != nil {
+			ErrorResponse(w, "Error scanning vaccine data", http.StatusInternalServerError, err)
+			return
+		}
+		vaccines = append(vaccines, v)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(vaccines)
+}
+
+func validVaccine(v models.Vaccine) bool {
+	return strings.TrimSpace(v.Name) != "" && strings.TrimSpace(v.Species) != "" &&
+		v.InitialDoses >= 1 && v.InitialIntervalDays >= 0 && v.BoosterIntervalDays > 0 && v.FirstDoseAgeWeeks >= 0
+}
+
+// CreateVaccine - staff add a vaccine schedule for a species
+func CreateVaccine(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireStaff(w, r); !ok {
+		return
+	}
+
+	var v models.Vaccine
+	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+		ErrorResponse(w, "Invalid vaccine input", http.StatusBadRequest, err)
+		return
+	}
+	if !validVaccine(v) {
+		http.Error(w, "name, species, initial_doses >= 1 and booster_interval_days > 0 are required", http.StatusBadRequest)
+		return
+	}
+
+	err := db.DB.QueryRow(`INSERT INTO vaccines (name, species, core, first_dose_age_weeks, initial_doses,
+			initial_interval_days, booster_interval_days)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		v.Name, v.Species, v.Core, v.FirstDoseAgeWeeks, v.InitialDoses, v.InitialIntervalDays, v.BoosterIntervalDays).Scan(&v.ID)
+	if err != nil {
+		ErrorResponse(w, "Failed to create vaccine", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"id": v.ID, "name": v.Name, "species": v.Species}).Info("Vaccine added to catalog")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(v)
+}
+
+// UpdateVaccine - staff change a vaccine schedule; existing due dates are kept
+func UpdateVaccine(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, ok := requireStaff(w, r); !ok {
+		return
+	}
+
+	var v models.Vaccine
+	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+		ErrorResponse(w, "Invalid vaccine input", http.StatusBadRequest, err)
+		return
+	}
+	if !validVaccine(v) {
+		http.Error(w, "name, species, initial_doses >= 1 and booster_interval_days > 0 are required", http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.DB.Exec(`UPDATE vaccines SET name=$1, species=$2, core=$3, first_dose_age_weeks=$4,
+			initial_doses=$5, initial_interval_days=$6, booster_interval_days=$7
+		WHERE id=$8`,
+		v.Name, v.Species, v.Core, v.FirstDoseAgeWeeks, v.InitialDoses, v.InitialIntervalDays, v.BoosterIntervalDays, id)
+	if err != nil {
+		ErrorResponse(w, "Failed to update vaccine", http.StatusInternalServerError, err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Vaccine not found", http.StatusNotFound)
+		return
+	}
+
+	utils.Log.WithField("id", id).Info("Vaccine updated")
+	w.Write([]byte("Vaccine updated"))
+}
+
+// RecordVaccination - staff record a dose; next_due_date defaults from the vaccine schedule
+func RecordVaccination(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	utils.Log.WithField("pet_id", petID).Debug("POST /pets/{id}/vaccinations called")
+
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	var v models.Vaccination
+	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+		ErrorResponse(w, "Invalid vaccination input", http.StatusBadRequest, err)
+		return
+	}
+	if v.AdministeredOn == "" {
+		v.AdministeredOn = time.Now().In(utils.ClinicLocation()).Format("2006-01-02")
+	}
+	given, err := time.Parse("2006-01-02", v.AdministeredOn)
+	if err != nil {
+		http.Error(w, "administered_on must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	var petSpecies string
+	err = db.DB.QueryRow("SELECT COALESCE(species, '') FROM pets WHERE id=$1", petID).Scan(&petSpecies)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Pet not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		ErrorResponse(w, "Failed to load pet", http.StatusInternalServerError, err)
+		return
+	}
+	if v.VisitID != nil {
+		if _, err := parseAttachmentVisit(petID, strconv.Itoa(*v.VisitID)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var vaccine models.Vaccine
+	err = db.DB.QueryRow(`SELECT name, species, initial_doses, initial_interval_days, booster_interval_days
+		FROM vaccines WHERE id=$1`, v.VaccineID).
+		Scan(&vaccine.Name, &vaccine.Species, &vaccine.InitialDoses, &vaccine.InitialIntervalDays, &vaccine.BoosterIntervalDays)
+	if err != nil {
+		ErrorResponse(w, "Unknown vaccine_id", http.StatusBadRequest, err)
+		return
+	}
+	if !strings.EqualFold(vaccine.Species, petSpecies) {
+		http.Error(w, "Vaccine "+vaccine.Name+" is for "+vaccine.Species+", not "+petSpecies, http.StatusBadRequest)
+		return
+	}
+
+	if v.DoseNumber == 0 {
+		db.DB.QueryRow(`SELECT COUNT(*) + 1 FROM vaccinations WHERE pet_id=$1 AND vaccine_id=$2`, petID, v.VaccineID).Scan(&v.DoseNumber)
+	}
+	if v.NextDueDate == "" {
+		interval := vaccine.BoosterIntervalDays
+		if v.DoseNumber < vaccine.InitialDoses {
+			interval = vaccine.InitialIntervalDays
+		}
+		v.NextDueDate = given.AddDate(0, 0, interval).Format("2006-01-02")
+	} else if _, err := time.Parse("2006-01-02", v.NextDueDate); err != nil {
+		http.Error(w, "next_due_date must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+
+	v.PetID = petID
+	v.VaccineName = vaccine.Name
+	v.CreatedBy = username
+	err = db.DB.QueryRow(`INSERT INTO vaccinations (pet_id, vaccine_id, visit_id, administered_on, dose_number,
+			product, lot_number, administering_vet, next_due_date, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`,
+		v.PetID, v.VaccineID, v.VisitID, v.AdministeredOn, v.DoseNumber,
+		v.Product, v.LotNumber, v.AdministeringVet, v.NextDueDate, username).Scan(&v.ID)
+	if err != nil {
+		ErrorResponse(w, "Failed to record vaccination", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"id": v.ID, "pet_id": petID, "vaccine": vaccine.Name, "next_due": v.NextDueDate}).Info("Vaccination recorded")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(v)
+}
+
+func loadPetVaccinations(petID int) ([]models.Vaccination, error) {
+	rows, err := db.DB.Query(`SELECT `+vaccinationColumns+`
+		FROM vaccinations v JOIN vaccines vc ON vc.id = v.vaccine_id
+		WHERE v.pet_id = $1
+		ORDER BY v.administered_on DESC, v.id DESC`, petID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []models.Vaccination{}
+	for rows.Next() {
+		var v models.Vaccination
+		if err := scanVaccination(rows, &v); err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, rows.Err()
+}
+
+// GetPetVaccinations - vaccination history of a pet
+func GetPetVaccinations(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	if _, _, ok := authorizePetAccess(w, r, petID); !ok {
+		return
+	}
+
+	list, err := loadPetVaccinations(petID)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch vaccinations", http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// GetDueVaccinations - staff list of overdue and upcoming (within ?days=, default 30) vaccinations,
+// based on each pet's latest dose of each vaccine
+func GetDueVaccinations(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireStaff(w, r); !ok {
+		return
+	}
+
+	days := 30
+	if d, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && d >= 0 {
+		days = d
+	}
+
+	rows, err := db.DB.Query(`SELECT l.pet_id, p.name, o.id, o.name, COALESCE(o.contact, ''), l.vaccine_id, vc.name,
+			l.administered_on::text, l.next_due_date::text, l.next_due_date - CURRENT_DATE, l.id
+		FROM (
+			SELECT DISTINCT ON (pet_id, vaccine_id) *
+			FROM vaccinations
+			ORDER BY pet_id, vaccine_id, administered_on DESC, id DESC
+		) l
+		JOIN vaccines vc ON vc.id = l.vaccine_id
+		JOIN pets p ON p.id = l.pet_id
+		JOIN owners o ON o.id = p.owner_id
+		WHERE l.next_due_date IS NOT NULL AND l.next_due_date <= CURRENT_DATE + $1::int
+		  AND p.status = 'active'
+		ORDER BY l.next_due_date, p.name`, days)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch due vaccinations", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	due := []models.VaccinationDue{}
+	for rows.Next() {
+		var d models.VaccinationDue
+		if err := rows.Scan(&d.PetID, &d.PetName, &d.OwnerID, &d.OwnerName, &d.OwnerContact, &d.VaccineID, &d.VaccineName,
+			&d.LastGivenOn, &d.NextDueDate, &d.DaysUntilDue, &d.VaccinationID); err != nil {
+			ErrorResponse(w, "Error scanning due vaccinations", http.StatusInternalServerError, err)
+			return
+		}
+		d.Status = "upcoming"
+		if d.DaysUntilDue < 0 {
+			d.Status = "overdue"
+		}
+		due = append(due, d)
+	}
+
+	utils.Log.WithField("count", len(due)).Info("Due vaccinations fetched")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(due)
+}
+
+var certificateTemplate = template.Must(template.New("certificate").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Vaccination certificate - {{.Pet.Name}}</title>
+<style>
+body { font-family: Arial, sans-serif; margin: 2cm; color: #222; }
+h1 { font-size: 20pt; margin-bottom: 0; }
+.sub { color: #666; margin-top: 4px; }
+table { width: 100%; border-collapse: collapse; margin-top: 1em; font-size: 10pt; }
+th, td { border: 1px solid #999; padding: 6px; text-align: left; }
+th { background: #eee; }
+.sign { margin-top: 3em; }
+@media print { body { margin: 1cm; } }
+</style>
+</head>
+<body>
+<h1>Vaccination certificate</h1>
+<p class="sub">{{.Clinic}} &middot; issued {{.Issued}}</p>
+
+<table>
+<tr><th>Pet</th><td>{{.Pet.Name}}</td><th>Owner</th><td>{{.Owner.Name}}</td></tr>
+<tr><th>Species</th><td>{{.Pet.Species}}</td><th>Contact</th><td>{{.Owner.Contact}}</td></tr>
+<tr><th>Breed</th><td>{{.Pet.Breed}}</td><th>Email</th><td>{{.Owner.Email}}</td></tr>
+</table>
+
+<table>
+<tr><th>Vaccine</th><th>Dose</th><th>Date given</th><th>Product</th><th>Lot no.</th><th>Vet</th><th>Next due</th></tr>
+{{range .Vaccinations}}<tr><td>{{.VaccineName}}</td><td>{{.DoseNumber}}</td><td>{{.AdministeredOn}}</td><td>{{.Product}}</td><td>{{.LotNumber}}</td><td>{{.AdministeringVet}}</td><td>{{.NextDueDate}}</td></tr>
+{{else}}<tr><td colspan="7">No vaccinations on record</td></tr>
+{{end}}</table>
+
+<p class="sign">Signature: ______________________________</p>
+</body>
+</html>
+`))
+
+// GetVaccinationCertificate - printable HTML certificate of a pet's vaccinations
+func GetVaccinationCertificate(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	if _, _, ok := authorizePetAccess(w, r, petID); !ok {
+		return
+	}
+
+	var data struct {
+		Clinic       string
+		Issued       string
+		Pet          models.Pet
+		Owner        models.Owner
+		Vaccinations []models.Vaccination
+	}
+	err = db.DB.QueryRow(`SELECT p.id, p.name, COALESCE(p.species, ''), COALESCE(p.breed, ''),
+			o.name, COALESCE(o.contact, ''), COALESCE(o.email, '')
+		FROM pets p JOIN owners o ON o.id = p.owner_id WHERE p.id=$1`, petID).
+		Scan(&data.Pet.ID, &data.Pet.Name, &data.Pet.Species, &data.Pet.Breed, &data.Owner.Name, &data.Owner.Contact, &data.Owner.Email)
+	if err != nil {
+		ErrorResponse(w, "Pet not found", http.StatusNotFound, err)
+		return
+	}
+
+	if data.Vaccinations, err = loadPetVaccinations(petID); err != nil {
+		ErrorResponse(w, "Failed to fetch vaccinations", http.StatusInternalServerError, err)
+		return
+	}
+	data.Clinic = os.Getenv("CLINIC_NAME")
+	if data.Clinic == "" {
+		data.Clinic = "Pet Clinic"
+	}
+	data.Issued = time.Now().In(utils.ClinicLocation()).Format("02 Jan 2006")
+
+	utils.Log.WithField("pet_id", petID).Info("Vaccination certificate generated")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	certificateTemplate.Execute(w, data)
+}
@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"pet-clinic/db"
+	"pet-clinic/models"
+	"pet-clinic/utils"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// loadVisits fetches visits matching the condition and applies their amendments in order
+func loadVisits(condition string, args ...interface{}) ([]models.Visit, error) {
+	rows, err := db.DB.Query(`SELECT id, pet_id, appointment_id, visit_date::text, COALESCE(vet, ''), kind,
+			subjective, objective, assessment, plan, COALESCE(created_by, ''), created_at::text
+		FROM visits WHERE `+condition+`
+		ORDER BY visit_date DESC, id DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	visits := []models.Visit{}
+	index := map[int]int{}
+	for rows.Next() {
+		var v models.Visit
+		var apptID sql.NullInt64
+		if err := rows.Scan(&v.ID, &v.PetID, &apptID, &v.VisitDate, &v.Vet, &v.Kind,
+			&v.Subjective, &v.Objective, &v.Assessment, &v.Plan, &v.CreatedBy, &v.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if apptID.Valid {
+			id := int(apptID.Int64)
+			v.AppointmentID = &id
+		}
+		v.Amendments = []models.VisitAmendment{}
+		index[v.ID] = len(visits)
+		visits = append(visits, v)
+	}
+	rows.Close()
+	if len(visits) == 0 {
+		return visits, nil
+	}
+
+	ids := make([]int64, 0, len(visits))
+	for _, v := range visits {
+		ids = append(ids, int64(v.ID))
+	}
+	rows, err = db.DB.Query(`SELECT id, visit_id, subjective, objective, assessment, plan, reason,
+			COALESCE(amended_by, ''), amended_at::text
+		FROM visit_amendments
+		WHERE visit_id = ANY($1)
+		ORDER BY amended_at, id`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a models.VisitAmendment
+		var s, o, as, p sql.NullString
+		if err := rows.Scan(&a.ID, &a.VisitID, &s, &o, &as, &p, &a.Reason, &a.AmendedBy, &a.AmendedAt); err != nil {
+			return nil, err
+		}
+
+		v := &visits[index[a.VisitID]]
+		if v.Original == nil {
+			original := v.SOAPNotes
+			v.Original = &original
+		}
+		apply := func(src sql.NullString, dst *string, field **string) {
+			if src.Valid {
+				val := src.String
+				*dst = val
+				*field = &val
+			}
+		}
+		apply(s, &v.Subjective, &a.Subjective)
+		apply(o, &v.Objective, &a.Objective)
+		apply(as, &v.Assessment, &a.Assessment)
+		apply(p, &v.Plan, &a.Plan)
+		v.Amendments = append(v.Amendments, a)
+	}
+	return visits, rows.Err()
+}
+
+// addLegacyNote records free-text medical history as a 'legacy' entry so it is never overwritten
+func addLegacyNote(petID int, text, username string) error {
+	_, err := db.DB.Exec(`INSERT INTO visits (pet_id, kind, subjective, created_by)
+		VALUES ($1, 'legacy', $2, $3)`, petID, text, username)
+	return err
+}
+
+// AddVisit - staff record an encounter with SOAP notes, optionally for an appointment
+func AddVisit(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	utils.Log.WithField("pet_id", petID).Debug("POST /pets/{id}/visits called")
+
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	var v models.Visit
+	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+		ErrorResponse(w, "Invalid visit input", http.StatusBadRequest, err)
+		return
+	}
+	if v.VisitDate == "" {
+		v.VisitDate = time.Now().In(utils.ClinicLocation()).Format("2006-01-02")
+	}
+	if _, err := time.Parse("2006-01-02", v.VisitDate); err != nil {
+		http.Error(w, "visit_date must be YYYY-MM-DD", http.StatusBadRequest)
+		return
+	}
+	if v.Subjective == "" && v.Objective == "" && v.Assessment == "" && v.Plan == "" {
+		http.Error(w, "At least one SOAP note is required", http.StatusBadRequest)
+		return
+	}
+
+	if v.AppointmentID != nil {
+		var apptPetID int
+		err := db.DB.QueryRow("SELECT pet_id FROM appointments WHERE id=$1", *v.AppointmentID).Scan(&apptPetID)
+		if err != nil || apptPetID != petID {
+			http.Error(w, "appointment_id does not belong to this pet", http.StatusBadRequest)
+			return
+		}
+		if v.Vet == "" {
+			db.DB.QueryRow("SELECT COALESCE(vet, '') FROM appointments WHERE id=$1", *v.AppointmentID).Scan(&v.Vet)
+		}
+	}
+
+	v.PetID = petID
+	v.Kind = "visit"
+	v.CreatedBy = username
+	v.Amendments = []models.VisitAmendment{}
+	err = db.DB.QueryRow(`INSERT INTO visits (pet_id, appointment_id, visit_date, vet, kind,
+			subjective, objective, assessment, plan, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id, created_at::text`,
+		v.PetID, v.AppointmentID, v.VisitDate, v.Vet, v.Kind,
+		v.Subjective, v.Objective, v.Assessment, v.Plan, username).Scan(&v.ID, &v.CreatedAt)
+	if err != nil {
+		ErrorResponse(w, "Failed to record visit", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"id": v.ID, "pet_id": petID, "vet": v.Vet}).Info("Visit recorded")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(v)
+}
+
+// GetPetVisits - medical record of a pet, newest first
+func GetPetVisits(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	if _, _, ok := authorizePetAccess(w, r, petID); !ok {
+		return
+	}
+
+	visits, err := loadVisits("pet_id = $1", petID)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch visits", http.StatusInternalServerError, err)
+		return
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"pet_id": petID, "count": len(visits)}).Info("Visits fetched successfully")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(visits)
+}
+
+// GetVisit - a single record with its amendment history
+func GetVisit(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	visits, err := loadVisits("id = $1", id)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch visit", http.StatusInternalServerError, err)
+		return
+	}
+	if len(visits) == 0 {
+		http.Error(w, "Visit not found", http.StatusNotFound)
+		return
+	}
+	if _, _, ok := authorizePetAccess(w, r, visits[0].PetID); !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(visits[0])
+}
+
+// AmendVisit - staff correct a record; the original text stays in the history
+func AmendVisit(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	utils.Log.WithField("id", id).Debug("POST /visits/{id}/amendments called")
+
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	var a models.VisitAmendment
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		ErrorResponse(w, "Invalid amendment input", http.StatusBadRequest, err)
+		return
+	}
+	if strings.TrimSpace(a.Reason) == "" {
+		http.Error(w, "reason is required", http.StatusBadRequest)
+		return
+	}
+	if a.Subjective == nil && a.Objective == nil && a.Assessment == nil && a.Plan == nil {
+		http.Error(w, "Amendment must change at least one SOAP note", http.StatusBadRequest)
+		return
+	}
+
+	var petID int
+	if err := db.DB.QueryRow("SELECT pet_id FROM visits WHERE id=$1", id).Scan(&petID); err != nil {
+		ErrorResponse(w, "Visit not found", http.StatusNotFound, err)
+		return
+	}
+
+	err := db.DB.QueryRow(`INSERT INTO visit_amendments (visit_id, subjective, objective, assessment, plan, reason, amended_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, visit_id, amended_at::text`,
+		id, a.Subjective, a.Objective, a.Assessment, a.Plan, a.Reason, username).Scan(&a.ID, &a.VisitID, &a.AmendedAt)
+	if err != nil {
+		ErrorResponse(w, "Failed to amend visit", http.StatusInternalServerError, err)
+		return
+	}
+	a.AmendedBy = username
+
+	utils.Log.WithFields(map[string]interface{}{"visit_id": id, "amendment_id": a.ID, "user": username}).Warn("Visit record amended")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(a)
+}
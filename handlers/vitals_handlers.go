@@ -0,0 +1,458 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"pet-clinic/charts"
+	"pet-clinic/db"
+	"pet-clinic/models"
+	"pet-clinic/utils"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// rawVitals is one stored reading in canonical units
+type rawVitals struct {
+	id         int
+	visitID    *int
+	recordedAt time.Time
+	weightKg   *float64
+	tempC      *float64
+	heartRate  *float64
+	respRate   *float64
+	recordedBy string
+}
+
+func toKg(m models.Measurement) (float64, error) {
+	switch strings.ToLower(m.Unit) {
+	case "kg", "":
+		return m.Value, nil
+	case "lb", "lbs":
+		return m.Value * 0.45359237, nil
+	}
+	return 0, fmt.Errorf("unknown weight unit %q (use kg or lb)", m.Unit)
+}
+
+func toCelsius(m models.Measurement) (float64, error) {
+	switch strings.ToUpper(strings.TrimPrefix(m.Unit, "°")) {
+	case "C", "":
+		return m.Value, nil
+	case "F":
+		return (m.Value - 32) * 5 / 9, nil
+	}
+	return 0, fmt.Errorf("unknown temperature unit %q (use C or F)", m.Unit)
+}
+
+func round(v float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(v*p) / p
+}
+
+func weightIn(kg float64, unit string) *models.Measurement {
+	if strings.ToLower(unit) == "lb" {
+		return &models.Measurement{Value: round(kg/0.45359237, 2), Unit: "lb"}
+	}
+	return &models.Measurement{Value: round(kg, 2), Unit: "kg"}
+}
+
+func temperatureIn(c float64, unit string) *models.Measurement {
+	if strings.ToUpper(unit) == "F" {
+		return &models.Measurement{Value: round(c*9/5+32, 1), Unit: "F"}
+	}
+	return &models.Measurement{Value: round(c, 1), Unit: "C"}
+}
+
+// loadReferenceRanges returns the ranges for the pet's species, breed-specific rows winning
+func loadReferenceRanges(petID int) (map[string]models.ReferenceRange, error) {
+	rows, err := db.DB.Query(`SELECT r.measure, r.min_value, r.max_value, r.breed
+		FROM vital_reference_ranges r JOIN pets p ON LOWER(r.species) = LOWER(p.species)
+		WHERE p.id = $1 AND (r.breed = '' OR LOWER(r.breed) = LOWER(p.breed))
+		ORDER BY r.breed = ''`, petID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ranges := map[string]models.ReferenceRange{}
+	for rows.Next() {
+		var rr models.ReferenceRange
+		if err := rows.Scan(&rr.Measure, &rr.Min, &rr.Max, &rr.Breed); err != nil {
+			return nil, err
+		}
+		if _, seen := ranges[rr.Measure]; !seen {
+			ranges[rr.Measure] = rr
+		}
+	}
+	return ranges, rows.Err()
+}
+
+// vitalsFlags lists readings outside their reference range
+func vitalsFlags(v rawVitals, ranges map[string]models.ReferenceRange) []string {
+	var flags []string
+	check := func(measure string, value *float64) {
+		rr, ok := ranges[measure]
+		if value == nil || !ok {
+			return
+		}
+		switch {
+		case *value < rr.Min:
+			flags = append(flags, fmt.Sprintf("%s low (%.1f < %.1f)", measure, *value, rr.Min))
+		case *value > rr.Max:
+			flags = append(flags, fmt.Sprintf("%s high (%.1f > %.1f)", measure, *value, rr.Max))
+		}
+	}
+	check("weight_kg", v.weightKg)
+	check("temperature_c", v.tempC)
+	check("heart_rate_bpm", v.heartRate)
+	check("respiratory_rate_bpm", v.respRate)
+	return flags
+}
+
+func loadRawVitals(petID int, from, to string) ([]rawVitals, error) {
+	rows, err := db.DB.Query(`SELECT id, visit_id, recorded_at, weight_kg::float8, temperature_c::float8,
+			heart_rate_bpm::float8, respiratory_rate_bpm::float8, COALESCE(recorded_by, '')
+		FROM vitals
+		WHERE pet_id = $1 AND recorded_at >= $2::timestamp AND recorded_at < $3::timestamp
+		ORDER BY recorded_at, id`, petID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []rawVitals
+	for rows.Next() {
+		var v rawVitals
+		if err := rows.Scan(&v.id, &v.visitID, &v.recordedAt, &v.weightKg, &v.tempC, &v.heartRate, &v.respRate, &v.recordedBy); err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, rows.Err()
+}
+
+// downsampleVitals averages readings into at most `points` equal time buckets
+func downsampleVitals(list []rawVitals, points int, ranges map[string]models.ReferenceRange) []rawBucket {
+	if len(list) == 0 {
+		return nil
+	}
+	if points <= 0 || len(list) <= points {
+		buckets := make([]rawBucket, len(list))
+		for i, v := range list {
+			buckets[i] = rawBucket{rawVitals: v, samples: 1, flags: vitalsFlags(v, ranges)}
+		}
+		return buckets
+	}
+
+	start, end := list[0].recordedAt, list[len(list)-1].recordedAt
+	width := end.Sub(start)/time.Duration(points) + 1
+
+	var buckets []rawBucket
+	for i := 0; i < len(list); {
+		limit := start.Add(width * time.Duration(int(list[i].recordedAt.Sub(start)/width)+1))
+		var group []rawVitals
+		for i < len(list) && list[i].recordedAt.Before(limit) {
+			group = append(group, list[i])
+			i++
+		}
+		buckets = append(buckets, mergeVitals(group, ranges))
+	}
+	return buckets
+}
+
+type rawBucket struct {
+	rawVitals
+	samples int
+	flags   []string
+}
+
+func mergeVitals(group []rawVitals, ranges map[string]models.ReferenceRange) rawBucket {
+	avg := func(get func(rawVitals) *float64) *float64 {
+		sum, n := 0.0, 0
+		for _, v := range group {
+			if p := get(v); p != nil {
+				sum += *p
+				n++
+			}
+		}
+		if n == 0 {
+			return nil
+		}
+		m := sum / float64(n)
+		return &m
+	}
+
+	var offset time.Duration
+	for _, v := range group {
+		offset += v.recordedAt.Sub(group[0].recordedAt)
+	}
+	b := rawBucket{samples: len(group)}
+	b.recordedAt = group[0].recordedAt.Add(offset / time.Duration(len(group)))
+	b.weightKg = avg(func(v rawVitals) *float64 { return v.weightKg })
+	b.tempC = avg(func(v rawVitals) *float64 { return v.tempC })
+	b.heartRate = avg(func(v rawVitals) *float64 { return v.heartRate })
+	b.respRate = avg(func(v rawVitals) *float64 { return v.respRate })
+
+	// any out-of-range reading in the bucket keeps its flag
+	seen := map[string]bool{}
+	for _, v := range group {
+		for _, f := range vitalsFlags(v, ranges) {
+			if !seen[f] {
+				seen[f] = true
+				b.flags = append(b.flags, f)
+			}
+		}
+	}
+	sort.Strings(b.flags)
+	return b
+}
+
+// RecordVitals - staff record weight, temperature, heart and respiratory rate (kg/lb, C/F accepted)
+func RecordVitals(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	username, ok := requireStaff(w, r)
+	if !ok {
+		return
+	}
+
+	var in models.VitalsInput
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		ErrorResponse(w, "Invalid vitals input", http.StatusBadRequest, err)
+		return
+	}
+	if in.Weight == nil && in.Temperature == nil && in.HeartRateBPM == nil && in.RespiratoryRateBPM == nil {
+		http.Error(w, "At least one measurement is required", http.StatusBadRequest)
+		return
+	}
+
+	if in.VisitID != nil {
+		if _, err := parseAttachmentVisit(petID, strconv.Itoa(*in.VisitID)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	v := rawVitals{visitID: in.VisitID, recordedBy: username}
+	if in.Weight != nil {
+		kg, err := toKg(*in.Weight)
+		if err != nil || kg <= 0 {
+			http.Error(w, "Invalid weight", http.StatusBadRequest)
+			return
+		}
+		v.weightKg = &kg
+	}
+	if in.Temperature != nil {
+		c, err := toCelsius(*in.Temperature)
+		if err != nil || c < 20 || c > 50 {
+			http.Error(w, "Invalid temperature", http.StatusBadRequest)
+			return
+		}
+		v.tempC = &c
+	}
+	if in.HeartRateBPM != nil {
+		if *in.HeartRateBPM <= 0 {
+			http.Error(w, "Invalid heart rate", http.StatusBadRequest)
+			return
+		}
+		hr := float64(*in.HeartRateBPM)
+		v.heartRate = &hr
+	}
+	if in.RespiratoryRateBPM != nil {
+		if *in.RespiratoryRateBPM <= 0 {
+			http.Error(w, "Invalid respiratory rate", http.StatusBadRequest)
+			return
+		}
+		rr := float64(*in.RespiratoryRateBPM)
+		v.respRate = &rr
+	}
+	v.recordedAt = time.Now().In(utils.ClinicLocation())
+	if in.RecordedAt != "" {
+		if v.recordedAt, err = parseLocalTimestamp(in.RecordedAt); err != nil {
+			http.Error(w, "recorded_at must be YYYY-MM-DDTHH:MM", http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = db.DB.QueryRow(`INSERT INTO vitals (pet_id, visit_id, recorded_at, weight_kg, temperature_c,
+			heart_rate_bpm, respiratory_rate_bpm, recorded_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		petID, v.visitID, v.recordedAt.Format(localTimestampFormat), v.weightKg, v.tempC,
+		in.HeartRateBPM, in.RespiratoryRateBPM, username).Scan(&v.id)
+	if err != nil {
+		ErrorResponse(w, "Failed to record vitals", http.StatusInternalServerError, err)
+		return
+	}
+
+	ranges, err := loadReferenceRanges(petID)
+	if err != nil {
+		utils.Log.WithError(err).Warn("Failed to load reference ranges")
+	}
+	reading := vitalsReading(petID, rawBucket{rawVitals: v, flags: vitalsFlags(v, ranges)}, "", "")
+	if len(reading.Flags) > 0 {
+		utils.Log.WithFields(map[string]interface{}{"pet_id": petID, "flags": reading.Flags}).Warn("Out-of-range vitals recorded")
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"id": v.id, "pet_id": petID}).Info("Vitals recorded")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(reading)
+}
+
+func vitalsReading(petID int, b rawBucket, weightUnit, tempUnit string) models.VitalsReading {
+	reading := models.VitalsReading{
+		ID:         b.id,
+		PetID:      petID,
+		VisitID:    b.visitID,
+		RecordedAt: b.recordedAt.Format(localTimestampFormat),
+		Flags:      b.flags,
+		RecordedBy: b.recordedBy,
+	}
+	if b.samples > 1 {
+		reading.Samples = b.samples
+	}
+	if b.weightKg != nil {
+		reading.Weight = weightIn(*b.weightKg, weightUnit)
+	}
+	if b.tempC != nil {
+		reading.Temperature = temperatureIn(*b.tempC, tempUnit)
+	}
+	if b.heartRate != nil {
+		hr := round(*b.heartRate, 1)
+		reading.HeartRateBPM = &hr
+	}
+	if b.respRate != nil {
+		rr := round(*b.respRate, 1)
+		reading.RespiratoryRateBPM = &rr
+	}
+	return reading
+}
+
+// vitalsWindow reads ?from= and ?to= (YYYY-MM-DD); defaults to the last year
+func vitalsWindow(r *http.Request) (from, to string) {
+	from = r.URL.Query().Get("from")
+	if from == "" {
+		from = time.Now().AddDate(-1, 0, 0).Format("2006-01-02")
+	}
+	to = r.URL.Query().Get("to")
+	if to == "" {
+		to = "infinity"
+	}
+	return from, to
+}
+
+// GetVitalsHistory - readings in ?weight_unit=kg|lb and ?temp_unit=C|F, downsampled to ?points= (default 200)
+func GetVitalsHistory(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	if _, _, ok := authorizePetAccess(w, r, petID); !ok {
+		return
+	}
+
+	points := 200
+	if p, err := strconv.Atoi(r.URL.Query().Get("points")); err == nil && p > 0 {
+		points = p
+	}
+	from, to := vitalsWindow(r)
+
+	list, err := loadRawVitals(petID, from, to)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch vitals", http.StatusInternalServerError, err)
+		return
+	}
+	ranges, err := loadReferenceRanges(petID)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch reference ranges", http.StatusInternalServerError, err)
+		return
+	}
+
+	readings := []models.VitalsReading{}
+	for _, b := range downsampleVitals(list, points, ranges) {
+		readings = append(readings, vitalsReading(petID, b, r.URL.Query().Get("weight_unit"), r.URL.Query().Get("temp_unit")))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(readings)
+}
+
+// GetVitalsReferenceRanges - ranges that apply to this pet's species and breed
+func GetVitalsReferenceRanges(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	if _, _, ok := authorizePetAccess(w, r, petID); !ok {
+		return
+	}
+
+	ranges, err := loadReferenceRanges(petID)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch reference ranges", http.StatusInternalServerError, err)
+		return
+	}
+	list := []models.ReferenceRange{}
+	for _, rr := range ranges {
+		list = append(list, rr)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Measure < list[j].Measure })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// GetWeightChart - SVG weight trend with the breed/species reference band (?unit=kg|lb)
+func GetWeightChart(w http.ResponseWriter, r *http.Request) {
+	petID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid pet id", http.StatusBadRequest)
+		return
+	}
+	if _, _, ok := authorizePetAccess(w, r, petID); !ok {
+		return
+	}
+
+	var petName string
+	db.DB.QueryRow("SELECT name FROM pets WHERE id=$1", petID).Scan(&petName)
+
+	from, to := vitalsWindow(r)
+	list, err := loadRawVitals(petID, from, to)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch vitals", http.StatusInternalServerError, err)
+		return
+	}
+	ranges, err := loadReferenceRanges(petID)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch reference ranges", http.StatusInternalServerError, err)
+		return
+	}
+
+	unit := r.URL.Query().Get("unit")
+	chart := &charts.LineChart{Title: "Weight - " + petName, Unit: weightIn(0, unit).Unit}
+	for _, b := range downsampleVitals(list, 200, ranges) {
+		if b.weightKg != nil {
+			chart.Points = append(chart.Points, charts.Point{Time: b.recordedAt, Value: weightIn(*b.weightKg, unit).Value})
+		}
+	}
+	if rr, ok := ranges["weight_kg"]; ok {
+		lo, hi := weightIn(rr.Min, unit).Value, weightIn(rr.Max, unit).Value
+		chart.RangeMin, chart.RangeMax = &lo, &hi
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "private, max-age=60")
+	if err := chart.WriteSVG(w); err != nil {
+		utils.Log.WithError(err).Error("Failed to write weight chart")
+	}
+}
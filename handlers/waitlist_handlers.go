@@ -0,0 +1,481 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"os"
+	"pet-clinic/db"
+	"pet-clinic/models"
+	"pet-clinic/utils"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// freedSlot is an appointment slot that became available again
+type freedSlot struct {
+	Date string
+	Time string
+	Vet  string
+	Type string
+}
+
+// waitlistHold returns how long an offered slot is held (WAITLIST_HOLD_MINUTES, default 30)
+func waitlistHold() time.Duration {
+	if m, err := strconv.Atoi(os.Getenv("WAITLIST_HOLD_MINUTES")); err == nil && m > 0 {
+		return time.Duration(m) * time.Minute
+	}
+	return 30 * time.Minute
+}
+
+// slotHeldForWaitlist reports whether a pending, unexpired offer holds the slot
+func slotHeldForWaitlist(date, tm, vet string) (bool, error) {
+	var held bool
+	err := db.DB.QueryRow(`SELECT EXISTS (
+		SELECT 1 FROM waitlist_offers
+		WHERE status = 'pending' AND expires_at > NOW()
+		  AND date = $1::date AND time = $2::time AND COALESCE(vet, '') = $3)`,
+		date, tm, vet).Scan(&held)
+	return held, err
+}
+
+// offerFreedSlot offers a freed slot to the longest-waiting matching entry.
+// Entries that were already offered this slot are skipped.
+func offerFreedSlot(s freedSlot) {
+	log := utils.Log.WithFields(map[string]interface{}{"date": s.Date, "time": s.Time, "vet": s.Vet})
+
+	// a slot that falls into a closure is not worth offering
+	if start, err := parseSlot(s.Date, s.Time); err == nil {
+		if reason, err := clinicClosedReason(start); err != nil || reason != "" {
+			log.WithError(err).Debug("Freed slot is not bookable, not offering it")
+			return
+		}
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		log.WithError(err).Error("Failed to start waitlist offer transaction")
+		return
+	}
+	defer tx.Rollback()
+
+	var entryID int
+	err = tx.QueryRow(`SELECT e.id FROM waitlist_entries e
+		JOIN pets p ON p.id = e.pet_id AND p.status = 'active'
+		WHERE e.status = 'waiting'
+		  AND $1::date >= CURRENT_DATE
+		  AND $1::date BETWEEN e.earliest_date AND e.latest_date
+		  AND (cardinality(e.vets) = 0 OR $3 = ANY(e.vets))
+		  AND (cardinality(e.appointment_types) = 0 OR $4 = ANY(e.appointment_types))
+		  AND NOT EXISTS (
+			SELECT 1 FROM waitlist_offers o
+			WHERE o.entry_id = e.id AND o.date = $1::date AND o.time = $2::time AND COALESCE(o.vet, '') = $3)
+		ORDER BY e.created_at, e.id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED`,
+		s.Date, s.Time, s.Vet, s.Type).Scan(&entryID)
+	if err == sql.ErrNoRows {
+		log.Debug("No waitlist entry matches the freed slot")
+		return
+	}
+	if err != nil {
+		log.WithError(err).Error("Failed to look up waitlist entries")
+		return
+	}
+
+	var offerID int
+	err = tx.QueryRow(`INSERT INTO waitlist_offers (entry_id, date, time, vet, appointment_type, expires_at)
+		VALUES ($1, $2, $3, $4, $5, NOW() + $6 * INTERVAL '1 second') RETURNING id`,
+		entryID, s.Date, s.Time, s.Vet, s.Type, int64(waitlistHold()/time.Second)).Scan(&offerID)
+	if err != nil {
+		log.WithError(err).Error("Failed to create waitlist offer")
+		return
+	}
+
+	if _, err := tx.Exec(`UPDATE waitlist_entries SET status='offered' WHERE id=$1`, entryID); err != nil {
+		log.WithError(err).Error("Failed to mark waitlist entry as offered")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.WithError(err).Error("Failed to commit waitlist offer")
+		return
+	}
+
+	log.WithFields(map[string]interface{}{"entry_id": entryID, "offer_id": offerID}).Info("Freed slot offered to waitlist")
+}
+
+// releaseOffer returns the entry to the queue and passes the slot on to the next entry
+func releaseOffer(entryID int, s freedSlot) {
+	if _, err := db.DB.Exec(`UPDATE waitlist_entries SET status='waiting' WHERE id=$1 AND status='offered'`, entryID); err != nil {
+		utils.Log.WithError(err).WithField("entry_id", entryID).Error("Failed to return waitlist entry to queue")
+	}
+	offerFreedSlot(s)
+}
+
+// RunWaitlistExpiry periodically expires offers whose hold has run out
+func RunWaitlistExpiry(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		expireWaitlistOffers()
+	}
+}
+
+func expireWaitlistOffers() {
+	rows, err := db.DB.Query(`UPDATE waitlist_offers SET status='expired'
+		WHERE status='pending' AND expires_at <= NOW()
+		RETURNING entry_id, date::text, time::text, COALESCE(vet, ''), COALESCE(appointment_type, '')`)
+	if err != nil {
+		utils.Log.WithError(err).Error("Failed to expire waitlist offers")
+		return
+	}
+
+	type expired struct {
+		entryID int
+		slot    freedSlot
+	}
+	var list []expired
+	for rows.Next() {
+		var e expired
+		if err := rows.Scan(&e.entryID, &e.slot.Date, &e.slot.Time, &e.slot.Vet, &e.slot.Type); err != nil {
+			utils.Log.WithError(err).Error("Error scanning expired waitlist offer")
+			continue
+		}
+		list = append(list, e)
+	}
+	rows.Close()
+
+	for _, e := range list {
+		utils.Log.WithField("entry_id", e.entryID).Info("Waitlist offer expired")
+		releaseOffer(e.entryID, e.slot)
+	}
+}
+
+// AddWaitlistEntry - put a pet on the waitlist (owners only for their own pets)
+func AddWaitlistEntry(w http.ResponseWriter, r *http.Request) {
+	utils.Log.Debug("POST /waitlist called")
+
+	var e models.WaitlistEntry
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		ErrorResponse(w, "Invalid waitlist input", http.StatusBadRequest, err)
+		return
+	}
+
+	earliest, err1 := time.Parse("2006-01-02", e.EarliestDate)
+	latest, err2 := time.Parse("2006-01-02", e.LatestDate)
+	if err1 != nil || err2 != nil || latest.Before(earliest) {
+		http.Error(w, "earliest_date and latest_date must be YYYY-MM-DD with earliest_date <= latest_date", http.StatusBadRequest)
+		return
+	}
+
+	username, _, ok := authorizePetAccess(w, r, e.PetID)
+	if !ok {
+		return
+	}
+	if !requireActivePet(w, e.PetID) {
+		return
+	}
+
+	if e.Vets == nil {
+		e.Vets = []string{}
+	}
+	if e.AppointmentTypes == nil {
+		e.AppointmentTypes = []string{}
+	}
+
+	err := db.DB.QueryRow(`INSERT INTO waitlist_entries
+		(pet_id, earliest_date, latest_date, vets, appointment_types, reason, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, status, created_at::text`,
+		e.PetID, e.EarliestDate, e.LatestDate, pq.Array(e.Vets), pq.Array(e.AppointmentTypes), e.Reason, username).
+		Scan(&e.ID, &e.Status, &e.CreatedAt)
+	if err != nil {
+		ErrorResponse(w, "Failed to add waitlist entry", http.StatusInternalServerError, err)
+		return
+	}
+	e.CreatedBy = username
+
+	utils.Log.WithFields(map[string]interface{}{"id": e.ID, "pet_id": e.PetID}).Info("Pet added to waitlist")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(e)
+}
+
+// GetWaitlist - staff see the whole waitlist, owners only their pets' entries
+func GetWaitlist(w http.ResponseWriter, r *http.Request) {
+	utils.Log.Debug("GET /waitlist called")
+
+	username, role, ok := getUserFromRequest(r)
+	if !ok {
+		ErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	query := `SELECT e.id, e.pet_id, e.earliest_date::text, e.latest_date::text, e.vets, e.appointment_types,
+		COALESCE(e.reason, ''), e.status, COALESCE(e.created_by, ''), e.created_at::text
+		FROM waitlist_entries e JOIN pets p ON p.id = e.pet_id`
+	var args []interface{}
+	if role == "owner" {
+		ownerID, valid := ownerIDFromUsername(username)
+		if !valid {
+			http.Error(w, "Invalid owner identity", http.StatusForbidden)
+			return
+		}
+		query += " WHERE " + ownedPetsCondition("p.id", 1)
+		args = append(args, ownerID)
+	}
+	query += " ORDER BY e.created_at, e.id"
+
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch waitlist", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	entries := []models.WaitlistEntry{}
+	for rows.Next() {
+		var e models.WaitlistEntry
+		if err := rows.Scan(&e.ID, &e.PetID, &e.EarliestDate, &e.LatestDate, pq.Array(&e.Vets), pq.Array(&e.AppointmentTypes),
+			&e.Reason, &e.Status, &e.CreatedBy, &e.CreatedAt); err != nil {
+			ErrorResponse(w, "Error scanning waitlist data", http.StatusInternalServerError, err)
+			return
+		}
+		entries = append(entries, e)
+	}
+
+	utils.Log.WithField("count", len(entries)).Info("Waitlist fetched successfully")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// CancelWaitlistEntry - remove a pet from the waitlist; a held slot is passed on
+func CancelWaitlistEntry(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	utils.Log.WithField("id", id).Debug("DELETE /waitlist/{id} called")
+
+	var petID int
+	err := db.DB.QueryRow("SELECT pet_id FROM waitlist_entries WHERE id=$1", id).Scan(&petID)
+	if err != nil {
+		ErrorResponse(w, "Waitlist entry not found", http.StatusNotFound, err)
+		return
+	}
+	if _, _, ok := authorizePetAccess(w, r, petID); !ok {
+		return
+	}
+
+	if _, err := db.DB.Exec(`UPDATE waitlist_entries SET status='cancelled' WHERE id=$1`, id); err != nil {
+		ErrorResponse(w, "Failed to cancel waitlist entry", http.StatusInternalServerError, err)
+		return
+	}
+
+	var s freedSlot
+	err = db.DB.QueryRow(`UPDATE waitlist_offers SET status='declined'
+		WHERE entry_id=$1 AND status='pending'
+		RETURNING date::text, time::text, COALESCE(vet, ''), COALESCE(appointment_type, '')`, id).
+		Scan(&s.Date, &s.Time, &s.Vet, &s.Type)
+	if err == nil {
+		offerFreedSlot(s)
+	} else if err != sql.ErrNoRows {
+		utils.Log.WithError(err).WithField("id", id).Error("Failed to release offer of cancelled waitlist entry")
+	}
+
+	utils.Log.WithField("id", id).Info("Waitlist entry cancelled")
+	w.Write([]byte("Waitlist entry cancelled"))
+}
+
+const waitlistOfferColumns = `o.id, o.entry_id, e.pet_id, o.date::text, o.time::text, COALESCE(o.vet, ''),
+	COALESCE(o.appointment_type, ''), o.status, o.expires_at::text, o.appointment_id`
+
+func scanWaitlistOffer(row interface{ Scan(...interface{}) error }, o *models.WaitlistOffer) error {
+	var apptID sql.NullInt64
+	err := row.Scan(&o.ID, &o.EntryID, &o.PetID, &o.Date, &o.Time, &o.Vet, &o.Type, &o.Status, &o.ExpiresAt, &apptID)
+	if apptID.Valid {
+		v := int(apptID.Int64)
+		o.AppointmentID = &v
+	}
+	return err
+}
+
+// GetWaitlistOffers - staff see all offers, owners only offers for their pets
+func GetWaitlistOffers(w http.ResponseWriter, r *http.Request) {
+	utils.Log.Debug("GET /waitlist/offers called")
+
+	username, role, ok := getUserFromRequest(r)
+	if !ok {
+		ErrorResponse(w, "Unauthorized", http.StatusUnauthorized, nil)
+		return
+	}
+
+	query := `SELECT ` + waitlistOfferColumns + `
+		FROM waitlist_offers o
+		JOIN waitlist_entries e ON e.id = o.entry_id
+		JOIN pets p ON p.id = e.pet_id`
+	var args []interface{}
+	if role == "owner" {
+		ownerID, valid := ownerIDFromUsername(username)
+		if !valid {
+			http.Error(w, "Invalid owner identity", http.StatusForbidden)
+			return
+		}
+		query += " WHERE " + ownedPetsCondition("p.id", 1)
+		args = append(args, ownerID)
+	}
+	query += " ORDER BY o.created_at DESC"
+
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		ErrorResponse(w, "Failed to fetch waitlist offers", http.StatusInternalServerError, err)
+		return
+	}
+	defer rows.Close()
+
+	offers := []models.WaitlistOffer{}
+	for rows.Next() {
+		var o models.WaitlistOffer
+		if err := scanWaitlistOffer(rows, &o); err != nil {
+			ErrorResponse(w, "Error scanning waitlist offer", http.StatusInternalServerError, err)
+			return
+		}
+		offers = append(offers, o)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(offers)
+}
+
+// loadPendingOffer fetches an offer and checks the caller may answer it.
+// Writes the error response itself and returns ok=false on failure.
+func loadPendingOffer(w http.ResponseWriter, r *http.Request, id string) (o models.WaitlistOffer, ok bool) {
+	row := db.DB.QueryRow(`SELECT `+waitlistOfferColumns+`
+		FROM waitlist_offers o JOIN waitlist_entries e ON e.id = o.entry_id
+		WHERE o.id = $1`, id)
+	if err := scanWaitlistOffer(row, &o); err != nil {
+		ErrorResponse(w, "Waitlist offer not found", http.StatusNotFound, err)
+		return o, false
+	}
+
+	if _, _, ok := authorizePetAccess(w, r, o.PetID); !ok {
+		return o, false
+	}
+
+	if o.Status != "pending" {
+		http.Error(w, "Offer is no longer pending", http.StatusConflict)
+		return o, false
+	}
+
+	var expired bool
+	if err := db.DB.QueryRow(`SELECT expires_at <= NOW() FROM waitlist_offers WHERE id=$1`, id).Scan(&expired); err != nil {
+		ErrorResponse(w, "Failed to check waitlist offer", http.StatusInternalServerError, err)
+		return o, false
+	}
+	if expired {
+		http.Error(w, "Offer has expired", http.StatusGone)
+		return o, false
+	}
+	return o, true
+}
+
+// AcceptWaitlistOffer - books the held slot for the waitlisted pet
+func AcceptWaitlistOffer(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	utils.Log.WithField("id", id).Debug("POST /waitlist/offers/{id}/accept called")
+
+	o, ok := loadPendingOffer(w, r, id)
+	if !ok {
+		return
+	}
+	// the pet's status or the clinic calendar may have changed since the offer was made
+	if !requireActivePet(w, o.PetID) {
+		return
+	}
+	if !checkSlotBookable(w, o.Date, o.Time) {
+		return
+	}
+
+	tx, err := db.DB.Begin()
+	if err != nil {
+		ErrorResponse(w, "Failed to accept offer", http.StatusInternalServerError, err)
+		return
+	}
+	defer tx.Rollback()
+
+	// claim the offer first so a concurrent expiry/decline cannot double-book it
+	result, err := tx.Exec(`UPDATE waitlist_offers SET status='accepted'
+		WHERE id=$1 AND status='pending' AND expires_at > NOW()`, id)
+	if err != nil {
+		ErrorResponse(w, "Failed to accept offer", http.StatusInternalServerError, err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Offer is no longer pending", http.StatusConflict)
+		return
+	}
+
+	var reason string
+	if err := tx.QueryRow(`SELECT COALESCE(reason, '') FROM waitlist_entries WHERE id=$1`, o.EntryID).Scan(&reason); err != nil {
+		ErrorResponse(w, "Failed to accept offer", http.StatusInternalServerError, err)
+		return
+	}
+
+	var apptID int
+	err = tx.QueryRow(`INSERT INTO appointments (date, time, pet_id, reason, vet, appointment_type)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		o.Date, o.Time, o.PetID, reason, o.Vet, o.Type).Scan(&apptID)
+	if err != nil {
+		ErrorResponse(w, "Failed to book offered slot", http.StatusInternalServerError, err)
+		return
+	}
+
+	if _, err := tx.Exec(`UPDATE waitlist_offers SET appointment_id=$1 WHERE id=$2`, apptID, id); err != nil {
+		ErrorResponse(w, "Failed to accept offer", http.StatusInternalServerError, err)
+		return
+	}
+	if _, err := tx.Exec(`UPDATE waitlist_entries SET status='fulfilled' WHERE id=$1`, o.EntryID); err != nil {
+		ErrorResponse(w, "Failed to accept offer", http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		ErrorResponse(w, "Failed to accept offer", http.StatusInternalServerError, err)
+		return
+	}
+
+	o.Status = "accepted"
+	o.AppointmentID = &apptID
+	go sendAppointmentInvite(apptID, "REQUEST")
+
+	utils.Log.WithFields(map[string]interface{}{"offer_id": o.ID, "appointment_id": apptID}).Info("Waitlist offer accepted")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(o)
+}
+
+// DeclineWaitlistOffer - gives the slot up; the entry keeps its place in the queue
+func DeclineWaitlistOffer(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	utils.Log.WithField("id", id).Debug("POST /waitlist/offers/{id}/decline called")
+
+	o, ok := loadPendingOffer(w, r, id)
+	if !ok {
+		return
+	}
+
+	result, err := db.DB.Exec(`UPDATE waitlist_offers SET status='declined' WHERE id=$1 AND status='pending'`, id)
+	if err != nil {
+		ErrorResponse(w, "Failed to decline offer", http.StatusInternalServerError, err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Offer is no longer pending", http.StatusConflict)
+		return
+	}
+
+	releaseOffer(o.EntryID, freedSlot{Date: o.Date, Time: o.Time, Vet: o.Vet, Type: o.Type})
+
+	utils.Log.WithField("offer_id", o.ID).Info("Waitlist offer declined")
+	w.Write([]byte("Waitlist offer declined"))
+}
@@ -0,0 +1,105 @@
+// Package ical writes iCalendar (RFC 5545) documents for appointments.
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	_ "time/tzdata" // the runtime image has no zoneinfo
+)
+
+const stampFormat = "20060102T150405Z"
+
+// Event is a single VEVENT; Start and End may be in any location, they are written in UTC
+type Event struct {
+	UID          string
+	Start        time.Time
+	End          time.Time
+	Summary      string
+	Description  string
+	Location     string
+	Status       string // CONFIRMED or CANCELLED
+	Sequence     int
+	LastModified time.Time
+}
+
+// Calendar is a VCALENDAR. Method is PUBLISH for feeds, REQUEST/CANCEL for invites.
+type Calendar struct {
+	Name   string
+	Method string
+	Events []Event
+}
+
+// Write serialises the calendar with CRLF line endings and 75-octet line folding
+func (c *Calendar) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	line := func(name, value string) {
+		writeFolded(bw, name+":"+value)
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//Pet Clinic//Appointments//EN")
+	line("CALSCALE", "GREGORIAN")
+	if c.Method != "" {
+		line("METHOD", c.Method)
+	}
+	if c.Name != "" {
+		line("X-WR-CALNAME", escapeText(c.Name))
+	}
+
+	now := time.Now().UTC().Format(stampFormat)
+	for _, e := range c.Events {
+		line("BEGIN", "VEVENT")
+		line("UID", e.UID)
+		line("DTSTAMP", now)
+		line("DTSTART", e.Start.UTC().Format(stampFormat))
+		line("DTEND", e.End.UTC().Format(stampFormat))
+		line("SUMMARY", escapeText(e.Summary))
+		if e.Description != "" {
+			line("DESCRIPTION", escapeText(e.Description))
+		}
+		if e.Location != "" {
+			line("LOCATION", escapeText(e.Location))
+		}
+		status := e.Status
+		if status == "" {
+			status = "CONFIRMED"
+		}
+		line("STATUS", status)
+		line("SEQUENCE", fmt.Sprint(e.Sequence))
+		if !e.LastModified.IsZero() {
+			line("LAST-MODIFIED", e.LastModified.UTC().Format(stampFormat))
+		}
+		line("END", "VEVENT")
+	}
+
+	line("END", "VCALENDAR")
+	return bw.Flush()
+}
+
+// escapeText escapes a TEXT value (RFC 5545 section 3.3.11)
+func escapeText(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+	return r.Replace(s)
+}
+
+// writeFolded writes one content line, folding it at 75 octets without splitting UTF-8 sequences
+func writeFolded(w *bufio.Writer, l string) {
+	limit := 75
+	for len(l) > limit {
+		cut := limit
+		for cut > 0 && l[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		w.WriteString(l[:cut])
+		w.WriteString("\r\n ")
+		l = l[cut:]
+		limit = 74 // continuation lines start with a space
+	}
+	w.WriteString(l)
+	w.WriteString("\r\n")
+}
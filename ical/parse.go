@@ -0,0 +1,192 @@
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ParsedEvent is a VEVENT read from an imported calendar.
+// End is exclusive; all-day events span whole days in the given location.
+type ParsedEvent struct {
+	UID     string
+	Summary string
+	Start   time.Time
+	End     time.Time
+	AllDay  bool
+}
+
+type contentLine struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// Parse reads the VEVENTs of an iCalendar stream. Floating and all-day times
+// are interpreted in loc. Recurrence rules are not expanded.
+func Parse(r io.Reader, loc *time.Location) ([]ParsedEvent, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []ParsedEvent
+	var cur *ParsedEvent
+	var duration time.Duration
+	for n, raw := range lines {
+		l, err := parseLine(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+
+		switch {
+		case l.name == "BEGIN" && strings.EqualFold(l.value, "VEVENT"):
+			cur = &ParsedEvent{}
+			duration = 0
+		case l.name == "END" && strings.EqualFold(l.value, "VEVENT") && cur != nil:
+			if cur.Start.IsZero() {
+				return nil, fmt.Errorf("line %d: VEVENT without DTSTART", n+1)
+			}
+			if cur.End.IsZero() {
+				switch {
+				case duration > 0:
+					cur.End = cur.Start.Add(duration)
+				case cur.AllDay:
+					cur.End = cur.Start.AddDate(0, 0, 1)
+				default:
+					cur.End = cur.Start
+				}
+			}
+			events = append(events, *cur)
+			cur = nil
+		case cur == nil:
+			// properties outside VEVENT (calendar or VTIMEZONE) are not needed
+		case l.name == "UID":
+			cur.UID = l.value
+		case l.name == "SUMMARY":
+			cur.Summary = unescapeText(l.value)
+		case l.name == "DTSTART":
+			cur.Start, cur.AllDay, err = parseDateTime(l, loc)
+		case l.name == "DTEND":
+			cur.End, _, err = parseDateTime(l, loc)
+		case l.name == "DURATION":
+			duration, err = parseDuration(l.value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n+1, err)
+		}
+	}
+	return events, nil
+}
+
+// unfold joins continuation lines (RFC 5545 section 3.1)
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		l := strings.TrimRight(sc.Text(), "\r")
+		if (strings.HasPrefix(l, " ") || strings.HasPrefix(l, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += l[1:]
+			continue
+		}
+		if l != "" {
+			lines = append(lines, l)
+		}
+	}
+	return lines, sc.Err()
+}
+
+func parseLine(raw string) (contentLine, error) {
+	l := contentLine{params: map[string]string{}}
+
+	// the value starts at the first colon outside a quoted parameter value
+	inQuote, colon := false, -1
+	for i, c := range raw {
+		if c == '"' {
+			inQuote = !inQuote
+		} else if c == ':' && !inQuote {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return l, fmt.Errorf("malformed content line %q", raw)
+	}
+	l.value = raw[colon+1:]
+
+	parts := strings.Split(raw[:colon], ";")
+	l.name = strings.ToUpper(parts[0])
+	for _, p := range parts[1:] {
+		if k, v, ok := strings.Cut(p, "="); ok {
+			l.params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+		}
+	}
+	return l, nil
+}
+
+func parseDateTime(l contentLine, loc *time.Location) (t time.Time, allDay bool, err error) {
+	v := l.value
+	if l.params["VALUE"] == "DATE" || len(v) == 8 {
+		t, err = time.ParseInLocation("20060102", v, loc)
+		return t, true, err
+	}
+	if strings.HasSuffix(v, "Z") {
+		t, err = time.Parse("20060102T150405Z", v)
+		return t, false, err
+	}
+	if tzid := l.params["TZID"]; tzid != "" {
+		if tz, lerr := time.LoadLocation(tzid); lerr == nil {
+			loc = tz
+		}
+	}
+	t, err = time.ParseInLocation("20060102T150405", v, loc)
+	return t, false, err
+}
+
+// parseDuration handles the dur-value forms used by calendars, e.g. P1D, PT2H30M, P1W
+func parseDuration(v string) (time.Duration, error) {
+	neg := strings.HasPrefix(v, "-")
+	v = strings.TrimLeft(v, "+-")
+	if !strings.HasPrefix(v, "P") {
+		return 0, fmt.Errorf("invalid duration %q", v)
+	}
+
+	var d time.Duration
+	num := 0
+	for _, c := range v[1:] {
+		switch {
+		case c >= '0' && c <= '9':
+			num = num*10 + int(c-'0')
+		case c == 'T':
+		case c == 'W':
+			d += time.Duration(num) * 7 * 24 * time.Hour
+			num = 0
+		case c == 'D':
+			d += time.Duration(num) * 24 * time.Hour
+			num = 0
+		case c == 'H':
+			d += time.Duration(num) * time.Hour
+			num = 0
+		case c == 'M':
+			d += time.Duration(num) * time.Minute
+			num = 0
+		case c == 'S':
+			d += time.Duration(num) * time.Second
+			num = 0
+		default:
+			return 0, fmt.Errorf("invalid duration %q", v)
+		}
+	}
+	if neg {
+		d = -d
+	}
+	return d, nil
+}
+
+func unescapeText(s string) string {
+	r := strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+	return r.Replace(s)
+}
@@ -0,0 +1,42 @@
+// Package identifiers validates and normalizes the identifiers a pet can carry:
+// ISO 11784/11785 microchips, tattoos, license tags and rabies tags.
+package identifiers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Kinds lists the supported identifier kinds
+var Kinds = []string{"microchip", "tattoo", "license_tag", "rabies_tag"}
+
+var tagPattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9-]{1,29}$`)
+
+// Normalize validates value for kind and returns the canonical form stored and
+// searched on: microchips as 15 digits, everything else upper case without spaces.
+func Normalize(kind, value string) (string, error) {
+	switch kind {
+	case "microchip":
+		return NormalizeMicrochip(value)
+	case "tattoo", "license_tag", "rabies_tag":
+		v := strings.ToUpper(strings.Join(strings.Fields(value), ""))
+		if !tagPattern.MatchString(v) {
+			return "", fmt.Errorf("%s must be 2-30 letters, digits or dashes", kind)
+		}
+		return v, nil
+	}
+	return "", fmt.Errorf("unknown identifier kind %q", kind)
+}
+
+// Candidates returns the normalized forms a free-text search value may take,
+// so a lookup works without knowing the identifier kind
+func Candidates(value string) map[string]string {
+	found := map[string]string{}
+	for _, kind := range Kinds {
+		if v, err := Normalize(kind, value); err == nil {
+			found[kind] = v
+		}
+	}
+	return found
+}
@@ -0,0 +1,93 @@
+package identifiers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxNationalID = 1<<38 - 1 // 38-bit identification code
+	testCountry   = 999       // reserved for test transponders
+)
+
+// NormalizeMicrochip accepts an ISO 11784/11785 (FDX-B) transponder code in any of
+// the forms readers print and returns the 15-digit decimal code:
+//
+//	985141000123456 / 985 141 000 123 456   3-digit country or manufacturer code + 12-digit id
+//	3D9.20BA9A53C0                          country and id in hex, as some readers show them
+//	<16 hex digits><4 hex digits>           raw 64-bit identification block with its CRC-16
+func NormalizeMicrochip(value string) (string, error) {
+	v := strings.ToUpper(strings.NewReplacer(" ", "", "-", "", "\t", "").Replace(strings.TrimSpace(value)))
+
+	var country, id uint64
+	switch digits := strings.ReplaceAll(v, ".", ""); {
+	case len(digits) == 15 && isDigits(digits):
+		country, _ = strconv.ParseUint(digits[:3], 10, 64)
+		id, _ = strconv.ParseUint(digits[3:], 10, 64)
+	case strings.Count(v, ".") == 1 && len(v) == 14 && strings.Index(v, ".") == 3:
+		var err1, err2 error
+		country, err1 = strconv.ParseUint(v[:3], 16, 64)
+		id, err2 = strconv.ParseUint(v[4:], 16, 64)
+		if err1 != nil || err2 != nil {
+			return "", fmt.Errorf("microchip hex form must be CCC.IIIIIIIIII")
+		}
+	case len(v) == 20 && isHex(v):
+		block, _ := strconv.ParseUint(v[:16], 16, 64)
+		crc, _ := strconv.ParseUint(v[16:], 16, 16)
+		if !validBlockCRC(block, uint16(crc)) {
+			return "", fmt.Errorf("microchip checksum does not match")
+		}
+		id = block & maxNationalID
+		country = (block >> 38) & 0x3FF
+	default:
+		return "", fmt.Errorf("microchip must be 15 digits (ISO 11784/11785)")
+	}
+
+	if country == 0 || country == testCountry || country > 999 {
+		return "", fmt.Errorf("microchip country/manufacturer code %03d is not valid", country)
+	}
+	if id > maxNationalID {
+		return "", fmt.Errorf("microchip identification code is out of range")
+	}
+	return fmt.Sprintf("%03d%012d", country, id), nil
+}
+
+// validBlockCRC checks the CRC-16/CCITT (Kermit) that FDX-B transmits after the
+// identification block; the block goes over the air least significant byte first
+func validBlockCRC(block uint64, crc uint16) bool {
+	var data [8]byte
+	binary.LittleEndian.PutUint64(data[:], block)
+
+	var sum uint16
+	for _, b := range data {
+		sum ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if sum&1 != 0 {
+				sum = sum>>1 ^ 0x8408
+			} else {
+				sum >>= 1
+			}
+		}
+	}
+	return sum == crc
+}
+
+func isDigits(v string) bool {
+	for _, c := range v {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return v != ""
+}
+
+func isHex(v string) bool {
+	for _, c := range v {
+		if (c < '0' || c > '9') && (c < 'A' || c > 'F') {
+			return false
+		}
+	}
+	return v != ""
+}
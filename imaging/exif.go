@@ -0,0 +1,121 @@
+package imaging
+
+import "encoding/binary"
+
+const (
+	tagOrientation = 0x0112
+	tagGPSIFD      = 0x8825
+)
+
+// tiffTypeSize is the byte size of one value of each TIFF field type
+var tiffTypeSize = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// tiffOrder returns the byte order of an EXIF TIFF block ("II*\0" or "MM\0*")
+func tiffOrder(tiff []byte) (binary.ByteOrder, bool) {
+	if len(tiff) < 8 {
+		return nil, false
+	}
+	switch string(tiff[:4]) {
+	case "II*\x00":
+		return binary.LittleEndian, true
+	case "MM\x00*":
+		return binary.BigEndian, true
+	}
+	return nil, false
+}
+
+// ifdEntry finds tag in the IFD at off and returns the offset of its 12-byte entry
+func ifdEntry(tiff []byte, order binary.ByteOrder, off uint32, tag uint16) (uint32, bool) {
+	if uint64(off)+2 > uint64(len(tiff)) {
+		return 0, false
+	}
+	n := uint32(order.Uint16(tiff[off:]))
+	for i := uint32(0); i < n; i++ {
+		e := off + 2 + i*12
+		if uint64(e)+12 > uint64(len(tiff)) {
+			return 0, false
+		}
+		if order.Uint16(tiff[e:]) == tag {
+			return e, true
+		}
+	}
+	return 0, false
+}
+
+// zeroGPS erases the GPS IFD of an EXIF TIFF block in place: every GPS value is
+// overwritten with zeros and the IFD is left with no entries. The block keeps
+// its size, so nothing around it has to move.
+func zeroGPS(tiff []byte) bool {
+	order, ok := tiffOrder(tiff)
+	if !ok {
+		return false
+	}
+	e, ok := ifdEntry(tiff, order, order.Uint32(tiff[4:]), tagGPSIFD)
+	if !ok {
+		return false
+	}
+	gps := order.Uint32(tiff[e+8:])
+	if uint64(gps)+2 > uint64(len(tiff)) {
+		return false
+	}
+	n := uint32(order.Uint16(tiff[gps:]))
+	for i := uint32(0); i < n; i++ {
+		entry := gps + 2 + i*12
+		if uint64(entry)+12 > uint64(len(tiff)) {
+			break
+		}
+		size := uint64(tiffTypeSize[order.Uint16(tiff[entry+2:])]) * uint64(order.Uint32(tiff[entry+4:]))
+		if size > 4 {
+			// value stored out of line
+			start := uint64(order.Uint32(tiff[entry+8:]))
+			if start+size <= uint64(len(tiff)) {
+				clear(tiff[start : start+size])
+			}
+		}
+		clear(tiff[entry : entry+12])
+	}
+	order.PutUint16(tiff[gps:], 0)
+	return true
+}
+
+// orientation reads the EXIF orientation (1-8) from IFD0, 1 when absent
+func orientation(tiff []byte) int {
+	order, ok := tiffOrder(tiff)
+	if !ok {
+		return 1
+	}
+	e, ok := ifdEntry(tiff, order, order.Uint32(tiff[4:]), tagOrientation)
+	if !ok {
+		return 1
+	}
+	if v := int(order.Uint16(tiff[e+8:])); v >= 1 && v <= 8 {
+		return v
+	}
+	return 1
+}
+
+// jpegEXIF returns the TIFF block of the first Exif APP1 segment in a JPEG
+func jpegEXIF(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return nil
+		}
+		payload := data[i+4 : i+2+length]
+		if marker == 0xE1 && len(payload) > 6 && string(payload[:6]) == "Exif\x00\x00" {
+			return payload[6:]
+		}
+		i += 2 + length
+	}
+	return nil
+}
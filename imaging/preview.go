@@ -0,0 +1,129 @@
+// Package imaging makes thumbnails and previews of uploaded photos and PDFs and
+// strips location metadata from uploaded images.
+package imaging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png" // registers the PNG decoder
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// Variant is one generated size; images are scaled to fit MaxEdge on their longer side
+type Variant struct {
+	Name    string
+	MaxEdge int
+}
+
+// Variants lists the sizes made for every supported upload
+var Variants = []Variant{{Name: "thumb", MaxEdge: 256}, {Name: "preview", MaxEdge: 1024}}
+
+// maxPixels refuses images whose header claims more pixels than this
+// (decompression bombs), before anything is decoded
+const maxPixels = 60_000_000
+
+// ErrUnsupported is returned for content types previews cannot be made of
+var ErrUnsupported = errors.New("no preview for this file type")
+
+// pdfRenderer returns the pdftoppm binary (PDF_RENDERER overrides the name),
+// or "" when it is not installed
+func pdfRenderer() string {
+	name := os.Getenv("PDF_RENDERER")
+	if name == "" {
+		name = "pdftoppm"
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// Supported reports whether previews can be made for contentType
+func Supported(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png":
+		return true
+	case "application/pdf":
+		return pdfRenderer() != ""
+	}
+	return false
+}
+
+// Render returns a JPEG per variant name. Photos are turned upright according
+// to their EXIF orientation; the output is re-encoded and carries no metadata.
+func Render(ctx context.Context, data []byte, contentType string) (map[string][]byte, error) {
+	orientationTag := 1
+	switch contentType {
+	case "image/jpeg":
+		orientationTag = orientation(jpegEXIF(data))
+	case "image/png":
+	case "application/pdf":
+		page, err := renderPDFPage(ctx, data)
+		if err != nil {
+			return nil, err
+		}
+		data = page
+	default:
+		return nil, ErrUnsupported
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, fmt.Errorf("image is %dx%d, too large to preview", cfg.Width, cfg.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	src := flatten(img)
+	out := map[string][]byte{}
+	for _, v := range Variants {
+		scaled := orient(fit(src, v.MaxEdge), orientationTag)
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, err
+		}
+		out[v.Name] = buf.Bytes()
+	}
+	return out, nil
+}
+
+// renderPDFPage renders the first page of a PDF to PNG with pdftoppm (poppler-utils)
+func renderPDFPage(ctx context.Context, data []byte) ([]byte, error) {
+	bin := pdfRenderer()
+	if bin == "" {
+		return nil, ErrUnsupported
+	}
+	dir, err := os.MkdirTemp("", "pdf-preview-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	in := filepath.Join(dir, "in.pdf")
+	if err := os.WriteFile(in, data, 0o600); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	largest := Variants[len(Variants)-1].MaxEdge
+	cmd := exec.CommandContext(ctx, bin, "-f", "1", "-l", "1", "-singlefile", "-png",
+		"-scale-to", fmt.Sprint(largest), in, filepath.Join(dir, "page"))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("pdftoppm: %v: %s", err, bytes.TrimSpace(output))
+	}
+	return os.ReadFile(filepath.Join(dir, "page.png"))
+}
@@ -0,0 +1,201 @@
+package imaging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	exifHeader   = "Exif\x00\x00"
+	xmpHeader    = "http://ns.adobe.com/xap/1.0/\x00"
+	xmpExtHeader = "http://ns.adobe.com/xmp/extension/\x00"
+	xmpKeyword   = "XML:com.adobe.xmp\x00"
+)
+
+// StripLocation returns a reader yielding r with location metadata removed:
+// the EXIF GPS block is zeroed and XMP packets (which can repeat the GPS
+// position) are blanked with spaces. Nothing else changes and the output has
+// exactly the input's size, so a size announced for the upload still holds.
+// Other content types are returned unchanged. Close the result when done.
+func StripLocation(r io.Reader, contentType string) io.ReadCloser {
+	var strip func(*bufio.Reader, io.Writer) error
+	switch contentType {
+	case "image/jpeg":
+		strip = stripJPEG
+	case "image/png":
+		strip = stripPNG
+	default:
+		return io.NopCloser(r)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		br := bufio.NewReader(r)
+		err := strip(br, pw)
+		if err == nil {
+			// anything after the metadata, or a file we could not follow, passes through
+			_, err = io.Copy(pw, br)
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// readSome reads up to n bytes; running out of input is not an error here,
+// the caller writes what it got and lets the rest pass through
+func readSome(br *bufio.Reader, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	got, err := io.ReadFull(br, buf)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = nil
+	}
+	return buf[:got], err
+}
+
+func stripJPEG(br *bufio.Reader, w io.Writer) error {
+	soi, err := readSome(br, 2)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(soi); err != nil || !bytes.Equal(soi, []byte{0xFF, 0xD8}) {
+		return err
+	}
+	for {
+		hdr, err := readSome(br, 2)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(hdr); err != nil {
+			return err
+		}
+		if len(hdr) < 2 || hdr[0] != 0xFF {
+			return nil
+		}
+		switch marker := hdr[1]; {
+		case marker == 0x01 || marker >= 0xD0 && marker <= 0xD7:
+			continue // no payload
+		case marker == 0xDA || marker == 0xD9 || marker == 0xFF:
+			return nil // entropy-coded data (or padding we do not follow) from here on
+		}
+
+		lb, err := readSome(br, 2)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(lb); err != nil || len(lb) < 2 {
+			return err
+		}
+		length := int(binary.BigEndian.Uint16(lb)) - 2
+		if length < 0 {
+			return nil
+		}
+		if hdr[1] != 0xE1 {
+			if _, err := io.CopyN(w, br, int64(length)); err != nil && err != io.EOF {
+				return err
+			}
+			continue
+		}
+
+		payload, err := readSome(br, length)
+		if err != nil {
+			return err
+		}
+		switch {
+		case bytes.HasPrefix(payload, []byte(exifHeader)):
+			zeroGPS(payload[len(exifHeader):])
+		case bytes.HasPrefix(payload, []byte(xmpHeader)):
+			blank(payload[len(xmpHeader):])
+		case bytes.HasPrefix(payload, []byte(xmpExtHeader)):
+			blank(payload[len(xmpExtHeader):])
+		}
+		if _, err := w.Write(payload); err != nil {
+			return err
+		}
+	}
+}
+
+// maxMetadataChunk bounds how much of a PNG metadata chunk is held in memory
+const maxMetadataChunk = 16 << 20
+
+func stripPNG(br *bufio.Reader, w io.Writer) error {
+	sig, err := readSome(br, 8)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(sig); err != nil || string(sig) != "\x89PNG\r\n\x1a\n" {
+		return err
+	}
+	for {
+		hdr, err := readSome(br, 8)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(hdr); err != nil || len(hdr) < 8 {
+			return err
+		}
+		length := int64(binary.BigEndian.Uint32(hdr))
+		kind := string(hdr[4:8])
+
+		if (kind != "eXIf" && kind != "iTXt") || length > maxMetadataChunk {
+			if _, err := io.CopyN(w, br, length+4); err != nil && err != io.EOF {
+				return err
+			}
+			if kind == "IEND" {
+				return nil
+			}
+			continue
+		}
+
+		chunk, err := readSome(br, int(length)+4)
+		if err != nil {
+			return err
+		}
+		if len(chunk) == int(length)+4 {
+			data := chunk[:length]
+			changed := false
+			switch {
+			case kind == "eXIf":
+				changed = zeroGPS(data)
+			case bytes.HasPrefix(data, []byte(xmpKeyword)):
+				changed = blankITXt(data[len(xmpKeyword):])
+			}
+			if changed {
+				crc := crc32.NewIEEE()
+				crc.Write(hdr[4:8])
+				crc.Write(data)
+				binary.BigEndian.PutUint32(chunk[length:], crc.Sum32())
+			}
+		}
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+	}
+}
+
+// blankITXt blanks the text of an uncompressed iTXt chunk, given the bytes
+// after its keyword: compression flag, method, language\0, translated keyword\0, text
+func blankITXt(rest []byte) bool {
+	if len(rest) < 2 || rest[0] != 0 {
+		return false // compressed text cannot be blanked in place
+	}
+	rest = rest[2:]
+	for i := 0; i < 2; i++ {
+		end := bytes.IndexByte(rest, 0)
+		if end < 0 {
+			return false
+		}
+		rest = rest[end+1:]
+	}
+	blank(rest)
+	return true
+}
+
+func blank(b []byte) {
+	for i := range b {
+		b[i] = ' '
+	}
+}
@@ -0,0 +1,100 @@
+package labs
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// csvColumns maps accepted header names to canonical ones
+var csvColumns = map[string]string{
+	"accession": "accession", "order": "accession", "order_id": "accession", "sample_id": "accession",
+	"pet_id": "patient", "patient_id": "patient", "patient": "patient",
+	"code": "code", "test_code": "code", "analyte_code": "code",
+	"name": "name", "test": "name", "analyte": "name",
+	"value": "value", "result": "value",
+	"units": "units", "unit": "units",
+	"ref_low": "ref_low", "low": "ref_low",
+	"ref_high": "ref_high", "high": "ref_high",
+	"ref_range": "ref_range", "range": "ref_range", "reference_range": "ref_range",
+	"flag": "flag", "abnormal_flag": "flag",
+}
+
+// ParseCSV reads a header row followed by one analyte per row. Rows are grouped
+// into reports by accession (or by patient when the accession is blank).
+func ParseCSV(r io.Reader) ([]Report, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	index := map[string]int{}
+	for i, h := range header {
+		key := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(h), " ", "_"))
+		if canonical, ok := csvColumns[key]; ok {
+			index[canonical] = i
+		}
+	}
+	if _, ok := index["value"]; !ok {
+		return nil, fmt.Errorf("missing value column")
+	}
+	if _, ok := index["accession"]; !ok {
+		if _, ok := index["patient"]; !ok {
+			return nil, fmt.Errorf("need an accession or pet_id column")
+		}
+	}
+
+	get := func(row []string, col string) string {
+		if i, ok := index[col]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	var reports []Report
+	byKey := map[string]int{}
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		a := Analyte{
+			Code:  get(row, "code"),
+			Name:  get(row, "name"),
+			Value: get(row, "value"),
+			Units: get(row, "units"),
+			Flag:  get(row, "flag"),
+		}
+		if rng := get(row, "ref_range"); rng != "" {
+			a.RefLow, a.RefHigh = parseRange(rng)
+		} else {
+			a.RefLow, a.RefHigh = parseNumber(get(row, "ref_low")), parseNumber(get(row, "ref_high"))
+		}
+		if err := a.validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		a.finish()
+
+		accession, patient := get(row, "accession"), get(row, "patient")
+		key := accession + "\x00" + patient
+		i, ok := byKey[key]
+		if !ok {
+			i = len(reports)
+			byKey[key] = i
+			reports = append(reports, Report{Accession: accession, PatientID: patient})
+		}
+		reports[i].Analytes = append(reports[i].Analytes, a)
+	}
+	if len(reports) == 0 {
+		return nil, fmt.Errorf("no result rows")
+	}
+	return reports, nil
+}
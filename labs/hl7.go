@@ -0,0 +1,97 @@
+package labs
+
+import (
+	"fmt"
+	"strings"
+)
+
+// hl7Delimiters are the separators declared in MSH-1 and MSH-2
+type hl7Delimiters struct {
+	field     string
+	component string
+}
+
+// hl7Unescape resolves the standard escapes, e.g. units sent as 10\S\9/L
+var hl7Unescape = strings.NewReplacer(`\F\`, "|", `\S\`, "^", `\T\`, "&", `\R\`, "~", `\E\`, `\`)
+
+// ParseHL7 reads ORU^R01 messages. Each OBR starts a report; the OBX segments
+// that follow it are its analytes. PID-3 is the pet id and OBR-2 (placer order
+// number) is our accession, falling back to OBR-3 (filler order number).
+func ParseHL7(data []byte) ([]Report, error) {
+	text := strings.ReplaceAll(string(data), "\r\n", "\r")
+	text = strings.ReplaceAll(text, "\n", "\r")
+
+	var (
+		d       hl7Delimiters
+		patient string
+		reports []Report
+		current *Report
+	)
+	for n, segment := range strings.Split(text, "\r") {
+		segment = strings.TrimSpace(segment)
+		if len(segment) < 3 {
+			continue
+		}
+
+		if strings.HasPrefix(segment, "MSH") {
+			if len(segment) < 5 {
+				return nil, fmt.Errorf("segment %d: truncated MSH", n+1)
+			}
+			d = hl7Delimiters{field: segment[3:4], component: segment[4:5]}
+			patient, current = "", nil
+			continue
+		}
+		if d.field == "" {
+			return nil, fmt.Errorf("segment %d: data before MSH", n+1)
+		}
+
+		fields := strings.Split(segment, d.field)
+		field := func(i int) string {
+			if i < len(fields) {
+				return fields[i]
+			}
+			return ""
+		}
+		component := func(v string, i int) string {
+			parts := strings.Split(v, d.component)
+			if i < len(parts) {
+				return hl7Unescape.Replace(strings.TrimSpace(parts[i]))
+			}
+			return ""
+		}
+
+		switch fields[0] {
+		case "PID":
+			patient = component(field(3), 0)
+		case "OBR":
+			accession := component(field(2), 0)
+			if accession == "" {
+				accession = component(field(3), 0)
+			}
+			reports = append(reports, Report{Accession: accession, PatientID: patient})
+			current = &reports[len(reports)-1]
+		case "OBX":
+			if current == nil {
+				return nil, fmt.Errorf("segment %d: OBX before OBR", n+1)
+			}
+			a := Analyte{
+				Code:  component(field(3), 0),
+				Name:  component(field(3), 1),
+				Value: component(field(5), 0),
+				Units: component(field(6), 0),
+				Flag:  component(field(8), 0),
+			}
+			a.RefLow, a.RefHigh = parseRange(field(7))
+			if err := a.validate(); err != nil {
+				return nil, fmt.Errorf("segment %d: %w", n+1, err)
+			}
+			a.finish()
+			current.Analytes = append(current.Analytes, a)
+		}
+	}
+
+	if len(reports) == 0 {
+		return nil, fmt.Errorf("no OBR segments found")
+	}
+	return reports, nil
+}
@@ -0,0 +1,108 @@
+// Package labs reads result files produced by the in-house blood analyzer.
+// Both HL7 v2 ORU^R01 messages and the analyzer's CSV export are supported.
+package labs
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Report is the set of results for one order (one OBR segment, or one accession in a CSV).
+type Report struct {
+	Accession string // our order number, echoed back by the analyzer
+	PatientID string // pet id as entered on the analyzer
+	Analytes  []Analyte
+}
+
+// Analyte is one measured value. Numeric is nil for text results such as "POS".
+type Analyte struct {
+	Code    string
+	Name    string
+	Value   string
+	Numeric *float64
+	Units   string
+	RefLow  *float64
+	RefHigh *float64
+	Flag    string // analyzer flag if sent, otherwise computed from the range
+}
+
+// Parse detects the file format from its content and returns the reports it contains.
+func Parse(data []byte) ([]Report, string, error) {
+	trimmed := bytes.TrimLeft(data, "\ufeff \t\r\n")
+	if bytes.HasPrefix(trimmed, []byte("MSH")) {
+		reports, err := ParseHL7(trimmed)
+		return reports, "hl7", err
+	}
+	reports, err := ParseCSV(bytes.NewReader(trimmed))
+	return reports, "csv", err
+}
+
+// parseRange reads reference ranges such as "5.5-16.9", "<10", ">2" and "0.5 - 1.8"
+func parseRange(v string) (low, high *float64) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(v, "<") {
+		return nil, parseNumber(strings.TrimLeft(v, "<="))
+	}
+	if strings.HasPrefix(v, ">") {
+		return parseNumber(strings.TrimLeft(v, ">=")), nil
+	}
+	// skip a leading minus so negative lower bounds still split correctly
+	if i := strings.Index(v[1:], "-"); i >= 0 {
+		return parseNumber(v[:i+1]), parseNumber(v[i+2:])
+	}
+	return nil, nil
+}
+
+func parseNumber(v string) *float64 {
+	f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	if err != nil {
+		return nil
+	}
+	return &f
+}
+
+// normalizeFlag maps analyzer flags to L, H, LL, HH or A (abnormal); "" and N mean normal
+func normalizeFlag(v string) string {
+	switch f := strings.ToUpper(strings.TrimSpace(v)); f {
+	case "", "N":
+		return ""
+	case "L", "H", "LL", "HH", "A":
+		return f
+	case "<", "LOW":
+		return "L"
+	case ">", "HIGH":
+		return "H"
+	case "AA", "ABN", "ABNORMAL":
+		return "A"
+	default:
+		return f
+	}
+}
+
+// finish fills in numeric values and computes a flag when the analyzer sent none
+func (a *Analyte) finish() {
+	a.Value = strings.TrimSpace(a.Value)
+	a.Numeric = parseNumber(a.Value)
+	a.Flag = normalizeFlag(a.Flag)
+	if a.Flag != "" || a.Numeric == nil {
+		return
+	}
+	switch {
+	case a.RefLow != nil && *a.Numeric < *a.RefLow:
+		a.Flag = "L"
+	case a.RefHigh != nil && *a.Numeric > *a.RefHigh:
+		a.Flag = "H"
+	}
+}
+
+func (a Analyte) validate() error {
+	if a.Code == "" && a.Name == "" {
+		return fmt.Errorf("analyte without code or name")
+	}
+	return nil
+}
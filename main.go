@@ -3,10 +3,15 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"os"
+	"time"
 
 	"pet-clinic/auth"
+	"pet-clinic/catalog"
 	"pet-clinic/db"
 	"pet-clinic/handlers"
+	"pet-clinic/maintenance"
+	"pet-clinic/reminders"
 	"pet-clinic/utils"
 
 	"github.com/gorilla/mux"
@@ -18,6 +23,24 @@ func main() {
 
 	db.Connect()
 
+	if err := catalog.SeedIfEmpty(); err != nil {
+		utils.Log.WithError(err).Error("Failed to seed species/breed catalog")
+	}
+
+	// One-off maintenance commands: ./petclinic <command> [args]
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
+	// Background jobs
+	go handlers.RunWaitlistExpiry(time.Minute)
+	go reminders.NewSchedulerFromEnv().Run(time.Minute)
+	go handlers.RunLabInbox(30 * time.Second)
+	go handlers.RunUploadCleanup(15 * time.Minute)
+	go handlers.RunPreviewWorker(10 * time.Second)
+	go handlers.RunArchiveWorker(30 * time.Second)
+
 	r := mux.NewRouter()
 
 	// Homepage route
@@ -29,6 +52,17 @@ func main() {
 	// Public route for login
 	r.HandleFunc("/login", handlers.Login).Methods("POST")
 
+	// Public calendar feeds (the secret token is the credential)
+	r.HandleFunc("/calendar/{token:[A-Za-z0-9_-]+}.ics", handlers.CalendarFeed).Methods("GET")
+
+	// Shared download links (the signature is the credential)
+	r.HandleFunc("/files/{id:[0-9]+}", handlers.SharedDownload).Methods("GET")
+	r.HandleFunc("/archives/{id:[0-9]+}", handlers.ArchiveDownload).Methods("GET", "HEAD")
+
+	// Waiting room board (guarded by TRIAGE_BOARD_KEY)
+	r.HandleFunc("/triage/board", handlers.GetTriageBoard).Methods("GET")
+	r.HandleFunc("/triage/board/stream", handlers.StreamTriageBoard).Methods("GET")
+
 	// Protected routes
 	api := r.PathPrefix("/api").Subrouter()
 	api.Use(auth.JWTMiddleware)
@@ -42,18 +76,143 @@ func main() {
 	// Pet routes (Update/Delete now check role/ownership)
 	api.HandleFunc("/pets", handlers.AddPet).Methods("POST")
 	api.HandleFunc("/pets", handlers.GetPets).Methods("GET")
+	api.HandleFunc("/pets/{id}", handlers.GetPet).Methods("GET")
 	api.HandleFunc("/pets/{id}", handlers.UpdatePet).Methods("PUT")
 	api.HandleFunc("/pets/{id}", handlers.DeletePet).Methods("DELETE")
+	api.HandleFunc("/pets/{id}/status", handlers.SetPetStatus).Methods("PUT")
+	api.HandleFunc("/pets/{id}/status-history", handlers.GetPetStatusHistory).Methods("GET")
+
+	// Species and breed catalog
+	api.HandleFunc("/catalog", handlers.GetCatalog).Methods("GET")
+	api.HandleFunc("/catalog", handlers.AddCatalogEntry).Methods("POST")
+	api.HandleFunc("/catalog/suggest", handlers.SuggestCatalog).Methods("GET")
+	api.HandleFunc("/catalog/aliases", handlers.DeleteCatalogAlias).Methods("DELETE")
+
+	// Co-owners and ownership transfers
+	api.HandleFunc("/pets/{id}/owners", handlers.GetPetOwners).Methods("GET")
+	api.HandleFunc("/pets/{id}/owners", handlers.AddPetOwner).Methods("POST")
+	api.HandleFunc("/pets/{id}/owners/{ownerId}", handlers.RemovePetOwner).Methods("DELETE")
+	api.HandleFunc("/pets/{id}/transfers", handlers.InitiatePetTransfer).Methods("POST")
+	api.HandleFunc("/transfers", handlers.GetPetTransfers).Methods("GET")
+	api.HandleFunc("/transfers/{id}/accept", handlers.AcceptPetTransfer).Methods("POST")
+	api.HandleFunc("/transfers/{id}/decline", handlers.DeclinePetTransfer).Methods("POST")
+	api.HandleFunc("/transfers/{id}/cancel", handlers.CancelPetTransfer).Methods("POST")
 
 	// Appointments
 	api.HandleFunc("/appointments", handlers.BookAppointment).Methods("POST")
 	api.HandleFunc("/appointments", handlers.GetAppointments).Methods("GET")
 	api.HandleFunc("/appointments/{id}", handlers.UpdateAppointment).Methods("PUT")
 	api.HandleFunc("/appointments/{id}", handlers.DeleteAppointment).Methods("DELETE")
+	api.HandleFunc("/appointments/{id}/ics", handlers.GetAppointmentICS).Methods("GET")
+
+	// Calendar feed tokens
+	api.HandleFunc("/calendar/tokens", handlers.CreateCalendarToken).Methods("POST")
+	api.HandleFunc("/calendar/tokens", handlers.GetCalendarTokens).Methods("GET")
+	api.HandleFunc("/calendar/tokens/{id}", handlers.RevokeCalendarToken).Methods("DELETE")
+
+	// Waitlist
+	api.HandleFunc("/waitlist", handlers.AddWaitlistEntry).Methods("POST")
+	api.HandleFunc("/waitlist", handlers.GetWaitlist).Methods("GET")
+	api.HandleFunc("/waitlist/offers", handlers.GetWaitlistOffers).Methods("GET")
+	api.HandleFunc("/waitlist/offers/{id}/accept", handlers.AcceptWaitlistOffer).Methods("POST")
+	api.HandleFunc("/waitlist/offers/{id}/decline", handlers.DeclineWaitlistOffer).Methods("POST")
+	api.HandleFunc("/waitlist/{id}", handlers.CancelWaitlistEntry).Methods("DELETE")
+
+	// Clinic calendar
+	api.HandleFunc("/clinic/hours", handlers.GetOpeningHours).Methods("GET")
+	api.HandleFunc("/clinic/hours", handlers.SetOpeningHours).Methods("PUT")
+	api.HandleFunc("/clinic/closures", handlers.GetClosures).Methods("GET")
+	api.HandleFunc("/clinic/closures", handlers.AddClosure).Methods("POST")
+	api.HandleFunc("/clinic/closures/import", handlers.ImportHolidays).Methods("POST")
+	api.HandleFunc("/clinic/closures/{id}", handlers.DeleteClosure).Methods("DELETE")
+	api.HandleFunc("/clinic/conflicts", handlers.GetClosureConflicts).Methods("GET")
+	api.HandleFunc("/clinic/availability", handlers.GetAvailability).Methods("GET")
+
+	// Walk-in triage queue
+	api.HandleFunc("/triage/checkins", handlers.CheckInPatient).Methods("POST")
+	api.HandleFunc("/triage/checkins/{id}", handlers.UpdateCheckIn).Methods("PUT")
+	api.HandleFunc("/triage/queue", handlers.GetTriageQueue).Methods("GET")
+	api.HandleFunc("/triage/queue/stream", handlers.StreamTriageQueue).Methods("GET")
+	api.HandleFunc("/triage/next", handlers.CallNextPatient).Methods("POST")
+
+	// Microchips and tags
+	api.HandleFunc("/pets/{id}/identifiers", handlers.AddPetIdentifier).Methods("POST")
+	api.HandleFunc("/pets/{id}/identifiers", handlers.GetPetIdentifiers).Methods("GET")
+	api.HandleFunc("/identifiers/lookup", handlers.LookupIdentifier).Methods("GET")
+	api.HandleFunc("/identifiers/{id}", handlers.DeletePetIdentifier).Methods("DELETE")
+
+	// Allergies, conditions and alerts
+	api.HandleFunc("/pets/{id}/alerts", handlers.AddPetAlert).Methods("POST")
+	api.HandleFunc("/pets/{id}/alerts", handlers.GetPetAlerts).Methods("GET")
+	api.HandleFunc("/alerts/{id}/resolve", handlers.ResolvePetAlert).Methods("PUT")
+
+	// Medical records
+	api.HandleFunc("/pets/{id}/visits", handlers.AddVisit).Methods("POST")
+	api.HandleFunc("/pets/{id}/visits", handlers.GetPetVisits).Methods("GET")
+	api.HandleFunc("/visits/{id}", handlers.GetVisit).Methods("GET")
+	api.HandleFunc("/visits/{id}/amendments", handlers.AmendVisit).Methods("POST")
+
+	// Vaccinations
+	api.HandleFunc("/vaccines", handlers.GetVaccines).Methods("GET")
+	api.HandleFunc("/vaccines", handlers.CreateVaccine).Methods("POST")
+	api.HandleFunc("/vaccines/{id}", handlers.UpdateVaccine).Methods("PUT")
+	api.HandleFunc("/vaccinations/due", handlers.GetDueVaccinations).Methods("GET")
+	api.HandleFunc("/pets/{id}/vaccinations", handlers.RecordVaccination).Methods("POST")
+	api.HandleFunc("/pets/{id}/vaccinations", handlers.GetPetVaccinations).Methods("GET")
+	api.HandleFunc("/pets/{id}/vaccination-certificate", handlers.GetVaccinationCertificate).Methods("GET")
+
+	// Prescriptions
+	api.HandleFunc("/pets/{id}/prescriptions", handlers.AddPrescription).Methods("POST")
+	api.HandleFunc("/pets/{id}/prescriptions", handlers.GetPetPrescriptions).Methods("GET")
+	api.HandleFunc("/prescriptions/{id}/status", handlers.UpdatePrescriptionStatus).Methods("PUT")
+	api.HandleFunc("/prescriptions/{id}/administrations", handlers.RecordAdministration).Methods("POST")
+	api.HandleFunc("/prescriptions/{id}/administrations", handlers.GetAdministrations).Methods("GET")
+	api.HandleFunc("/prescriptions/{id}/refill-requests", handlers.RequestRefill).Methods("POST")
+	api.HandleFunc("/refill-requests", handlers.GetRefillRequests).Methods("GET")
+	api.HandleFunc("/refill-requests/{id}/approve", handlers.ApproveRefill).Methods("POST")
+	api.HandleFunc("/refill-requests/{id}/deny", handlers.DenyRefill).Methods("POST")
+
+	// Vitals
+	api.HandleFunc("/pets/{id}/vitals", handlers.RecordVitals).Methods("POST")
+	api.HandleFunc("/pets/{id}/vitals", handlers.GetVitalsHistory).Methods("GET")
+	api.HandleFunc("/pets/{id}/vitals/ranges", handlers.GetVitalsReferenceRanges).Methods("GET")
+	api.HandleFunc("/pets/{id}/vitals/weight.svg", handlers.GetWeightChart).Methods("GET")
+
+	// Lab orders and results
+	api.HandleFunc("/pets/{id}/lab-orders", handlers.CreateLabOrder).Methods("POST")
+	api.HandleFunc("/pets/{id}/lab-orders", handlers.GetPetLabOrders).Methods("GET")
+	api.HandleFunc("/lab-orders", handlers.GetLabOrders).Methods("GET")
+	api.HandleFunc("/lab-results/import", handlers.ImportLabResults).Methods("POST")
+	api.HandleFunc("/lab-imports", handlers.GetLabImports).Methods("GET")
+	api.HandleFunc("/lab-imports/{id}/assign", handlers.AssignLabImport).Methods("POST")
+
+	// Reminders
+	api.HandleFunc("/reminders", handlers.GetReminderSends).Methods("GET")
 
 	// Files
 	api.HandleFunc("/upload", handlers.UploadFile).Methods("POST")
-	api.HandleFunc("/download/{filename}", handlers.DownloadFile).Methods("GET")
+	api.HandleFunc("/download/{id}", handlers.DownloadFile).Methods("GET", "HEAD")
+	api.HandleFunc("/attachments/{id}", handlers.GetAttachment).Methods("GET")
+	api.HandleFunc("/attachments/{id}/preview", handlers.GetAttachmentPreview).Methods("GET")
+	api.HandleFunc("/attachments/{id}/versions", handlers.GetAttachmentVersions).Methods("GET")
+	api.HandleFunc("/attachments/{id}/versions/{version}/restore", handlers.RestoreAttachmentVersion).Methods("POST")
+	api.HandleFunc("/attachments/{id}/links", handlers.CreateDownloadLink).Methods("POST")
+	api.HandleFunc("/attachments/{id}/links", handlers.GetDownloadLinks).Methods("GET")
+	api.HandleFunc("/links/{id}", handlers.RevokeDownloadLink).Methods("DELETE")
+	api.HandleFunc("/links/{id}/accesses", handlers.GetDownloadLinkAccesses).Methods("GET")
+	api.HandleFunc("/pets/{id}/attachments", handlers.GetPetAttachments).Methods("GET")
+	api.HandleFunc("/pets/{id}/archive", handlers.GetPetArchive).Methods("GET")
+	api.HandleFunc("/archive-jobs/{id}", handlers.GetArchiveJob).Methods("GET")
+	api.HandleFunc("/visits/{id}/attachments", handlers.GetVisitAttachments).Methods("GET")
+	api.HandleFunc("/uploads", handlers.UploadOptions).Methods("OPTIONS")
+	api.HandleFunc("/uploads", handlers.CreateUpload).Methods("POST")
+	api.HandleFunc("/uploads/{id}", handlers.GetUploadOffset).Methods("HEAD")
+	api.HandleFunc("/uploads/{id}", handlers.PatchUpload).Methods("PATCH")
+	api.HandleFunc("/uploads/{id}", handlers.DeleteUpload).Methods("DELETE")
+	api.HandleFunc("/storage/usage", handlers.GetStorageUsage).Methods("GET")
+	api.HandleFunc("/owners/{id}/storage-quota", handlers.SetOwnerStorageQuota).Methods("PUT")
+	api.HandleFunc("/quarantine", handlers.GetQuarantinedFiles).Methods("GET")
+	api.HandleFunc("/quarantine/{id}", handlers.DeleteQuarantinedFile).Methods("DELETE")
 
 	fmt.Println("Server running at http://localhost:8080")
 	utils.Log.Info("Server running at :8080")
@@ -65,3 +224,35 @@ func main() {
 	select {}
 
 }
+
+func runCommand(name string, args []string) {
+	var err error
+	switch name {
+	case "migrate-medical-history":
+		err = maintenance.MigrateLegacyMedicalHistory()
+	case "seed-breed-catalog":
+		err = maintenance.SeedBreedCatalog()
+	case "normalize-breeds":
+		err = maintenance.NormalizePetBreeds()
+	case "import-legacy-uploads":
+		if len(args) < 1 || len(args) > 2 {
+			utils.Log.Fatal("Usage: import-legacy-uploads <mapping.csv> [dir], e.g. import-legacy-uploads files.csv uploads")
+		}
+		dir := "uploads"
+		if len(args) == 2 {
+			dir = args[1]
+		}
+		err = maintenance.ImportLegacyUploads(args[0], dir)
+	case "migrate-blobs":
+		if len(args) != 2 {
+			utils.Log.Fatal("Usage: migrate-blobs <from> <to>, e.g. migrate-blobs local s3")
+		}
+		err = maintenance.MigrateBlobs(args[0], args[1])
+	default:
+		utils.Log.WithField("command", name).Fatal("Unknown command")
+	}
+	if err != nil {
+		utils.Log.WithError(err).WithField("command", name).Fatal("Command failed")
+	}
+	utils.Log.WithField("command", name).Info("Command finished")
+}
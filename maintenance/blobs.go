@@ -0,0 +1,62 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"pet-clinic/storage"
+	"pet-clinic/utils"
+)
+
+// MigrateBlobs copies every blob from one storage backend to another (e.g.
+// "local" to "s3"). Blobs already present in the target with the same size are
+// skipped, so an interrupted run can simply be repeated. The source is left as is.
+func MigrateBlobs(from, to string) error {
+	if from == to {
+		return fmt.Errorf("source and target backend are both %q", from)
+	}
+	src, err := storage.New(from)
+	if err != nil {
+		return err
+	}
+	dst, err := storage.New(to)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var copied, skipped int
+	err = src.List(ctx, "", func(info storage.Info) error {
+		existing, err := dst.Stat(ctx, info.Key)
+		if err == nil && existing.Size == info.Size {
+			skipped++
+			return nil
+		}
+		if err == nil {
+			return fmt.Errorf("blob %s exists in %s with size %d, expected %d", info.Key, to, existing.Size, info.Size)
+		}
+		if !errors.Is(err, storage.ErrNotExist) {
+			return err
+		}
+
+		r, err := src.Get(ctx, info.Key)
+		if err != nil {
+			return err
+		}
+		err = dst.Put(ctx, info.Key, r, info.Size)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("copy %s: %w", info.Key, err)
+		}
+
+		if check, err := dst.Stat(ctx, info.Key); err != nil || check.Size != info.Size {
+			return fmt.Errorf("blob %s did not verify in %s after copy", info.Key, to)
+		}
+		copied++
+		utils.Log.WithFields(map[string]interface{}{"key": info.Key, "size": info.Size}).Debug("Blob copied")
+		return nil
+	})
+
+	utils.Log.WithFields(map[string]interface{}{"from": from, "to": to, "copied": copied, "skipped": skipped}).Info("Blob migration done")
+	return err
+}
@@ -0,0 +1,87 @@
+package maintenance
+
+import (
+	"pet-clinic/catalog"
+	"pet-clinic/db"
+	"pet-clinic/utils"
+)
+
+// SeedBreedCatalog imports the bundled species/breed seed file; rows that already
+// exist are kept, so it can be re-run after the seed file grows
+func SeedBreedCatalog() error {
+	seed, err := catalog.Seed()
+	if err != nil {
+		return err
+	}
+	return catalog.Import(seed)
+}
+
+// NormalizePetBreeds rewrites pet species and breeds to their catalog names.
+// Exact names and aliases are always applied; misspellings are corrected only
+// when one catalog name is clearly closest. Everything else is logged for review.
+func NormalizePetBreeds() error {
+	c, err := catalog.Load()
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.DB.Query("SELECT id, COALESCE(species, ''), COALESCE(breed, '') FROM pets ORDER BY id")
+	if err != nil {
+		return err
+	}
+	type pet struct {
+		id             int
+		species, breed string
+	}
+	var pets []pet
+	for rows.Next() {
+		var p pet
+		if err := rows.Scan(&p.id, &p.species, &p.breed); err != nil {
+			rows.Close()
+			return err
+		}
+		pets = append(pets, p)
+	}
+	rows.Close()
+
+	resolved := func(m catalog.Match) string {
+		if m.Name != "" {
+			return m.Name
+		}
+		return m.Closest
+	}
+
+	var updated, unresolved int
+	for _, p := range pets {
+		log := utils.Log.WithFields(map[string]interface{}{"pet_id": p.id, "species": p.species, "breed": p.breed})
+
+		species := resolved(c.ResolveSpecies(p.species))
+		if species == "" {
+			log.Warn("Species not in catalog, left unchanged")
+			unresolved++
+			continue
+		}
+		breed := p.breed
+		if breed != "" && c.HasBreeds(species) {
+			m := c.ResolveBreed(species, breed)
+			if b := resolved(m); b != "" {
+				breed = b
+			} else {
+				log.WithField("suggestions", m.Suggestions).Warn("Breed not in catalog, left unchanged")
+				unresolved++
+			}
+		}
+
+		if species == p.species && breed == p.breed {
+			continue
+		}
+		if _, err := db.DB.Exec("UPDATE pets SET species=$1, breed=$2 WHERE id=$3", species, breed, p.id); err != nil {
+			return err
+		}
+		log.WithFields(map[string]interface{}{"new_species": species, "new_breed": breed}).Info("Pet species/breed normalized")
+		updated++
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"pets": len(pets), "updated": updated, "unresolved": unresolved}).Info("Breed normalization done")
+	return nil
+}
@@ -0,0 +1,25 @@
+// Package maintenance holds one-off data jobs run from the command line (./petclinic <command>).
+package maintenance
+
+import (
+	"pet-clinic/db"
+	"pet-clinic/utils"
+)
+
+// MigrateLegacyMedicalHistory copies each pet's free-text medical_history into a
+// 'legacy' medical record entry. Pets that already have a legacy entry are skipped,
+// so the job can be re-run safely.
+func MigrateLegacyMedicalHistory() error {
+	result, err := db.DB.Exec(`INSERT INTO visits (pet_id, kind, subjective, created_by)
+		SELECT p.id, 'legacy', p.medical_history, 'migration'
+		FROM pets p
+		WHERE COALESCE(TRIM(p.medical_history), '') <> ''
+		  AND NOT EXISTS (SELECT 1 FROM visits v WHERE v.pet_id = p.id AND v.kind = 'legacy')`)
+	if err != nil {
+		return err
+	}
+
+	count, _ := result.RowsAffected()
+	utils.Log.WithField("migrated", count).Info("Legacy medical history migrated")
+	return nil
+}
@@ -0,0 +1,109 @@
+package maintenance
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"pet-clinic/db"
+	"pet-clinic/handlers"
+	"pet-clinic/utils"
+)
+
+// legacyImporter is recorded as uploaded_by, which also marks files already imported
+const legacyImporter = "legacy-import"
+
+// ImportLegacyUploads records files saved by the old upload handler under
+// dir/<original name> as attachments. The old handler kept no link to a pet, so
+// mappingFile is a CSV of "filename,pet_id" lines saying where each file belongs.
+// Files already imported for that pet are skipped, so the job can be re-run; files
+// in dir that are not in the mapping are only logged. Originals are left in place.
+func ImportLegacyUploads(mappingFile, dir string) error {
+	f, err := os.Open(mappingFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = 2
+	mapped := map[string]bool{}
+	var imported, skipped, failed int
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSpace(record[0])
+		petID, err := strconv.Atoi(strings.TrimSpace(record[1]))
+		if err != nil {
+			if line == 1 {
+				continue // header row
+			}
+			return fmt.Errorf("%s line %d: invalid pet_id %q", mappingFile, line, record[1])
+		}
+		if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+			return fmt.Errorf("%s line %d: %q is not a file name", mappingFile, line, name)
+		}
+		mapped[name] = true
+		log := utils.Log.WithFields(map[string]interface{}{"file": name, "pet_id": petID})
+
+		var done bool
+		err = db.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM attachments WHERE pet_id=$1 AND filename=$2 AND uploaded_by=$3)`,
+			petID, name, legacyImporter).Scan(&done)
+		if err != nil {
+			return err
+		}
+		if done {
+			skipped++
+			continue
+		}
+
+		file, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			log.WithError(err).Warn("Legacy upload could not be opened")
+			failed++
+			continue
+		}
+		a, err := handlers.ImportLegacyFile(context.Background(), petID, file, legacyImporter)
+		file.Close()
+		if err != nil {
+			log.WithError(err).Warn("Legacy upload not imported")
+			failed++
+			continue
+		}
+		log.WithFields(map[string]interface{}{"id": a.ID, "size": a.Size}).Info("Legacy upload imported")
+		imported++
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var unmapped int
+	for _, e := range entries {
+		// 32 hex characters are blob keys the current upload handler wrote
+		if _, err := hex.DecodeString(e.Name()); err == nil && len(e.Name()) == 32 {
+			continue
+		}
+		if e.Type().IsRegular() && !mapped[e.Name()] {
+			utils.Log.WithField("file", e.Name()).Warn("Legacy upload not in the mapping, left unimported")
+			unmapped++
+		}
+	}
+
+	utils.Log.WithFields(map[string]interface{}{"imported": imported, "skipped": skipped, "failed": failed, "unmapped": unmapped}).Info("Legacy upload import done")
+	if failed > 0 {
+		return fmt.Errorf("%d legacy uploads could not be imported", failed)
+	}
+	return nil
+}
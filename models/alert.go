@@ -0,0 +1,15 @@
+package models
+
+type PetAlert struct {
+	ID         int    `json:"id"`
+	PetID      int    `json:"pet_id"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	DrugClass  string `json:"drug_class,omitempty"`
+	Severity   string `json:"severity"`
+	Notes      string `json:"notes,omitempty"`
+	Active     bool   `json:"active"`
+	CreatedBy  string `json:"created_by,omitempty"`
+	CreatedAt  string `json:"created_at"`
+	ResolvedAt string `json:"resolved_at,omitempty"`
+}
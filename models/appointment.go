@@ -6,4 +6,9 @@ type Appointment struct {
 	Time   string `json:"time"`
 	PetID  int    `json:"pet_id"`
 	Reason string `json:"reason"`
+	Vet    string `json:"vet"`
+	Type   string `json:"appointment_type"`
+	Status string `json:"status"`
+
+	ClosureConflictID *int `json:"closure_conflict_id,omitempty"`
 }
@@ -0,0 +1,123 @@
+package models
+
+import "time"
+
+// Attachment is a stored file; ID is server-generated and Filename is only metadata
+type Attachment struct {
+	ID          string `json:"id"`
+	PetID       int    `json:"pet_id"`
+	OwnerID     *int   `json:"owner_id,omitempty"`
+	VisitID     *int   `json:"visit_id,omitempty"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"`
+	Description string `json:"description"`
+	UploadedBy  string `json:"uploaded_by,omitempty"`
+	UploadedAt  string `json:"uploaded_at"`
+	// Version is the current version; BlobKey is where its content is stored
+	Version int    `json:"version"`
+	BlobKey string `json:"-"`
+	// PreviewStatus: none (not an image/PDF), pending, processing, ready or failed
+	PreviewStatus string `json:"preview_status"`
+}
+
+// AttachmentVersion is one upload (or restore) of an attachment
+type AttachmentVersion struct {
+	Version      int    `json:"version"`
+	Filename     string `json:"filename"`
+	ContentType  string `json:"content_type"`
+	Size         int64  `json:"size"`
+	SHA256       string `json:"sha256"`
+	RestoredFrom *int   `json:"restored_from,omitempty"`
+	UploadedBy   string `json:"uploaded_by,omitempty"`
+	UploadedAt   string `json:"uploaded_at"`
+	BlobKey      string `json:"-"`
+}
+
+// StorageUsage is the stored bytes of one owner, or of the whole clinic when
+// OwnerID is nil; QuotaBytes nil means unlimited
+type StorageUsage struct {
+	OwnerID    *int   `json:"owner_id,omitempty"`
+	OwnerName  string `json:"owner_name,omitempty"`
+	UsedBytes  int64  `json:"used_bytes"`
+	QuotaBytes *int64 `json:"quota_bytes"`
+}
+
+// QuarantinedFile is an upload the malware scanner rejected
+type QuarantinedFile struct {
+	ID            string `json:"id"`
+	PetID         *int   `json:"pet_id,omitempty"`
+	Filename      string `json:"filename"`
+	ContentType   string `json:"content_type"`
+	Size          int64  `json:"size"`
+	SHA256        string `json:"sha256"`
+	Signature     string `json:"signature"`
+	UploadedBy    string `json:"uploaded_by,omitempty"`
+	QuarantinedAt string `json:"quarantined_at"`
+}
+
+// UploadSession is a resumable upload; Parts are the blob keys of the chunks received so far
+type UploadSession struct {
+	ID             string
+	PetID          int
+	VisitID        *int
+	Filename       string
+	Description    string
+	ExpectedSHA256 string
+	Length         int64
+	Offset         int64
+	Parts          []string
+	CreatedBy      string
+	ExpiresAt      time.Time
+	AttachmentID   *string
+	VersionOf      *string
+}
+
+// DownloadLink shares one attachment through a signed URL until it expires,
+// is revoked or reaches MaxDownloads
+type DownloadLink struct {
+	ID            int    `json:"id"`
+	AttachmentID  string `json:"attachment_id"`
+	Recipient     string `json:"recipient,omitempty"`
+	ExpiresAt     string `json:"expires_at"`
+	MaxDownloads  *int   `json:"max_downloads,omitempty"`
+	DownloadCount int    `json:"download_count"`
+	URL           string `json:"url,omitempty"`
+	CreatedBy     string `json:"created_by"`
+	CreatedAt     string `json:"created_at"`
+	RevokedBy     string `json:"revoked_by,omitempty"`
+	RevokedAt     string `json:"revoked_at,omitempty"`
+	// ExpiresUnix is expires_at as read by the database, signed into the URL
+	ExpiresUnix int64 `json:"-"`
+}
+
+// DownloadLinkAccess is one request made with a download link
+type DownloadLinkAccess struct {
+	ID         int    `json:"id"`
+	LinkID     int    `json:"link_id"`
+	Outcome    string `json:"outcome"`
+	RemoteAddr string `json:"remote_addr"`
+	UserAgent  string `json:"user_agent"`
+	AccessedAt string `json:"accessed_at"`
+}
+
+// ArchiveJob builds a ZIP of a pet's whole record in the background; URL is set
+// once it is ready and stops working at ExpiresAt
+type ArchiveJob struct {
+	ID          int    `json:"id"`
+	PetID       int    `json:"pet_id"`
+	Status      string `json:"status"`
+	Filename    string `json:"filename"`
+	Size        *int64 `json:"size,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
+	Error       string `json:"error,omitempty"`
+	URL         string `json:"url,omitempty"`
+	RequestedBy string `json:"requested_by"`
+	CreatedAt   string `json:"created_at"`
+	CompletedAt string `json:"completed_at,omitempty"`
+	ExpiresAt   string `json:"expires_at,omitempty"`
+	BlobKey     string `json:"-"`
+	// ExpiresUnix is expires_at as read by the database, signed into the URL
+	ExpiresUnix int64 `json:"-"`
+}
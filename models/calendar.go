@@ -0,0 +1,12 @@
+package models
+
+type CalendarFeedToken struct {
+	ID        int    `json:"id"`
+	Scope     string `json:"scope"`
+	Subject   string `json:"subject"`
+	Token     string `json:"token,omitempty"`
+	FeedURL   string `json:"feed_url,omitempty"`
+	CreatedBy string `json:"created_by"`
+	CreatedAt string `json:"created_at"`
+	RevokedAt string `json:"revoked_at,omitempty"`
+}
@@ -0,0 +1,30 @@
+package models
+
+type OpeningHours struct {
+	Weekday int    `json:"weekday"`
+	Opens   string `json:"opens"`
+	Closes  string `json:"closes"`
+}
+
+type ClinicClosure struct {
+	ID        int    `json:"id"`
+	StartsAt  string `json:"starts_at"`
+	EndsAt    string `json:"ends_at"`
+	Reason    string `json:"reason"`
+	Kind      string `json:"kind"`
+	SourceUID string `json:"source_uid,omitempty"`
+	CreatedBy string `json:"created_by,omitempty"`
+}
+
+// ClosureResult is returned when closures are added, with the appointments they clash with
+type ClosureResult struct {
+	Closures            []ClinicClosure `json:"closures"`
+	FlaggedAppointments []int           `json:"flagged_appointments"`
+}
+
+type Availability struct {
+	Date  string   `json:"date"`
+	Vet   string   `json:"vet"`
+	Open  bool     `json:"open"`
+	Slots []string `json:"slots"`
+}
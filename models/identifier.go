@@ -0,0 +1,30 @@
+package models
+
+type PetIdentifier struct {
+	ID      int    `json:"id"`
+	PetID   int    `json:"pet_id"`
+	Kind    string `json:"kind"`
+	Value   string `json:"value"`
+	Issuer  string `json:"issuer,omitempty"`
+	Notes   string `json:"notes,omitempty"`
+	AddedBy string `json:"added_by,omitempty"`
+	AddedAt string `json:"added_at"`
+}
+
+// IdentifierMatch is a lookup hit. Owners is only filled in for staff.
+type IdentifierMatch struct {
+	Identifier PetIdentifier  `json:"identifier"`
+	PetName    string         `json:"pet_name"`
+	Species    string         `json:"species"`
+	Breed      string         `json:"breed"`
+	PetStatus  string         `json:"pet_status"`
+	Owners     []OwnerContact `json:"owners,omitempty"`
+}
+
+type OwnerContact struct {
+	OwnerID int    `json:"owner_id"`
+	Name    string `json:"name"`
+	Role    string `json:"role"`
+	Contact string `json:"contact"`
+	Email   string `json:"email"`
+}
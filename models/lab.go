@@ -0,0 +1,45 @@
+package models
+
+type LabOrder struct {
+	ID         int         `json:"id"`
+	PetID      int         `json:"pet_id"`
+	VisitID    *int        `json:"visit_id,omitempty"`
+	Accession  string      `json:"accession"`
+	Panel      string      `json:"panel"`
+	Notes      string      `json:"notes"`
+	Status     string      `json:"status"`
+	OrderedBy  string      `json:"ordered_by,omitempty"`
+	OrderedAt  string      `json:"ordered_at"`
+	ResultedAt string      `json:"resulted_at,omitempty"`
+	Results    []LabResult `json:"results,omitempty"`
+}
+
+type LabResult struct {
+	ID           int      `json:"id"`
+	OrderID      int      `json:"order_id"`
+	Code         string   `json:"code"`
+	Name         string   `json:"name"`
+	Value        string   `json:"value"`
+	NumericValue *float64 `json:"numeric_value,omitempty"`
+	Units        string   `json:"units"`
+	RefLow       *float64 `json:"ref_low,omitempty"`
+	RefHigh      *float64 `json:"ref_high,omitempty"`
+	// L, H, LL, HH or A; empty when within range
+	Flag       string `json:"flag,omitempty"`
+	Abnormal   bool   `json:"abnormal"`
+	ReceivedAt string `json:"received_at"`
+}
+
+type LabImport struct {
+	ID         int    `json:"id"`
+	Filename   string `json:"filename"`
+	Format     string `json:"format"`
+	Source     string `json:"source"`
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	ImportedBy string `json:"imported_by,omitempty"`
+	ReceivedAt string `json:"received_at"`
+	// accession numbers (or pet ids) found in the file
+	Matched   []string `json:"matched,omitempty"`
+	Unmatched []string `json:"unmatched,omitempty"`
+}
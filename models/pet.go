@@ -1,10 +1,30 @@
 package models
 
 type Pet struct {
-	ID             int    `json:"id"`
-	Name           string `json:"name"`
-	Species        string `json:"species"`
-	Breed          string `json:"breed"`
-	OwnerID        int    `json:"owner_id"`
-	MedicalHistory string `json:"medical_history"`
+	ID             int        `json:"id"`
+	Name           string     `json:"name"`
+	Alerts         []PetAlert `json:"alerts"`
+	Species        string     `json:"species"`
+	Breed          string     `json:"breed"`
+	OwnerID        int        `json:"owner_id"`
+	MedicalHistory string     `json:"medical_history"`
+	Status         string     `json:"status"`
+	StatusDate     string     `json:"status_date,omitempty"`
+	CauseOfDeath   string     `json:"cause_of_death,omitempty"`
+	StatusNote     string     `json:"status_note,omitempty"`
+}
+
+// PetStatusChange moves a pet between active, deceased, transferred and lost
+type PetStatusChange struct {
+	ID           int    `json:"id"`
+	PetID        int    `json:"pet_id"`
+	Status       string `json:"status"`
+	Date         string `json:"date,omitempty"`
+	CauseOfDeath string `json:"cause_of_death,omitempty"`
+	Note         string `json:"note,omitempty"`
+	ChangedBy    string `json:"changed_by,omitempty"`
+	ChangedAt    string `json:"changed_at"`
+	// filled in on the response when marking a pet deceased
+	CancelledAppointments int `json:"cancelled_appointments,omitempty"`
+	CancelledReminders    int `json:"cancelled_reminders,omitempty"`
 }
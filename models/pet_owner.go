@@ -0,0 +1,27 @@
+package models
+
+type PetOwner struct {
+	PetID     int    `json:"pet_id"`
+	OwnerID   int    `json:"owner_id"`
+	OwnerName string `json:"owner_name"`
+	// primary, co-owner or caregiver
+	Role      string `json:"role"`
+	AddedBy   string `json:"added_by,omitempty"`
+	AddedAt   string `json:"added_at"`
+	RemovedBy string `json:"removed_by,omitempty"`
+	RemovedAt string `json:"removed_at,omitempty"`
+}
+
+type PetTransfer struct {
+	ID          int    `json:"id"`
+	PetID       int    `json:"pet_id"`
+	PetName     string `json:"pet_name"`
+	FromOwnerID int    `json:"from_owner_id"`
+	ToOwnerID   int    `json:"to_owner_id"`
+	Note        string `json:"note"`
+	Status      string `json:"status"`
+	InitiatedBy string `json:"initiated_by,omitempty"`
+	CreatedAt   string `json:"created_at"`
+	DecidedBy   string `json:"decided_by,omitempty"`
+	DecidedAt   string `json:"decided_at,omitempty"`
+}
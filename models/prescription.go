@@ -0,0 +1,48 @@
+package models
+
+type Prescription struct {
+	ID             int    `json:"id"`
+	PetID          int    `json:"pet_id"`
+	VisitID        int    `json:"visit_id"`
+	Drug           string `json:"drug"`
+	DrugClass      string `json:"drug_class"`
+	Dose           string `json:"dose"`
+	Frequency      string `json:"frequency"`
+	Route          string `json:"route"`
+	DurationDays   *int   `json:"duration_days,omitempty"`
+	RefillsAllowed int    `json:"refills_allowed"`
+	RefillsUsed    int    `json:"refills_used"`
+	Instructions   string `json:"instructions"`
+	PrescribingVet string `json:"prescribing_vet"`
+	// set by staff to prescribe despite a severe allergy
+	AllergyOverride string   `json:"allergy_override,omitempty"`
+	Warnings        []string `json:"warnings,omitempty"`
+	Status          string   `json:"status"`
+	CreatedBy       string   `json:"created_by,omitempty"`
+	CreatedAt       string   `json:"created_at"`
+}
+
+type MedicationAdministration struct {
+	ID             int    `json:"id"`
+	PrescriptionID int    `json:"prescription_id"`
+	AdministeredAt string `json:"administered_at"`
+	DoseGiven      string `json:"dose_given"`
+	Route          string `json:"route"`
+	Outcome        string `json:"outcome"`
+	Notes          string `json:"notes"`
+	AdministeredBy string `json:"administered_by"`
+}
+
+type RefillRequest struct {
+	ID             int    `json:"id"`
+	PrescriptionID int    `json:"prescription_id"`
+	PetID          int    `json:"pet_id"`
+	Drug           string `json:"drug"`
+	Note           string `json:"note"`
+	Status         string `json:"status"`
+	RequestedBy    string `json:"requested_by"`
+	RequestedAt    string `json:"requested_at"`
+	DecidedBy      string `json:"decided_by,omitempty"`
+	DecidedAt      string `json:"decided_at,omitempty"`
+	DecisionNote   string `json:"decision_note,omitempty"`
+}
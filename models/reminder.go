@@ -0,0 +1,14 @@
+package models
+
+type ReminderSend struct {
+	ID            int    `json:"id"`
+	AppointmentID *int   `json:"appointment_id,omitempty"`
+	VaccinationID *int   `json:"vaccination_id,omitempty"`
+	OffsetMinutes int    `json:"offset_minutes"`
+	Channel       string `json:"channel"`
+	Recipient     string `json:"recipient"`
+	Status        string `json:"status"`
+	Attempts      int    `json:"attempts"`
+	LastError     string `json:"last_error,omitempty"`
+	SentAt        string `json:"sent_at,omitempty"`
+}
@@ -0,0 +1,27 @@
+package models
+
+type CheckIn struct {
+	ID            int    `json:"id"`
+	PetID         *int   `json:"pet_id,omitempty"`
+	PatientName   string `json:"patient_name"`
+	Complaint     string `json:"complaint"`
+	Priority      int    `json:"priority"`
+	PriorityLabel string `json:"priority_label"`
+	AssignedVet   string `json:"assigned_vet"`
+	Status        string `json:"status"`
+	ArrivedAt     string `json:"arrived_at"`
+	CalledAt      string `json:"called_at,omitempty"`
+	WaitedMinutes int    `json:"waited_minutes"`
+	EstimatedWait *int   `json:"estimated_wait_minutes,omitempty"`
+}
+
+// TriageBoardEntry is the anonymised view shown on the waiting room screen:
+// a ticket number and the patient's initials, never the full name
+type TriageBoardEntry struct {
+	Number        int    `json:"number"`
+	Initials      string `json:"initials"`
+	PriorityLabel string `json:"priority_label"`
+	Status        string `json:"status"`
+	AssignedVet   string `json:"assigned_vet,omitempty"`
+	EstimatedWait *int   `json:"estimated_wait_minutes,omitempty"`
+}
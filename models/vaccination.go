@@ -0,0 +1,43 @@
+package models
+
+type Vaccine struct {
+	ID                  int    `json:"id"`
+	Name                string `json:"name"`
+	Species             string `json:"species"`
+	Core                bool   `json:"core"`
+	FirstDoseAgeWeeks   int    `json:"first_dose_age_weeks"`
+	InitialDoses        int    `json:"initial_doses"`
+	InitialIntervalDays int    `json:"initial_interval_days"`
+	BoosterIntervalDays int    `json:"booster_interval_days"`
+}
+
+type Vaccination struct {
+	ID               int    `json:"id"`
+	PetID            int    `json:"pet_id"`
+	VaccineID        int    `json:"vaccine_id"`
+	VaccineName      string `json:"vaccine_name"`
+	VisitID          *int   `json:"visit_id,omitempty"`
+	AdministeredOn   string `json:"administered_on"`
+	DoseNumber       int    `json:"dose_number"`
+	Product          string `json:"product"`
+	LotNumber        string `json:"lot_number"`
+	AdministeringVet string `json:"administering_vet"`
+	NextDueDate      string `json:"next_due_date,omitempty"`
+	CreatedBy        string `json:"created_by,omitempty"`
+}
+
+// VaccinationDue is a pet whose latest dose of a vaccine is overdue or due soon
+type VaccinationDue struct {
+	PetID         int    `json:"pet_id"`
+	PetName       string `json:"pet_name"`
+	OwnerID       int    `json:"owner_id"`
+	OwnerName     string `json:"owner_name"`
+	OwnerContact  string `json:"owner_contact"`
+	VaccineID     int    `json:"vaccine_id"`
+	VaccineName   string `json:"vaccine_name"`
+	LastGivenOn   string `json:"last_given_on"`
+	NextDueDate   string `json:"next_due_date"`
+	DaysUntilDue  int    `json:"days_until_due"`
+	Status        string `json:"status"`
+	VaccinationID int    `json:"vaccination_id"`
+}
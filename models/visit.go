@@ -0,0 +1,36 @@
+package models
+
+type SOAPNotes struct {
+	Subjective string `json:"subjective"`
+	Objective  string `json:"objective"`
+	Assessment string `json:"assessment"`
+	Plan       string `json:"plan"`
+}
+
+// Visit is one medical record entry; the notes shown have all amendments applied
+type Visit struct {
+	ID            int    `json:"id"`
+	PetID         int    `json:"pet_id"`
+	AppointmentID *int   `json:"appointment_id,omitempty"`
+	VisitDate     string `json:"visit_date"`
+	Vet           string `json:"vet"`
+	Kind          string `json:"kind"`
+	SOAPNotes
+	Original   *SOAPNotes       `json:"original_notes,omitempty"`
+	Amendments []VisitAmendment `json:"amendments"`
+	CreatedBy  string           `json:"created_by"`
+	CreatedAt  string           `json:"created_at"`
+}
+
+// VisitAmendment changes only the notes that are set
+type VisitAmendment struct {
+	ID         int     `json:"id"`
+	VisitID    int     `json:"visit_id"`
+	Subjective *string `json:"subjective,omitempty"`
+	Objective  *string `json:"objective,omitempty"`
+	Assessment *string `json:"assessment,omitempty"`
+	Plan       *string `json:"plan,omitempty"`
+	Reason     string  `json:"reason"`
+	AmendedBy  string  `json:"amended_by"`
+	AmendedAt  string  `json:"amended_at"`
+}
@@ -0,0 +1,37 @@
+package models
+
+// Measurement is a value with its unit as sent by the client (kg/lb, C/F)
+type Measurement struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+type VitalsInput struct {
+	VisitID            *int         `json:"visit_id"`
+	RecordedAt         string       `json:"recorded_at"`
+	Weight             *Measurement `json:"weight"`
+	Temperature        *Measurement `json:"temperature"`
+	HeartRateBPM       *int         `json:"heart_rate_bpm"`
+	RespiratoryRateBPM *int         `json:"respiratory_rate_bpm"`
+}
+
+type VitalsReading struct {
+	ID                 int          `json:"id"`
+	PetID              int          `json:"pet_id"`
+	VisitID            *int         `json:"visit_id,omitempty"`
+	RecordedAt         string       `json:"recorded_at"`
+	Weight             *Measurement `json:"weight,omitempty"`
+	Temperature        *Measurement `json:"temperature,omitempty"`
+	HeartRateBPM       *float64     `json:"heart_rate_bpm,omitempty"`
+	RespiratoryRateBPM *float64     `json:"respiratory_rate_bpm,omitempty"`
+	Samples            int          `json:"samples,omitempty"`
+	Flags              []string     `json:"flags,omitempty"`
+	RecordedBy         string       `json:"recorded_by,omitempty"`
+}
+
+type ReferenceRange struct {
+	Measure string  `json:"measure"`
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+	Breed   string  `json:"breed,omitempty"`
+}
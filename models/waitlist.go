@@ -0,0 +1,27 @@
+package models
+
+type WaitlistEntry struct {
+	ID               int      `json:"id"`
+	PetID            int      `json:"pet_id"`
+	EarliestDate     string   `json:"earliest_date"`
+	LatestDate       string   `json:"latest_date"`
+	Vets             []string `json:"vets"`
+	AppointmentTypes []string `json:"appointment_types"`
+	Reason           string   `json:"reason"`
+	Status           string   `json:"status"`
+	CreatedBy        string   `json:"created_by"`
+	CreatedAt        string   `json:"created_at"`
+}
+
+type WaitlistOffer struct {
+	ID            int    `json:"id"`
+	EntryID       int    `json:"entry_id"`
+	PetID         int    `json:"pet_id"`
+	Date          string `json:"date"`
+	Time          string `json:"time"`
+	Vet           string `json:"vet"`
+	Type          string `json:"appointment_type"`
+	Status        string `json:"status"`
+	ExpiresAt     string `json:"expires_at"`
+	AppointmentID *int   `json:"appointment_id,omitempty"`
+}
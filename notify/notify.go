@@ -0,0 +1,22 @@
+package notify
+
+// Message is a single notification addressed to one recipient
+type Message struct {
+	To          string
+	Subject     string
+	Body        string
+	Attachments []Attachment
+}
+
+// Attachment is a file sent along with a message (channels that cannot carry files ignore it)
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Notifier delivers messages over one channel (email, sms, ...)
+type Notifier interface {
+	Channel() string
+	Send(msg Message) error
+}
@@ -0,0 +1,30 @@
+package notify
+
+import (
+	"errors"
+	"strings"
+
+	"pet-clinic/utils"
+)
+
+// SMSGatewayNotifier is a stub SMS gateway: it validates and logs messages
+// instead of delivering them until a real provider is wired in.
+type SMSGatewayNotifier struct {
+	SenderID string
+}
+
+func (n *SMSGatewayNotifier) Channel() string { return "sms" }
+
+func (n *SMSGatewayNotifier) Send(msg Message) error {
+	number := strings.TrimPrefix(strings.TrimSpace(msg.To), "+")
+	if len(number) < 7 || strings.Trim(number, "0123456789") != "" {
+		return errors.New("invalid phone number: " + msg.To)
+	}
+
+	utils.Log.WithFields(map[string]interface{}{
+		"sender": n.SenderID,
+		"to":     msg.To,
+		"length": len(msg.Body),
+	}).Info("SMS gateway stub: message accepted")
+	return nil
+}
@@ -0,0 +1,89 @@
+package notify
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+)
+
+// SMTPNotifier sends plain-text emails through an SMTP relay
+type SMTPNotifier struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// NewSMTPNotifierFromEnv builds an SMTPNotifier from SMTP_HOST, SMTP_PORT,
+// SMTP_USERNAME, SMTP_PASSWORD and SMTP_FROM
+func NewSMTPNotifierFromEnv() (*SMTPNotifier, error) {
+	n := &SMTPNotifier{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     os.Getenv("SMTP_PORT"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+	if n.Host == "" || n.From == "" {
+		return nil, errors.New("SMTP_HOST and SMTP_FROM must be set")
+	}
+	if n.Port == "" {
+		n.Port = "587"
+	}
+	return n, nil
+}
+
+func (n *SMTPNotifier) Channel() string { return "email" }
+
+func (n *SMTPNotifier) Send(msg Message) error {
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return errors.New("invalid characters in email header")
+	}
+
+	var a smtp.Auth
+	if n.Username != "" {
+		a = smtp.PlainAuth("", n.Username, n.Password, n.Host)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", n.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	body := strings.ReplaceAll(msg.Body, "\n", "\r\n")
+
+	if len(msg.Attachments) == 0 {
+		b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+		b.WriteString(body)
+	} else {
+		mw := multipart.NewWriter(&b)
+		fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+
+		part, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=UTF-8"}})
+		part.Write([]byte(body))
+
+		for _, a := range msg.Attachments {
+			part, _ := mw.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {a.ContentType},
+				"Content-Transfer-Encoding": {"base64"},
+				"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})},
+			})
+			enc := base64.StdEncoding.EncodeToString(a.Data)
+			for len(enc) > 76 {
+				part.Write([]byte(enc[:76] + "\r\n"))
+				enc = enc[76:]
+			}
+			part.Write([]byte(enc + "\r\n"))
+		}
+		mw.Close()
+	}
+
+	return smtp.SendMail(n.Host+":"+n.Port, a, n.From, []string{msg.To}, []byte(b.String()))
+}
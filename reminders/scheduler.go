@@ -0,0 +1,325 @@
+package reminders
+
+import (
+	"database/sql"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"pet-clinic/db"
+	"pet-clinic/notify"
+	"pet-clinic/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+// recipient column on owners used by each channel
+var recipientColumn = map[string]string{
+	"email": "o.email",
+	"sms":   "o.contact",
+}
+
+// Scheduler sends appointment reminders at fixed offsets before the appointment,
+// and vaccination reminders a number of days before the next dose is due
+type Scheduler struct {
+	Offsets         []time.Duration // largest first
+	VaccineLeadDays int             // 0 disables vaccination reminders
+	Notifiers       []notify.Notifier
+	MaxAttempts     int
+	RetryDelay      time.Duration
+
+	templates map[string]*template.Template
+}
+
+// NewSchedulerFromEnv configures a scheduler from:
+//
+//	REMINDER_OFFSETS       comma separated durations, default "48h,2h"
+//	VACCINE_REMINDER_DAYS  days before a vaccination is due, default 14 (0 disables)
+//	REMINDER_CHANNELS      comma separated channels (email, sms), default "email"
+//	REMINDER_MAX_ATTEMPTS  sends per reminder before giving up, default 5
+//	REMINDER_TEMPLATE_DIR  directory with <channel>.tmpl overrides
+func NewSchedulerFromEnv() *Scheduler {
+	s := &Scheduler{
+		VaccineLeadDays: 14,
+		MaxAttempts:     5,
+		RetryDelay:      5 * time.Minute,
+		templates:       map[string]*template.Template{},
+	}
+
+	offsets := os.Getenv("REMINDER_OFFSETS")
+	if offsets == "" {
+		offsets = "48h,2h"
+	}
+	for _, o := range strings.Split(offsets, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(o))
+		if err != nil || d <= 0 {
+			utils.Log.WithField("offset", o).Warn("Ignoring invalid reminder offset")
+			continue
+		}
+		s.Offsets = append(s.Offsets, d)
+	}
+	sort.Slice(s.Offsets, func(i, j int) bool { return s.Offsets[i] > s.Offsets[j] })
+
+	if n, err := strconv.Atoi(os.Getenv("VACCINE_REMINDER_DAYS")); err == nil && n >= 0 {
+		s.VaccineLeadDays = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("REMINDER_MAX_ATTEMPTS")); err == nil && n > 0 {
+		s.MaxAttempts = n
+	}
+
+	channels := os.Getenv("REMINDER_CHANNELS")
+	if channels == "" {
+		channels = "email"
+	}
+	for _, c := range strings.Split(channels, ",") {
+		switch strings.TrimSpace(c) {
+		case "email":
+			n, err := notify.NewSMTPNotifierFromEnv()
+			if err != nil {
+				utils.Log.WithError(err).Warn("Email reminders disabled")
+				continue
+			}
+			s.Notifiers = append(s.Notifiers, n)
+		case "sms":
+			s.Notifiers = append(s.Notifiers, &notify.SMSGatewayNotifier{SenderID: os.Getenv("SMS_SENDER_ID")})
+		default:
+			utils.Log.WithField("channel", c).Warn("Ignoring unknown reminder channel")
+		}
+	}
+
+	dir := os.Getenv("REMINDER_TEMPLATE_DIR")
+	for _, n := range s.Notifiers {
+		t, err := loadTemplate(dir, n.Channel())
+		if err != nil {
+			utils.Log.WithError(err).WithField("channel", n.Channel()).Error("Failed to load reminder template")
+			continue
+		}
+		s.templates[n.Channel()] = t
+	}
+	return s
+}
+
+// Run schedules and delivers due reminders every interval
+func (s *Scheduler) Run(interval time.Duration) {
+	utils.Log.WithFields(map[string]interface{}{
+		"offsets":   s.Offsets,
+		"notifiers": len(s.Notifiers),
+	}).Info("Reminder scheduler started")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.schedule()
+		s.scheduleVaccinations()
+		s.deliver()
+	}
+}
+
+// localTimestampFormat is how clinic-local times are passed to timestamp columns
+const localTimestampFormat = "2006-01-02 15:04:05"
+
+// clinicNow is the current time in CLINIC_TIMEZONE, which appointment dates and
+// times are recorded in; the database session's zone may differ
+func clinicNow() string {
+	return time.Now().In(utils.ClinicLocation()).Format(localTimestampFormat)
+}
+
+// schedule records one pending send per appointment, offset and channel.
+// Each offset covers the window down to the next smaller offset, so an
+// appointment booked late only gets the reminders that still make sense.
+func (s *Scheduler) schedule() {
+	for i, offset := range s.Offsets {
+		lower := 0
+		if i+1 < len(s.Offsets) {
+			lower = int(s.Offsets[i+1].Minutes())
+		}
+		upper := int(offset.Minutes())
+
+		for _, n := range s.Notifiers {
+			col, ok := recipientColumn[n.Channel()]
+			if !ok || s.templates[n.Channel()] == nil {
+				continue
+			}
+			result, err := db.DB.Exec(`INSERT INTO reminder_sends (appointment_id, offset_minutes, channel, recipient)
+				SELECT a.id, $1, $2, `+col+`
+				FROM appointments a
+				JOIN pets p ON p.id = a.pet_id
+				JOIN owners o ON o.id = p.owner_id
+				WHERE a.status <> 'cancelled' AND p.status <> 'deceased'
+				  AND (a.date + a.time) > $4::timestamp + $3 * INTERVAL '1 minute'
+				  AND (a.date + a.time) <= $4::timestamp + $1 * INTERVAL '1 minute'
+				  AND COALESCE(`+col+`, '') <> ''
+				ON CONFLICT (appointment_id, offset_minutes, channel) DO NOTHING`,
+				upper, n.Channel(), lower, clinicNow())
+			if err != nil {
+				utils.Log.WithError(err).Error("Failed to schedule reminders")
+				continue
+			}
+			if count, _ := result.RowsAffected(); count > 0 {
+				utils.Log.WithFields(map[string]interface{}{"offset": offset.String(), "channel": n.Channel(), "count": count}).Info("Reminders scheduled")
+			}
+		}
+	}
+}
+
+// scheduleVaccinations records one pending send per channel for each pet whose latest
+// dose of a vaccine falls due within VaccineLeadDays. Only active pets are reminded:
+// the family of a deceased, transferred or lost pet never gets vaccine reminders.
+func (s *Scheduler) scheduleVaccinations() {
+	if s.VaccineLeadDays == 0 {
+		return
+	}
+	for _, n := range s.Notifiers {
+		col, ok := recipientColumn[n.Channel()]
+		if !ok || s.templates[n.Channel()] == nil {
+			continue
+		}
+		result, err := db.DB.Exec(`INSERT INTO reminder_sends (vaccination_id, offset_minutes, channel, recipient)
+			SELECT l.id, $1 * 1440, $2, `+col+`
+			FROM (
+				SELECT DISTINCT ON (pet_id, vaccine_id) id, pet_id, next_due_date
+				FROM vaccinations
+				ORDER BY pet_id, vaccine_id, administered_on DESC, id DESC
+			) l
+			JOIN pets p ON p.id = l.pet_id
+			JOIN owners o ON o.id = p.owner_id
+			WHERE l.next_due_date BETWEEN CURRENT_DATE AND CURRENT_DATE + $1::int
+			  AND p.status = 'active'
+			  AND COALESCE(`+col+`, '') <> ''
+			ON CONFLICT (vaccination_id, offset_minutes, channel) DO NOTHING`,
+			s.VaccineLeadDays, n.Channel())
+		if err != nil {
+			utils.Log.WithError(err).Error("Failed to schedule vaccination reminders")
+			continue
+		}
+		if count, _ := result.RowsAffected(); count > 0 {
+			utils.Log.WithFields(map[string]interface{}{"channel": n.Channel(), "count": count}).Info("Vaccination reminders scheduled")
+		}
+	}
+}
+
+type dueSend struct {
+	id            int
+	appointmentID sql.NullInt64
+	vaccinationID sql.NullInt64
+	offsetMinutes int
+	channel       string
+	recipient     string
+	attempts      int
+}
+
+// deliver claims due sends and hands them to the matching notifier.
+// Claiming flips the row to 'sending' first so a reminder is never sent twice.
+func (s *Scheduler) deliver() {
+	rows, err := db.DB.Query(`UPDATE reminder_sends SET status='sending', attempts=attempts+1
+		WHERE id IN (
+			SELECT id FROM reminder_sends
+			WHERE status IN ('pending', 'failed') AND attempts < $1 AND next_attempt_at <= NOW()
+			ORDER BY id
+			LIMIT 100
+			FOR UPDATE SKIP LOCKED)
+		RETURNING id, appointment_id, vaccination_id, offset_minutes, channel, recipient, attempts`, s.MaxAttempts)
+	if err != nil {
+		utils.Log.WithError(err).Error("Failed to claim due reminders")
+		return
+	}
+
+	var due []dueSend
+	for rows.Next() {
+		var d dueSend
+		if err := rows.Scan(&d.id, &d.appointmentID, &d.vaccinationID, &d.offsetMinutes, &d.channel, &d.recipient, &d.attempts); err != nil {
+			utils.Log.WithError(err).Error("Error scanning due reminder")
+			continue
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+
+	notifiers := map[string]notify.Notifier{}
+	for _, n := range s.Notifiers {
+		notifiers[n.Channel()] = n
+	}
+
+	for _, d := range due {
+		s.send(d, notifiers[d.channel])
+	}
+}
+
+func (s *Scheduler) send(d dueSend, n notify.Notifier) {
+	log := utils.Log.WithFields(map[string]interface{}{
+		"reminder_id":    d.id,
+		"appointment_id": d.appointmentID.Int64,
+		"vaccination_id": d.vaccinationID.Int64,
+		"channel":        d.channel,
+		"attempt":        d.attempts,
+	})
+
+	var data templateData
+	var current bool
+	var err error
+	prefix := ""
+	if d.vaccinationID.Valid {
+		prefix = "vaccine_"
+		// only the latest dose of a vaccine still counts; a newer dose replaces the reminder
+		err = db.DB.QueryRow(`SELECT o.name, p.name, vc.name, v.next_due_date::text,
+				v.next_due_date >= CURRENT_DATE AND p.status = 'active' AND NOT EXISTS (
+					SELECT 1 FROM vaccinations n
+					WHERE n.pet_id = v.pet_id AND n.vaccine_id = v.vaccine_id AND n.administered_on > v.administered_on)
+			FROM vaccinations v
+			JOIN vaccines vc ON vc.id = v.vaccine_id
+			JOIN pets p ON p.id = v.pet_id
+			JOIN owners o ON o.id = p.owner_id
+			WHERE v.id = $1`, d.vaccinationID.Int64).
+			Scan(&data.OwnerName, &data.PetName, &data.Vaccine, &data.Date, &current)
+	} else {
+		err = db.DB.QueryRow(`SELECT o.name, p.name, a.date::text, to_char(a.time, 'HH24:MI'),
+				COALESCE(a.vet, ''), COALESCE(a.reason, ''),
+				(a.date + a.time) > $2::timestamp AND a.status <> 'cancelled' AND p.status <> 'deceased'
+			FROM appointments a
+			JOIN pets p ON p.id = a.pet_id
+			JOIN owners o ON o.id = p.owner_id
+			WHERE a.id = $1`, d.appointmentID.Int64, clinicNow()).
+			Scan(&data.OwnerName, &data.PetName, &data.Date, &data.Time, &data.Vet, &data.Reason, &current)
+	}
+	if err != nil && err != sql.ErrNoRows {
+		// a database hiccup is not a reason to drop the reminder; retry it later
+		s.retry(log, d, err)
+		return
+	}
+	if err == sql.ErrNoRows || !current || n == nil {
+		log.Warn("Skipping reminder: no longer current or channel disabled")
+		db.DB.Exec(`UPDATE reminder_sends SET status='skipped' WHERE id=$1`, d.id)
+		return
+	}
+	data.HoursLeft = d.offsetMinutes / 60
+
+	var subject, body strings.Builder
+	t := s.templates[d.channel]
+	err = t.ExecuteTemplate(&subject, prefix+"subject", data)
+	if err == nil {
+		err = t.ExecuteTemplate(&body, prefix+"body", data)
+	}
+	if err == nil {
+		err = n.Send(notify.Message{To: d.recipient, Subject: subject.String(), Body: body.String()})
+	}
+
+	if err != nil {
+		s.retry(log, d, err)
+		return
+	}
+
+	db.DB.Exec(`UPDATE reminder_sends SET status='sent', sent_at=NOW(), last_error=NULL WHERE id=$1`, d.id)
+	log.Info("Reminder sent")
+}
+
+// retry marks a send failed with a backoff; deliver picks it up again until MaxAttempts
+func (s *Scheduler) retry(log *logrus.Entry, d dueSend, err error) {
+	log.WithError(err).Warn("Reminder send failed, will retry")
+	db.DB.Exec(`UPDATE reminder_sends SET status='failed', last_error=$1,
+		next_attempt_at = NOW() + $2 * INTERVAL '1 second' WHERE id=$3`,
+		err.Error(), int(s.RetryDelay.Seconds())*d.attempts, d.id)
+}
@@ -0,0 +1,54 @@
+package reminders
+
+import (
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// default templates per channel; each defines "subject"/"body" for appointments
+// and "vaccine_subject"/"vaccine_body" for vaccinations falling due
+var defaultTemplates = map[string]string{
+	"email": `{{define "subject"}}Reminder: {{.PetName}}'s appointment on {{.Date}} at {{.Time}}{{end}}
+{{- define "body"}}Hello {{.OwnerName}},
+
+This is a reminder that {{.PetName}} has an appointment on {{.Date}} at {{.Time}}{{if .Vet}} with {{.Vet}}{{end}}.
+{{if .Reason}}Reason: {{.Reason}}
+{{end}}
+If you cannot make it, please cancel so the slot can be offered to someone else.
+
+Pet Clinic{{end}}
+{{- define "vaccine_subject"}}{{.PetName}}'s {{.Vaccine}} vaccination is due on {{.Date}}{{end}}
+{{- define "vaccine_body"}}Hello {{.OwnerName}},
+
+{{.PetName}}'s {{.Vaccine}} vaccination is due on {{.Date}}. Please book an appointment so {{.PetName}} stays protected.
+
+Pet Clinic{{end}}`,
+	"sms": `{{define "subject"}}{{end}}
+{{- define "body"}}Pet Clinic: {{.PetName}} has an appointment on {{.Date}} at {{.Time}}{{if .Vet}} with {{.Vet}}{{end}}.{{end}}
+{{- define "vaccine_subject"}}{{end}}
+{{- define "vaccine_body"}}Pet Clinic: {{.PetName}}'s {{.Vaccine}} vaccination is due on {{.Date}}. Please book an appointment.{{end}}`,
+}
+
+// templateData is what reminder templates can reference
+type templateData struct {
+	OwnerName string
+	PetName   string
+	Date      string
+	Time      string
+	Vet       string
+	Reason    string
+	Vaccine   string
+	HoursLeft int
+}
+
+// loadTemplate returns <dir>/<channel>.tmpl when present, else the built-in template
+func loadTemplate(dir, channel string) (*template.Template, error) {
+	if dir != "" {
+		path := filepath.Join(dir, channel+".tmpl")
+		if _, err := os.Stat(path); err == nil {
+			return template.ParseFiles(path)
+		}
+	}
+	return template.New(channel).Parse(defaultTemplates[channel])
+}
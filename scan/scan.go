@@ -0,0 +1,36 @@
+// Package scan passes uploaded files through a malware scanner before they are
+// stored. The bundled scanner speaks the clamd (ClamAV daemon) protocol.
+package scan
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+)
+
+// ErrTooLarge means the file is bigger than the scanner accepts (clamd's
+// StreamMaxLength); retrying will not help
+var ErrTooLarge = errors.New("file exceeds the scanner's size limit")
+
+// Result is the verdict for one file
+type Result struct {
+	Infected  bool
+	Signature string // name of the matched signature when Infected
+}
+
+// Scanner inspects a stream and reports whether it is infected. An error means
+// no verdict was reached; callers must not treat that as clean.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+}
+
+// FromEnv returns the scanner configured by CLAMD_ADDRESS ("tcp://host:3310",
+// "host:3310" or "unix:///var/run/clamav/clamd.ctl"), or nil when scanning is off
+func FromEnv() Scanner {
+	addr := os.Getenv("CLAMD_ADDRESS")
+	if addr == "" {
+		return nil
+	}
+	return NewClamd(addr)
+}
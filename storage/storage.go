@@ -0,0 +1,96 @@
+// Package storage keeps uploaded file contents in a BlobStore: a local directory
+// or an S3-compatible bucket (AWS S3, MinIO), chosen by configuration.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotExist is returned when a key is not in the store
+var ErrNotExist = errors.New("blob does not exist")
+
+// Info describes a stored blob
+type Info struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// BlobStore stores opaque blobs under server-generated keys such as "3f2a…" or
+// "thumbs/3f2a…". Put never replaces an existing key.
+type BlobStore interface {
+	// Put stores size bytes from r under key
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetRange reads length bytes starting at offset, for HTTP Range requests
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (Info, error)
+	Delete(ctx context.Context, key string) error
+	// List calls fn for every blob whose key starts with prefix
+	List(ctx context.Context, prefix string, fn func(Info) error) error
+}
+
+// ValidKey accepts lower-case keys of letters, digits, '-', '_', '.' and '/'
+// separated segments; no empty, "." or ".." segments
+func ValidKey(key string) bool {
+	if key == "" || len(key) > 512 {
+		return false
+	}
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return false
+		}
+		for _, c := range seg {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// New builds the named backend from the environment:
+//
+//	local  LOCAL_STORAGE_DIR (default "uploads")
+//	s3     S3_ENDPOINT, S3_REGION (default us-east-1), S3_BUCKET, S3_ACCESS_KEY, S3_SECRET_KEY, S3_PREFIX
+func New(backend string) (BlobStore, error) {
+	switch backend {
+	case "", "local":
+		dir := os.Getenv("LOCAL_STORAGE_DIR")
+		if dir == "" {
+			dir = "uploads"
+		}
+		return NewLocal(dir)
+	case "s3":
+		return NewS3(S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			Prefix:    os.Getenv("S3_PREFIX"),
+		})
+	}
+	return nil, fmt.Errorf("unknown storage backend %q (use local or s3)", backend)
+}
+
+var (
+	defaultOnce  sync.Once
+	defaultStore BlobStore
+	defaultErr   error
+)
+
+// Default returns the backend selected by STORAGE_BACKEND (default local)
+func Default() (BlobStore, error) {
+	defaultOnce.Do(func() {
+		defaultStore, defaultErr = New(os.Getenv("STORAGE_BACKEND"))
+	})
+	return defaultStore, defaultErr
+}
@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// ClinicLocation is the time zone appointment dates/times are recorded in (CLINIC_TIMEZONE, default UTC)
+func ClinicLocation() *time.Location {
+	name := os.Getenv("CLINIC_TIMEZONE")
+	if name == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		Log.WithError(err).WithField("timezone", name).Warn("Unknown CLINIC_TIMEZONE, falling back to UTC")
+		return time.UTC
+	}
+	return loc
+}
+
+// AppointmentDuration is the length of one appointment slot (APPOINTMENT_DURATION_MINUTES, default 30)
+func AppointmentDuration() time.Duration {
+	if m, err := strconv.Atoi(os.Getenv("APPOINTMENT_DURATION_MINUTES")); err == nil && m > 0 {
+		return time.Duration(m) * time.Minute
+	}
+	return 30 * time.Minute
+}